@@ -0,0 +1,304 @@
+package gena
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	serverName = "Linux/i686 UPnP/1.0 go-upnp-playground/0.0.1"
+	// maxTimeout is granted when a control point asks for more, or for an
+	// infinite subscription.
+	maxTimeout = 1800 * time.Second
+)
+
+// A Variable is an evented state variable.
+type Variable struct {
+	Name  string
+	Value string
+	// Moderation is the minimum interval between two events of a moderated
+	// variable, such as 2 seconds for SystemUpdateID. Values set meanwhile
+	// are evented once it has passed.
+	Moderation time.Duration
+	// Accumulate combines the value set since the last event with the next
+	// one, like ContainerUpdateIDs collects the updates of containers.
+	// Without it the next value replaces the previous one.
+	Accumulate func(value string, next string) string
+
+	evented     bool // Value has been evented, the next value starts over
+	pending     bool // Value waits for the moderation interval to pass
+	lastEvented time.Time
+}
+
+// A Service manages the event subscriptions of one UPnP service and notifies
+// subscribers when its state variables change.
+type Service struct {
+	mu            sync.Mutex
+	variables     []*Variable
+	subscriptions map[string]*subscription
+
+	moderationTimer *time.Timer
+	moderationDue   time.Time
+}
+
+func NewService(variables ...Variable) *Service {
+	s := &Service{
+		subscriptions: make(map[string]*subscription),
+	}
+	for i := range variables {
+		v := variables[i]
+		s.variables = append(s.variables, &v)
+	}
+	return s
+}
+
+func (s *Service) variable(name string) *Variable {
+	for _, v := range s.variables {
+		if v.Name == name {
+			return v
+		}
+	}
+	log.Fatalf("gena: unknown state variable %s", name)
+	return nil
+}
+
+// SetVariable changes the value of a state variable and notifies subscribers,
+// immediately or after the moderation interval for moderated variables.
+func (s *Service) SetVariable(name string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.variable(name)
+	if v.Accumulate != nil && !v.evented && v.Value != "" && value != "" {
+		v.Value = v.Accumulate(v.Value, value)
+	} else {
+		v.Value = value
+	}
+	v.evented = false
+	if v.Moderation == 0 {
+		s.notify([]*Variable{v})
+		return
+	}
+	v.pending = true
+	s.flushModerated()
+}
+
+// flushModerated events together the pending variables whose moderation
+// interval has passed, and sets the timer for the others. s.mu must be held.
+func (s *Service) flushModerated() {
+	now := time.Now()
+	var due []*Variable
+	var next time.Time
+	for _, v := range s.variables {
+		if !v.pending {
+			continue
+		}
+		at := v.lastEvented.Add(v.Moderation)
+		if !at.After(now) {
+			v.pending = false
+			v.lastEvented = now
+			due = append(due, v)
+			continue
+		}
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	s.notify(due)
+	if next.IsZero() || s.moderationTimer != nil && !next.Before(s.moderationDue) {
+		return
+	}
+	if s.moderationTimer != nil {
+		s.moderationTimer.Stop()
+	}
+	s.moderationDue = next
+	var timer *time.Timer
+	timer = time.AfterFunc(next.Sub(now), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.moderationTimer != timer {
+			// Replaced by an earlier one.
+			return
+		}
+		s.moderationTimer = nil
+		s.flushModerated()
+	})
+	s.moderationTimer = timer
+}
+
+// notify queues an event with the current values of variables to every
+// subscriber. s.mu must be held.
+func (s *Service) notify(variables []*Variable) {
+	if len(variables) == 0 {
+		return
+	}
+	body := marshalPropertySet(variables)
+	for _, v := range variables {
+		v.evented = true
+	}
+	for _, sub := range s.subscriptions {
+		sub.send(body)
+	}
+}
+
+func (s *Service) unsubscribe(sid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub, ok := s.subscriptions[sid]; ok {
+		sub.close()
+		delete(s.subscriptions, sid)
+	}
+}
+
+// interfaceAddrs returns the addresses of the network interfaces. Tests
+// replace it.
+var interfaceAddrs = net.InterfaceAddrs
+
+// isLocal reports whether host is a link-local address or one in the subnet of
+// a network interface. Events are not sent anywhere else.
+func isLocal(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLinkLocalUnicast() {
+		return true
+	}
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		log.Printf("gena: could not list interface addresses: %s", err)
+		return false
+	}
+	for _, addr := range addrs {
+		if network, ok := addr.(*net.IPNet); ok && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCallback parses a CALLBACK header such as
+// "<http://192.168.0.2:49152/event/1><http://192.168.0.2:49153/event/1>".
+// Callbacks must be at local addresses.
+func parseCallback(header string) ([]*url.URL, error) {
+	var urls []*url.URL
+	for _, field := range strings.Split(header, ">") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.HasPrefix(field, "<") {
+			return nil, fmt.Errorf("malformed callback %q", header)
+		}
+		u, err := url.Parse(field[1:])
+		if err != nil || u.Scheme != "http" {
+			return nil, fmt.Errorf("unsupported callback URL %q", field[1:])
+		}
+		if !isLocal(u.Hostname()) {
+			return nil, fmt.Errorf("callback URL %q is not on the local network", field[1:])
+		}
+		urls = append(urls, u)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("empty callback")
+	}
+	return urls, nil
+}
+
+// parseTimeout parses a TIMEOUT header such as "Second-1800".
+func parseTimeout(header string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimPrefix(header, "Second-"))
+	if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxTimeout {
+		return maxTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func writeSubscribeResponse(w http.ResponseWriter, sid string, timeout time.Duration) {
+	// Putting headers in here avoids them being title-cased.
+	h := w.Header()
+	h["SERVER"] = []string{serverName}
+	h["SID"] = []string{sid}
+	h["TIMEOUT"] = []string{fmt.Sprintf("Second-%d", int(timeout/time.Second))}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Service) subscribe(w http.ResponseWriter, r *http.Request) {
+	sid, callback, nt := r.Header.Get("SID"), r.Header.Get("CALLBACK"), r.Header.Get("NT")
+	timeout := parseTimeout(r.Header.Get("TIMEOUT"))
+
+	if sid != "" {
+		// Renewal
+		if callback != "" || nt != "" {
+			http.Error(w, "SID header field and one of NT or CALLBACK header fields are present", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		sub, ok := s.subscriptions[sid]
+		if ok {
+			sub.renew(timeout)
+		}
+		s.mu.Unlock()
+		if !ok {
+			http.Error(w, "no such subscription", http.StatusPreconditionFailed)
+			return
+		}
+		writeSubscribeResponse(w, sid, timeout)
+		return
+	}
+
+	if nt != "upnp:event" {
+		http.Error(w, "NT must be upnp:event", http.StatusPreconditionFailed)
+		return
+	}
+	urls, err := parseCallback(callback)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	sid = fmt.Sprintf("uuid:%s", uuid.New())
+	writeSubscribeResponse(w, sid, timeout)
+	// The initial event must not reach the subscriber before the response.
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := newSubscription(s, sid, urls, timeout)
+	s.subscriptions[sid] = sub
+	sub.send(marshalPropertySet(s.variables))
+}
+
+// ServeHTTP handles SUBSCRIBE and UNSUBSCRIBE requests to the eventSubURL.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "SUBSCRIBE":
+		s.subscribe(w, r)
+	case "UNSUBSCRIBE":
+		sid := r.Header.Get("SID")
+		if r.Header.Get("CALLBACK") != "" || r.Header.Get("NT") != "" {
+			http.Error(w, "SID header field and one of NT or CALLBACK header fields are present", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		_, ok := s.subscriptions[sid]
+		s.mu.Unlock()
+		if !ok {
+			http.Error(w, "no such subscription", http.StatusPreconditionFailed)
+			return
+		}
+		s.unsubscribe(sid)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
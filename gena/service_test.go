@@ -0,0 +1,234 @@
+package gena
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	interfaceAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.IPv4(127, 0, 0, 1), Mask: net.CIDRMask(8, 32)},
+			&net.IPNet{IP: net.IPv4(192, 168, 0, 2), Mask: net.CIDRMask(24, 32)},
+		}, nil
+	}
+	m.Run()
+}
+
+func TestParseCallback(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		n      int
+	}{
+		{"<http://127.0.0.1:49152/event>", 1},
+		{"<http://192.168.0.9:49152/event><http://169.254.10.1/event>", 2},
+		{"<http://[fe80::1]:49152/event>", 1},
+		{"<http://192.168.1.9:49152/event>", 0},
+		{"<http://10.0.0.1/event>", 0},
+		{"<http://localhost/event>", 0},
+		{"<http://example.com/event>", 0},
+		{"<https://127.0.0.1/event>", 0},
+		{"<http://127.0.0.1/event><http://10.0.0.1/event>", 0},
+		{"http://127.0.0.1/event", 0},
+		{"", 0},
+	} {
+		urls, err := parseCallback(test.header)
+		if test.n == 0 {
+			if err == nil {
+				t.Errorf("%q: want error, got %v", test.header, urls)
+			}
+			continue
+		}
+		if err != nil || len(urls) != test.n {
+			t.Errorf("%q: got %v, %v", test.header, urls, err)
+		}
+	}
+}
+
+type event struct {
+	sid, seq, body string
+	at             time.Time
+}
+
+// newSubscriber returns a callback server passing on the events it receives.
+func newSubscriber(t *testing.T) (*httptest.Server, <-chan event) {
+	events := make(chan event, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "NOTIFY" || r.Header.Get("NT") != "upnp:event" || r.Header.Get("NTS") != "upnp:propchange" {
+			t.Errorf("got %s NT %q NTS %q", r.Method, r.Header.Get("NT"), r.Header.Get("NTS"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		events <- event{r.Header.Get("SID"), r.Header.Get("SEQ"), string(body), time.Now()}
+	}))
+	t.Cleanup(server.Close)
+	return server, events
+}
+
+func nextEvent(t *testing.T, events <-chan event) event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return event{}
+}
+
+func noEvent(t *testing.T, events <-chan event, wait time.Duration) {
+	t.Helper()
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %+v", e)
+	case <-time.After(wait):
+	}
+}
+
+// request sends a SUBSCRIBE or UNSUBSCRIBE request with headers to s.
+func request(s *Service, method string, headers map[string]string) *http.Response {
+	r := httptest.NewRequest(method, "/event", nil)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w.Result()
+}
+
+// header returns the header field name of res, which is written in upper case
+// as UPnP clients expect it.
+func header(res *http.Response, name string) string {
+	if values := res.Header[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func subscribe(t *testing.T, s *Service, callback string) string {
+	t.Helper()
+	res := request(s, "SUBSCRIBE", map[string]string{"NT": "upnp:event", "CALLBACK": "<" + callback + ">", "TIMEOUT": "Second-60"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("SUBSCRIBE: %s", res.Status)
+	}
+	if timeout := header(res, "TIMEOUT"); timeout != "Second-60" {
+		t.Errorf("granted TIMEOUT %q", timeout)
+	}
+	sid := header(res, "SID")
+	if !strings.HasPrefix(sid, "uuid:") {
+		t.Fatalf("got SID %q", sid)
+	}
+	t.Cleanup(func() { s.unsubscribe(sid) })
+	return sid
+}
+
+func TestSubscribe(t *testing.T) {
+	s := NewService(Variable{Name: "A", Value: "1"}, Variable{Name: "B", Value: "2"})
+	server, events := newSubscriber(t)
+	sid := subscribe(t, s, server.URL+"/event")
+
+	initial := nextEvent(t, events)
+	if initial.sid != sid || initial.seq != "0" || !strings.Contains(initial.body, "<A>1</A>") || !strings.Contains(initial.body, "<B>2</B>") {
+		t.Errorf("initial event %+v", initial)
+	}
+	s.SetVariable("B", "3")
+	e := nextEvent(t, events)
+	if e.seq != "1" || !strings.Contains(e.body, "<B>3</B>") || strings.Contains(e.body, "<A>") {
+		t.Errorf("event %+v", e)
+	}
+
+	for _, headers := range []map[string]string{
+		{"CALLBACK": "<" + server.URL + "/event>"},
+		{"NT": "upnp:event"},
+		{"NT": "upnp:event", "CALLBACK": "<http://10.0.0.1/event>"},
+	} {
+		if res := request(s, "SUBSCRIBE", headers); res.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("SUBSCRIBE with %v: %s", headers, res.Status)
+		}
+	}
+}
+
+func TestRenew(t *testing.T) {
+	s := NewService(Variable{Name: "A", Value: "1"})
+	server, events := newSubscriber(t)
+	sid := subscribe(t, s, server.URL+"/event")
+	nextEvent(t, events)
+
+	res := request(s, "SUBSCRIBE", map[string]string{"SID": sid, "TIMEOUT": "Second-120"})
+	if res.StatusCode != http.StatusOK || header(res, "SID") != sid || header(res, "TIMEOUT") != "Second-120" {
+		t.Errorf("renewal: %s SID %q TIMEOUT %q", res.Status, header(res, "SID"), header(res, "TIMEOUT"))
+	}
+	if res := request(s, "SUBSCRIBE", map[string]string{"SID": "uuid:unknown"}); res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("renewing an unknown subscription: %s", res.Status)
+	}
+	if res := request(s, "SUBSCRIBE", map[string]string{"SID": sid, "NT": "upnp:event"}); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("renewing with NT: %s", res.Status)
+	}
+	// A renewal sends no initial event.
+	noEvent(t, events, 100*time.Millisecond)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	s := NewService(Variable{Name: "A", Value: "1"})
+	server, events := newSubscriber(t)
+	sid := subscribe(t, s, server.URL+"/event")
+	nextEvent(t, events)
+
+	if res := request(s, "UNSUBSCRIBE", map[string]string{"SID": sid, "CALLBACK": "<" + server.URL + "/event>"}); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("UNSUBSCRIBE with CALLBACK: %s", res.Status)
+	}
+	if res := request(s, "UNSUBSCRIBE", map[string]string{"SID": sid}); res.StatusCode != http.StatusOK {
+		t.Fatalf("UNSUBSCRIBE: %s", res.Status)
+	}
+	s.SetVariable("A", "2")
+	noEvent(t, events, 100*time.Millisecond)
+	if res := request(s, "UNSUBSCRIBE", map[string]string{"SID": sid}); res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("UNSUBSCRIBE again: %s", res.Status)
+	}
+	if res := request(s, "SUBSCRIBE", map[string]string{"SID": sid}); res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("renewing after UNSUBSCRIBE: %s", res.Status)
+	}
+}
+
+func TestModeration(t *testing.T) {
+	const moderation = 200 * time.Millisecond
+	join := func(value string, next string) string { return value + "," + next }
+	s := NewService(
+		Variable{Name: "Slow", Moderation: moderation, Accumulate: join},
+		Variable{Name: "Fast", Moderation: moderation / 4},
+	)
+	server, events := newSubscriber(t)
+	subscribe(t, s, server.URL+"/event")
+	nextEvent(t, events)
+
+	s.SetVariable("Slow", "a")
+	first := nextEvent(t, events)
+	if !strings.Contains(first.body, "<Slow>a</Slow>") {
+		t.Errorf("first event %+v", first)
+	}
+	// Values set within the interval are evented together once it has
+	// passed, accumulated or replaced.
+	s.SetVariable("Slow", "b")
+	s.SetVariable("Fast", "x")
+	if e := nextEvent(t, events); !strings.Contains(e.body, "<Fast>x</Fast>") || strings.Contains(e.body, "<Slow>") {
+		t.Errorf("first event of the faster variable %+v", e)
+	}
+	s.SetVariable("Fast", "y")
+	s.SetVariable("Fast", "z")
+	s.SetVariable("Slow", "c")
+	if e := nextEvent(t, events); !strings.Contains(e.body, "<Fast>z</Fast>") || strings.Contains(e.body, "<Slow>") {
+		t.Errorf("moderated event of the faster variable %+v", e)
+	}
+	slow := nextEvent(t, events)
+	if !strings.Contains(slow.body, "<Slow>b,c</Slow>") || strings.Contains(slow.body, "<Fast>") {
+		t.Errorf("moderated event %+v", slow)
+	}
+	if interval := slow.at.Sub(first.at); interval < moderation-10*time.Millisecond {
+		t.Errorf("moderated events %s apart", interval)
+	}
+	noEvent(t, events, moderation)
+}
@@ -0,0 +1,148 @@
+package gena
+
+import (
+	"bytes"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// queueSize is the number of undelivered events a subscriber may fall
+	// behind before it is dropped.
+	queueSize      = 32
+	deliveryTries  = 3
+	retryInterval  = 2 * time.Second
+	notifyDeadline = 10 * time.Second
+)
+
+var notifyClient = &http.Client{Timeout: notifyDeadline}
+
+type subscription struct {
+	service  *Service
+	sid      string
+	callback []*url.URL
+	expiry   *time.Timer
+	queue    chan []byte
+	seq      uint32
+}
+
+func newSubscription(service *Service, sid string, callback []*url.URL, timeout time.Duration) *subscription {
+	sub := &subscription{
+		service:  service,
+		sid:      sid,
+		callback: callback,
+		queue:    make(chan []byte, queueSize),
+	}
+	sub.expiry = time.AfterFunc(timeout, func() {
+		log.Printf("gena: subscription %s expired", sid)
+		service.unsubscribe(sid)
+	})
+	go sub.deliver()
+	return sub
+}
+
+func (sub *subscription) renew(timeout time.Duration) {
+	sub.expiry.Reset(timeout)
+}
+
+// send queues an event. Service.mu must be held, which keeps events in order.
+func (sub *subscription) send(body []byte) {
+	select {
+	case sub.queue <- body:
+	default:
+		log.Printf("gena: subscriber %s is not keeping up, dropping subscription", sub.sid)
+		sub.close()
+		delete(sub.service.subscriptions, sub.sid)
+	}
+}
+
+// close stops delivery. Service.mu must be held.
+func (sub *subscription) close() {
+	sub.expiry.Stop()
+	close(sub.queue)
+}
+
+// deliver sends queued events one by one, so that subscribers receive them
+// in SEQ order.
+func (sub *subscription) deliver() {
+	for body := range sub.queue {
+		seq := sub.seq
+		// SEQ wraps to 1, as 0 is reserved for the initial event.
+		if sub.seq == ^uint32(0) {
+			sub.seq = 1
+		} else {
+			sub.seq++
+		}
+		for try := 0; try < deliveryTries; try++ {
+			if try > 0 {
+				time.Sleep(retryInterval)
+			}
+			if sub.notify(seq, body) {
+				break
+			}
+		}
+	}
+}
+
+// notify tries each callback URL in turn and reports whether one of them
+// accepted the event.
+func (sub *subscription) notify(seq uint32, body []byte) bool {
+	for _, u := range sub.callback {
+		req, err := http.NewRequest("NOTIFY", u.String(), bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		// Putting headers in here avoids them being title-cased.
+		req.Header = http.Header{
+			"CONTENT-TYPE": {`text/xml; charset="utf-8"`},
+			"NT":           {"upnp:event"},
+			"NTS":          {"upnp:propchange"},
+			"SID":          {sub.sid},
+			"SEQ":          {strconv.FormatUint(uint64(seq), 10)},
+		}
+		res, err := notifyClient.Do(req)
+		if err != nil {
+			log.Printf("gena: NOTIFY %s SEQ %d to %s failed: %s", sub.sid, seq, u, err)
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			return true
+		}
+		log.Printf("gena: NOTIFY %s SEQ %d to %s failed: %s", sub.sid, seq, u, res.Status)
+	}
+	return false
+}
+
+type property struct {
+	XMLName  xml.Name `xml:"e:property"`
+	Variable struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	}
+}
+
+type propertySet struct {
+	XMLName    xml.Name `xml:"e:propertyset"`
+	Namespace  string   `xml:"xmlns:e,attr"`
+	Properties []property
+}
+
+func marshalPropertySet(variables []*Variable) []byte {
+	set := propertySet{Namespace: "urn:schemas-upnp-org:event-1-0"}
+	for _, v := range variables {
+		var p property
+		p.Variable.XMLName.Local = v.Name
+		p.Variable.Value = v.Value
+		set.Properties = append(set.Properties, p)
+	}
+	data, err := xml.Marshal(set)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return append([]byte(xml.Header), data...)
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"go-upnp-playground/service"
+	"go-upnp-playground/service/contentdirectory"
 	"go-upnp-playground/ssdp"
 	"log"
 	"net"
 
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/google/uuid"
@@ -29,7 +32,33 @@ func localIP() (net.IP, error) {
 	return nil, errors.New("could not get local IP addres")
 }
 
+// dirsFlag is a flag which may be given more than once.
+type dirsFlag []string
+
+func (f *dirsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *dirsFlag) Set(dir string) error {
+	*f = append(*f, dir)
+	return nil
+}
+
 func main() {
+	allowDestroyObject := flag.Bool("allow-destroy-object", false, "let clients delete recordings from EPGStation with DestroyObject")
+	useEPGStation := flag.Bool("epgstation", true, "serve the recordings and schedules of EPGStation on port 8888 of this host")
+	mirakurunURL := flag.String("mirakurun", "", "serve the live channels of the Mirakurun at this URL, such as http://localhost:40772")
+	stateDir := flag.String("state-dir", ".", "keep the journal, the tree, bookmarks, favorites and looked up metadata in this directory")
+	var mediaDirs dirsFlag
+	flag.Var(&mediaDirs, "media-dir", "serve the video, audio and image files under this directory besides the recordings; may be given more than once")
+	flag.Parse()
+	contentdirectory.AllowDestroyObject = *allowDestroyObject
+	contentdirectory.StateDir = *stateDir
+	service.UseEPGStation = *useEPGStation
+	ssdp.ScheduledRecording = *useEPGStation
+	service.MirakurunURL = *mirakurunURL
+	service.MediaDirs = mediaDirs
+
 	deviceUUID := uuid.New()
 	localIP, err := localIP()
 	server := service.NewServer(deviceUUID, localIP)
@@ -59,7 +88,7 @@ func main() {
 		errSsdpAdvRes <- ssdpadv.Serve()
 	}()
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -0,0 +1,146 @@
+package contentdirectory
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// combinedSource is the contents of several sources as one. Requests for a
+// content or a resource go to the source which last listed it.
+type combinedSource struct {
+	sources []ContentSource
+
+	mu              sync.Mutex
+	contentSources  map[string]ContentSource
+	resourceSources map[string]ContentSource
+}
+
+// CombineSources returns a ContentSource listing the contents of every source.
+// The IDs of contents, resources and folders must not collide between them;
+// a content listed twice is left out the second time.
+func CombineSources(sources ...ContentSource) ContentSource {
+	if len(sources) == 1 {
+		return sources[0]
+	}
+	return &combinedSource{sources: sources}
+}
+
+// Contents fails if any source fails, as leaving the contents of one out would
+// remove them from the tree.
+func (c *combinedSource) Contents(ctx context.Context) ([]Content, error) {
+	var contents []Content
+	contentSources := make(map[string]ContentSource)
+	resourceSources := make(map[string]ContentSource)
+	for _, source := range c.sources {
+		sourceContents, err := source.Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, content := range sourceContents {
+			if _, ok := contentSources[content.ID]; ok {
+				log.Printf("leaving out content %s listed by two sources", content.ID)
+				continue
+			}
+			contentSources[content.ID] = source
+			for _, resource := range content.Resources {
+				resourceSources[resource.ID] = source
+			}
+			contents = append(contents, content)
+		}
+	}
+	c.mu.Lock()
+	c.contentSources, c.resourceSources = contentSources, resourceSources
+	c.mu.Unlock()
+	return contents, nil
+}
+
+// contentSource returns the source of a content, or nil if none listed it.
+func (c *combinedSource) contentSource(contentID string) ContentSource {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.contentSources[contentID]
+}
+
+// resourceSource returns the source of a resource, or nil if none listed it.
+func (c *combinedSource) resourceSource(resourceID string) ContentSource {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resourceSources[resourceID]
+}
+
+func (c *combinedSource) Probe(ctx context.Context, resource Resource) (Probe, error) {
+	source := c.resourceSource(resource.ID)
+	if source == nil {
+		return Probe{}, ErrNoSuchObject
+	}
+	return source.Probe(ctx, resource)
+}
+
+func (c *combinedSource) ServeResource(w http.ResponseWriter, r *http.Request, resourceID string) {
+	source := c.resourceSource(resourceID)
+	if source == nil {
+		http.NotFound(w, r)
+		return
+	}
+	source.ServeResource(w, r, resourceID)
+}
+
+func (c *combinedSource) Delete(ctx context.Context, contentID string) error {
+	source := c.contentSource(contentID)
+	if source == nil {
+		return ErrNoSuchObject
+	}
+	return source.Delete(ctx, contentID)
+}
+
+// SearchKeyword asks every source which is a KeywordSearcher. The contents of
+// the others are still matched by title.
+func (c *combinedSource) SearchKeyword(ctx context.Context, keyword string) ([]string, error) {
+	var ids []string
+	for _, source := range c.sources {
+		searcher, ok := source.(KeywordSearcher)
+		if !ok {
+			continue
+		}
+		sourceIDs, err := searcher.SearchKeyword(ctx, keyword)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, sourceIDs...)
+	}
+	return ids, nil
+}
+
+// Changes tells of the changes of every source which is a ChangeNotifier.
+func (c *combinedSource) Changes(ctx context.Context) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	for _, source := range c.sources {
+		notifier, ok := source.(ChangeNotifier)
+		if !ok {
+			continue
+		}
+		sourceChanges := notifier.Changes(ctx)
+		if sourceChanges == nil {
+			continue
+		}
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case _, ok := <-sourceChanges:
+					if !ok {
+						return
+					}
+					select {
+					case changes <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}()
+	}
+	return changes
+}
@@ -1,184 +1,102 @@
 package contentdirectory
 
 import (
-	"context"
 	"encoding/xml"
-	"fmt"
-	"go-upnp-playground/epgstation"
+	"errors"
 	"log"
-	"time"
+	"os"
+	"path/filepath"
 )
 
-var serviceURLBase string
-var videoFileIdDurationMap map[epgstation.VideoFileId]time.Duration
-var lastRecordedTotal int
-
-var genreIdNameMap = map[epgstation.ProgramGenreLv1]string{
-	0x0: "ニュース・報道",
-	0x1: "スポーツ",
-	0x2: "情報・ワイドショー",
-	0x3: "ドラマ",
-	0x4: "音楽",
-	0x5: "バラエティ",
-	0x6: "映画",
-	0x7: "アニメ・特撮",
-	0x8: "ドキュメンタリー・教養",
-	0x9: "劇場・公演",
-	0xa: "趣味・教育",
-	0xb: "福祉",
-	0xc: "予備",
-	0xd: "予備",
-	0xe: "拡張",
-	0xf: "その他",
-}
+var (
+	ErrNoSuchObject    = errors.New("no such object")
+	ErrNoSuchContainer = errors.New("no such container")
+)
 
-func watchEPGStationForSetup() {
-	for {
-		time.Sleep(1 * time.Minute)
-		res, err := epgstation.EPGStation.GetRecordedWithResponse(context.Background(), &epgstation.GetRecordedParams{
-			IsHalfWidth: false,
-		})
-		if err == nil && res.JSON200.Total != lastRecordedTotal {
-			Setup(serviceURLBase)
-		}
-	}
+// StateDir is the directory of the files kept across restarts, such as the
+// journal and the bookmarks.
+var StateDir = "."
+
+// statePath returns the path of a file in StateDir.
+func statePath(name string) string {
+	return filepath.Join(StateDir, name)
 }
 
+var serviceURLBase string
+var updateListeners []func(systemUpdateID int, containers []ContainerUpdate, lastChange string)
+
+// Setup builds the tree from the Source and publishes it once complete.
+// RunSync keeps it up to date afterwards.
 func Setup(ServiceURLBase string) {
 	log.Println("Setup ContentDirectory start")
-	serviceURLBase = ServiceURLBase
-
-	rootContainer := NewContainer("0", nil, "Root")
-	recordedContainer := setupRecordedContainer(rootContainer)
-	setupGenresContainer(rootContainer)
-	setupChannelsContainer(rootContainer)
-	setupRulesContainer(rootContainer)
-
-	log.Printf("Setup ContentDirectory complete. %d items found", recordedContainer.ChildCount)
-
-	go watchEPGStationForSetup()
-}
-
-func setupRecordedContainer(parent *Container) *Container {
-	recordedContainer := NewContainer("01", parent, "録画済み")
-	res, err := epgstation.EPGStation.GetRecordedWithResponse(context.Background(), &epgstation.GetRecordedParams{
-		IsHalfWidth: false,
-	})
-	if err != nil {
-		log.Fatal(err)
+	if Source == nil {
+		log.Fatal("no content source")
 	}
-	lastRecordedTotal = res.JSON200.Total
-	videoFileIdDurationMap = make(map[epgstation.VideoFileId]time.Duration)
-	for _, recordedItem := range res.JSON200.Records {
-		for _, videoFile := range *recordedItem.VideoFiles {
-			res, err := epgstation.EPGStation.GetVideosVideoFileIdDurationWithResponse(context.Background(), epgstation.PathVideoFileId(videoFile.Id))
-			if err != nil {
-				log.Fatal(err)
-			}
-			videoFileIdDurationMap[videoFile.Id] = time.Duration(res.JSON200.Duration * float32(time.Second))
-		}
+	if err := os.MkdirAll(StateDir, 0755); err != nil {
+		log.Fatal(err)
 	}
-	for _, recordedItem := range res.JSON200.Records {
-		NewItem(recordedContainer, &recordedItem, videoFileIdDurationMap)
+	writeMu.Lock()
+	serviceURLBase = ServiceURLBase
+	loadBookmarks()
+	loadFavorites()
+	loadMetadata()
+	if loadJournal() {
+		loadTree()
 	}
-	return recordedContainer
-}
+	writeMu.Unlock()
 
-func setupGenresContainer(parent *Container) *Container {
-	genresContainer := NewContainer("02", parent, "ジャンル別")
-	res, err := epgstation.EPGStation.GetRecordedOptionsWithResponse(context.Background())
-	if err != nil {
+	if err := Sync(); err != nil {
 		log.Fatal(err)
 	}
-	for _, genre := range res.JSON200.Genres {
-		genreContainer := NewContainer(ObjectID(fmt.Sprintf("02%d", int(genre.Genre))), genresContainer, genreIdNameMap[genre.Genre])
-		genre := epgstation.QueryProgramGenre(genre.Genre)
-		res, err := epgstation.EPGStation.GetRecordedWithResponse(context.Background(), &epgstation.GetRecordedParams{
-			IsHalfWidth: true,
-			Genre:       &genre,
-		})
-		if err != nil {
-			log.Fatal(err)
-		}
-		for _, recordedItem := range res.JSON200.Records {
-			NewItem(genreContainer, &recordedItem, videoFileIdDurationMap)
+	items := 0
+	for id := range contentContainers {
+		if container, ok := load().get(id).(*Container); ok {
+			items += container.ChildCount
 		}
 	}
-	return genresContainer
+	log.Printf("Setup ContentDirectory complete. %d items found", items)
 }
 
-func setupChannelsContainer(parent *Container) *Container {
-	channelsContainer := NewContainer("03", parent, "チャンネル別")
-	resChannelInfo, err := epgstation.EPGStation.GetChannelsWithResponse(context.Background())
-	if err != nil {
-		log.Fatal(err)
-	}
-	channelIdChannelItemMap := make(map[epgstation.ChannelId]epgstation.ChannelItem)
-	for _, channelItem := range *resChannelInfo.JSON200 {
-		channelIdChannelItemMap[channelItem.Id] = channelItem
-	}
+// A ContainerUpdate is a container whose children changed, along with its new
+// containerUpdateID.
+type ContainerUpdate struct {
+	Id       ObjectID
+	UpdateID int
+}
 
-	res, err := epgstation.EPGStation.GetRecordedOptionsWithResponse(context.Background())
-	if err != nil {
-		log.Fatal(err)
-	}
-	for _, channel := range res.JSON200.Channels {
-		channelName := channelIdChannelItemMap[channel.ChannelId].HalfWidthName
-		channelContainer := NewContainer(ObjectID(fmt.Sprintf("03%d", int(channel.ChannelId))), channelsContainer, channelName)
-		queryChannelId := epgstation.QueryChannelId(channel.ChannelId)
-		res, err := epgstation.EPGStation.GetRecordedWithResponse(context.Background(), &epgstation.GetRecordedParams{
-			IsHalfWidth: false,
-			ChannelId:   &queryChannelId,
-		})
-		if err != nil {
-			log.Fatal(err)
-		}
-		for _, recordedItem := range res.JSON200.Records {
-			NewItem(channelContainer, &recordedItem, videoFileIdDurationMap)
-		}
-	}
-	return channelsContainer
+// OnUpdate registers f to be called whenever the content directory changes,
+// with the new SystemUpdateID, the containers whose children changed and the
+// LastChange of the changes.
+func OnUpdate(f func(systemUpdateID int, containers []ContainerUpdate, lastChange string)) {
+	updateListeners = append(updateListeners, f)
 }
 
-func setupRulesContainer(parent *Container) *Container {
-	rulesContainer := NewContainer("04", parent, "ルール別")
-	resRulesInfo, err := epgstation.EPGStation.GetRulesKeywordWithResponse(context.Background(), &epgstation.GetRulesKeywordParams{})
-	if err != nil {
-		log.Fatal(err)
-	}
-	for _, ruleItem := range resRulesInfo.JSON200.Items {
-		queryRuleId := epgstation.QueryRuleId(ruleItem.Id)
-		res, err := epgstation.EPGStation.GetRecordedWithResponse(context.Background(), &epgstation.GetRecordedParams{
-			IsHalfWidth: false,
-			RuleId:      &queryRuleId,
-		})
-		if err != nil {
-			log.Fatal(err)
-		}
-		if res.JSON200.Total > 0 {
-			ruleContainer := NewContainer(ObjectID(fmt.Sprintf("04%d", int(ruleItem.Id))), rulesContainer, ruleItem.Keyword)
-			for _, recordedItem := range res.JSON200.Records {
-				NewItem(ruleContainer, &recordedItem, videoFileIdDurationMap)
-			}
+func notifyUpdate(s *snapshot, containerIDs []ObjectID) {
+	var containers []ContainerUpdate
+	for _, id := range containerIDs {
+		if container, ok := s.get(id).(*Container); ok {
+			containers = append(containers, ContainerUpdate{id, container.ContainerUpdateID})
 		}
 	}
-	return rulesContainer
-}
-
-func GetRecordedTotal() int {
-	res, err := epgstation.EPGStation.GetRecordedWithResponse(context.Background(), &epgstation.GetRecordedParams{
-		IsHalfWidth: false,
-	})
-	if err != nil {
-		log.Fatal(err)
+	lastChange := flushChanges()
+	for _, f := range updateListeners {
+		f(GetSystemUpdateID(), containers, lastChange)
 	}
-	return res.JSON200.Total
 }
 
-func MarshalMetadata(objectID string) string {
-	object := registory[ObjectID(objectID)]
+// Version is the version of ContentDirectory served. Clients may invoke any
+// version up to it.
+const Version = 3
+
+func marshalObjects(objects []interface{}, filter string, version int) string {
 	wrapper := DIDLLite{}
-	wrapper.Objects = append(wrapper.Objects, &object)
+	f := ParseFilter(filter, version)
+	for _, object := range objects {
+		if !f.all || version < trackChangesVersion {
+			object = filteredObject{object, f}
+		}
+		wrapper.Objects = append(wrapper.Objects, object)
+	}
 	data, err := xml.Marshal(wrapper)
 	if err != nil {
 		log.Fatal(err)
@@ -186,32 +104,66 @@ func MarshalMetadata(objectID string) string {
 	return string(data)
 }
 
-func MarshalDirectChildren(objectID string, StartingIndex int, RequestedCount int) string {
-	object := registory[ObjectID(objectID)]
+func MarshalMetadata(objectID string, Filter string, version int) string {
+	object := load().get(ObjectID(objectID))
+	return marshalObjects([]interface{}{object}, Filter, version)
+}
+
+// MarshalContainer returns the DIDL-Lite of a container which is not in the
+// tree, with the properties version knows.
+func MarshalContainer(container *Container, Filter string, version int) string {
+	return marshalObjects([]interface{}{container}, Filter, version)
+}
+
+// marshalPage marshals the objects selected by StartingIndex and
+// RequestedCount, where a RequestedCount of 0 selects every remaining object.
+// It returns the DIDL-Lite and the number of objects in it.
+func marshalPage(objects []interface{}, Filter string, StartingIndex int, RequestedCount int, version int) (string, int) {
+	min, max := StartingIndex, StartingIndex+RequestedCount
+	if min > len(objects) {
+		min = len(objects)
+	}
+	if RequestedCount == 0 || max > len(objects) {
+		max = len(objects)
+	}
+	return marshalObjects(objects[min:max], Filter, version), max - min
+}
+
+// Browse answers a Browse of objectID, of its metadata or of its direct
+// children, from a single snapshot so that the result, the total and the
+// update ID agree. It returns the DIDL-Lite, NumberReturned, TotalMatches and
+// UpdateID. The DIDL-Lite has the properties version knows.
+func Browse(objectID string, metadata bool, Filter string, SortCriteria string, StartingIndex int, RequestedCount int, version int) (string, int, int, int, error) {
+	s := load()
+	object := s.get(ObjectID(objectID))
+	if object == nil {
+		return "", 0, 0, 0, ErrNoSuchObject
+	}
+	if metadata {
+		return marshalObjects([]interface{}{object}, Filter, version), 1, 1, s.updateID, nil
+	}
 	container, ok := object.(*Container)
 	if !ok {
-		log.Fatalf("passed objectID %s not found as a container", objectID)
+		return "", 0, 0, 0, ErrNoSuchObject
 	}
-	wrapper := DIDLLite{}
-	var min, max int
-	if StartingIndex < cap(container.Children) {
-		min = StartingIndex
-	} else {
-		min = cap(container.Children)
-	}
-	if StartingIndex+RequestedCount <= cap(container.Children) {
-		max = StartingIndex + RequestedCount
-	} else {
-		max = cap(container.Children)
-	}
-	wrapper.Objects = container.Children[min:max]
-	data, err := xml.Marshal(wrapper)
+	keys, err := parseSortCriteria(SortCriteria)
 	if err != nil {
-		log.Fatal(err)
+		return "", 0, 0, 0, err
 	}
-	return string(data)
+	children := s.children(container)
+	if len(keys) > 0 {
+		children = append([]interface{}(nil), children...)
+		sortObjects(children, keys)
+	}
+	result, returned := marshalPage(children, Filter, StartingIndex, RequestedCount, version)
+	return result, returned, len(children), s.updateID, nil
 }
 
 func GetObject(objectID string) interface{} {
-	return registory[ObjectID(objectID)]
+	return load().get(ObjectID(objectID))
+}
+
+// GetResource returns the resource resourceID, or nil if there is none.
+func GetResource(resourceID string) *Res {
+	return load().resources[ObjectID(resourceID)]
 }
@@ -0,0 +1,447 @@
+package contentdirectory
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeSource is a ContentSource holding its contents in memory.
+type fakeSource struct {
+	mu       sync.Mutex
+	contents []Content
+}
+
+func (f *fakeSource) Contents(ctx context.Context) ([]Content, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Content(nil), f.contents...), nil
+}
+
+func (f *fakeSource) Probe(ctx context.Context, resource Resource) (Probe, error) {
+	return Probe{Duration: time.Minute}, nil
+}
+
+func (f *fakeSource) ServeResource(w http.ResponseWriter, r *http.Request, resourceID string) {
+	http.NotFound(w, r)
+}
+
+func (f *fakeSource) Delete(ctx context.Context, contentID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, content := range f.contents {
+		if content.ID == contentID {
+			f.contents = append(f.contents[:i], f.contents[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoSuchObject
+}
+
+func (f *fakeSource) set(contents []Content) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.contents = contents
+}
+
+// blockingSource is a fakeSource whose Contents and Delete wait for release,
+// telling started when they start.
+type blockingSource struct {
+	*fakeSource
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSource) Contents(ctx context.Context) ([]Content, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.fakeSource.Contents(ctx)
+}
+
+func (b *blockingSource) Delete(ctx context.Context, contentID string) error {
+	b.started <- struct{}{}
+	<-b.release
+	return b.fakeSource.Delete(ctx, contentID)
+}
+
+// fakeContent returns a recording in a genre and a channel view.
+func fakeContent(id string, start time.Time) Content {
+	return Content{
+		ID:    id,
+		Title: "番組" + id,
+		Start: start,
+		End:   start.Add(30 * time.Minute),
+		Resources: []Resource{{
+			ID:       id,
+			Filename: id + ".m2ts",
+			Size:     1000,
+			Duration: 30 * time.Minute,
+		}},
+		Views: []View{
+			{Kind: ViewGenre, Key: "0", Title: "ニュース・報道"},
+			{Kind: ViewChannel, Key: "1", Title: "テレビ"},
+		},
+	}
+}
+
+// setupTest builds the tree of source from scratch, keeping the state files in
+// a temporary directory.
+func setupTest(t *testing.T, source ContentSource) {
+	t.Helper()
+	StateDir = t.TempDir()
+	Source = source
+	restart()
+}
+
+// restart builds the tree again from the state files and the Source, as after
+// a restart of the server.
+func restart() {
+	syncMu.Lock()
+	passed = false
+	syncMu.Unlock()
+	journalMu.Lock()
+	systemUpdateID, reservedUpdateID, pendingChanges = 0, 0, nil
+	serviceResetToken = uuid.New().String()
+	journalMu.Unlock()
+	writeMu.Lock()
+	published.Store(newSnapshot(nil))
+	synced = make(map[ObjectID]recording)
+	viewTitles = make(map[ObjectID]string)
+	writeMu.Unlock()
+	metadataMu.Lock()
+	metadata = make(map[string]fileMetadata)
+	metadataMu.Unlock()
+	Setup("http://localhost/")
+}
+
+func TestDestroyObjectRaisesSystemUpdateID(t *testing.T) {
+	AllowDestroyObject = true
+	defer func() { AllowDestroyObject = false }()
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &fakeSource{contents: []Content{fakeContent("1", start), fakeContent("2", start.Add(time.Hour))}}
+	setupTest(t, source)
+
+	before := GetSystemUpdateID()
+	if err := DestroyObject("01/1"); err != nil {
+		t.Fatal(err)
+	}
+	if after := GetSystemUpdateID(); after <= before {
+		t.Errorf("SystemUpdateID went from %d to %d on delete", before, after)
+	}
+	if GetObject("01/1") != nil {
+		t.Error("deleted item still in the tree")
+	}
+}
+
+func TestDestroyObjectDeletesWithoutBlockingWriters(t *testing.T) {
+	AllowDestroyObject = true
+	defer func() { AllowDestroyObject = false }()
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &fakeSource{contents: []Content{fakeContent("1", start), fakeContent("2", start.Add(time.Hour))}}
+	setupTest(t, source)
+	blocking := &blockingSource{source, make(chan struct{}), make(chan struct{})}
+	Source = blocking
+	defer func() { Source = source }()
+
+	destroyed := make(chan error)
+	go func() { destroyed <- DestroyObject("01/1") }()
+	<-blocking.started
+
+	written := make(chan error)
+	go func() {
+		written <- modify(func(s *snapshot) ([]ObjectID, error) { return nil, errUnchanged })
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("a writer waited for the Source to delete a content")
+	}
+	close(blocking.release)
+	if err := <-destroyed; err != nil {
+		t.Fatal(err)
+	}
+	if GetObject("01/1") != nil {
+		t.Error("deleted item still in the tree")
+	}
+}
+
+func TestTrackChangesPropertiesOfVersion(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	setupTest(t, &fakeSource{contents: []Content{fakeContent("1", start)}})
+
+	for _, test := range []struct {
+		id         ObjectID
+		properties []string
+	}{
+		{"01", []string{"upnp:objectUpdateID", "upnp:containerUpdateID", "upnp:totalDeletedChildCount"}},
+		{"01/1", []string{"upnp:objectUpdateID"}},
+	} {
+		v3 := MarshalMetadata(string(test.id), "*", 3)
+		for _, property := range test.properties {
+			// The marshalled elements declare their namespace instead of
+			// a prefix.
+			if !strings.Contains(v3, "<"+strings.TrimPrefix(property, "upnp:")+" ") {
+				t.Errorf("version 3 metadata of %s lacks %s: %s", test.id, property, v3)
+			}
+		}
+		for _, filter := range []string{"*", strings.Join(test.properties, ",")} {
+			v1 := MarshalMetadata(string(test.id), filter, 1)
+			for _, property := range test.properties {
+				if strings.Contains(v1, strings.TrimPrefix(property, "upnp:")) {
+					t.Errorf("version 1 metadata of %s has %s: %s", test.id, property, v1)
+				}
+			}
+		}
+	}
+}
+
+// TestSyncWhileBrowsing browses, searches and looks up objects while syncs
+// change the tree, for go test -race. Every answer must be of one snapshot.
+func TestSyncWhileBrowsing(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &fakeSource{contents: []Content{fakeContent("1", start)}}
+	setupTest(t, source)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			var contents []Content
+			for j := i; j <= i+i%10; j++ {
+				contents = append(contents, fakeContent(strconv.Itoa(j), start.Add(time.Duration(j)*time.Hour)))
+			}
+			source.set(contents)
+			if err := Sync(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lastUpdateID := 0
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				ids := []string{"0", "01"}
+				for _, id := range []string{"02", "03"} {
+					if views, ok := GetObject(id).(*Container); ok {
+						for _, view := range views.Children {
+							ids = append(ids, string(view.(*Container).Id))
+						}
+					}
+				}
+				for _, id := range ids {
+					_, returned, total, updateID, err := Browse(id, false, "*", "", 0, 0, Version)
+					if err == ErrNoSuchObject {
+						// The view went away since it was listed.
+						continue
+					}
+					if err != nil {
+						t.Errorf("browsing %s: %s", id, err)
+						return
+					}
+					if returned != total {
+						t.Errorf("browsing %s returned %d of %d", id, returned, total)
+					}
+					if updateID < lastUpdateID {
+						t.Errorf("UpdateID went from %d to %d", lastUpdateID, updateID)
+					}
+					lastUpdateID = updateID
+					if _, _, _, _, err := Browse(id, true, "*", "", 0, 0, Version); err != nil && err != ErrNoSuchObject {
+						t.Errorf("browsing metadata of %s: %s", id, err)
+					}
+				}
+				_, returned, total, _, err := Search(context.Background(), "0", `upnp:class derivedfrom "object.item"`, "*", "", 0, 0, Version)
+				if err != nil {
+					t.Errorf("searching: %s", err)
+					return
+				}
+				if returned != total {
+					t.Errorf("search returned %d of %d", returned, total)
+				}
+				GetObject("01/1")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSyncListsWithoutBlockingWriters(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &fakeSource{contents: []Content{fakeContent("1", start)}}
+	setupTest(t, source)
+	blocking := &blockingSource{source, make(chan struct{}), make(chan struct{})}
+	Source = blocking
+	defer func() { Source = source }()
+
+	source.set([]Content{fakeContent("1", start), fakeContent("2", start.Add(time.Hour))})
+	synced := make(chan error)
+	go func() { synced <- Sync() }()
+	<-blocking.started
+
+	written := make(chan error)
+	go func() {
+		written <- modify(func(s *snapshot) ([]ObjectID, error) { return nil, errUnchanged })
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("a writer waited for the Source to list its contents")
+	}
+	close(blocking.release)
+	if err := <-synced; err != nil {
+		t.Fatal(err)
+	}
+	if GetObject("01/2") == nil {
+		t.Error("content added to the Source is missing from the tree")
+	}
+}
+
+// fakeFile returns a media file of class in the folder "a".
+func fakeFile(id string, class string, modTime time.Time) Content {
+	return Content{
+		ID:        id,
+		Title:     "ファイル" + id,
+		Start:     modTime,
+		End:       modTime,
+		Protected: true,
+		Class:     class,
+		Kind:      ContentFile,
+		Resources: []Resource{{ID: id, Filename: id + ".jpg", Size: 100, Duration: time.Second}},
+		Folder:    []Folder{{Key: "a", Title: "写真"}},
+	}
+}
+
+func TestFilesContainer(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &fakeSource{contents: []Content{
+		fakeContent("1", start),
+		fakeFile("f1", "object.item.imageItem.photo", start.Add(time.Hour)),
+	}}
+	setupTest(t, source)
+
+	recorded := GetObject("01").(*Container)
+	if recorded.ChildCount != 1 || recorded.Children[0].(*Item).Id != "01/1" {
+		t.Errorf("01 holds %d items", recorded.ChildCount)
+	}
+	files, ok := GetObject("07").(*Container)
+	if !ok || files.ChildCount != 1 || files.Children[0].(*Item).Class != "object.item.imageItem.photo" {
+		t.Fatalf("07 is %+v", GetObject("07"))
+	}
+	result, _, total, _, err := Browse("06a", false, "*", "", 0, 0, Version)
+	if err != nil || total != 1 || !strings.Contains(result, `07/f1"`) {
+		t.Errorf("folder of the file: %s, %d, %v", result, total, err)
+	}
+	if _, err := CreateReference("05", "07/f1"); err != nil {
+		t.Errorf("CreateReference of a file: %v", err)
+	}
+
+	// The container of files goes with the last file.
+	source.set([]Content{fakeContent("1", start)})
+	if err := Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if GetObject("07") != nil || GetObject("06") != nil {
+		t.Error("containers of files left without files")
+	}
+}
+
+func TestFeatureListIsOfVideo(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	setupTest(t, &fakeSource{contents: []Content{
+		fakeContent("1", start),
+		fakeFile("f1", "object.item.imageItem.photo", start),
+		fakeFile("f2", "object.item.audioItem.musicTrack", start),
+	}})
+
+	var list featureList
+	if err := xml.Unmarshal([]byte(MarshalFeatureList()), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Features) == 0 || len(list.Features[0].Containers) == 0 {
+		t.Fatalf("no containers in %s", MarshalFeatureList())
+	}
+	for _, f := range list.Features {
+		for _, c := range f.Containers {
+			container, ok := GetObject(string(c.Id)).(*Container)
+			if !ok {
+				t.Errorf("feature list has unknown container %s", c.Id)
+				continue
+			}
+			for _, child := range container.Children {
+				if item := child.(*Item); !strings.HasPrefix(item.Class, c.Type) {
+					t.Errorf("%s is listed as %s, but holds %s of %s", c.Id, c.Type, item.Id, item.Class)
+				}
+			}
+		}
+	}
+}
+
+// fakeChannel returns a live channel in the channel view of fakeContent, with
+// no program on air.
+func fakeChannel(id string) Content {
+	return Content{
+		ID:        id,
+		Title:     "テレビ",
+		Protected: true,
+		Class:     "object.item.videoItem.videoBroadcast",
+		Kind:      ContentLive,
+		Resources: []Resource{{ID: id, Filename: id + ".ts", Live: true}},
+		Views:     []View{{Kind: ViewChannel, Key: "1", Title: "テレビ"}},
+	}
+}
+
+func TestLiveContainer(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &fakeSource{contents: []Content{fakeContent("1", start), fakeChannel("m1")}}
+	setupTest(t, source)
+
+	if recorded := GetObject("01").(*Container); recorded.ChildCount != 1 {
+		t.Errorf("01 holds %d items, want the recording only", recorded.ChildCount)
+	}
+	live, ok := GetObject("08").(*Container)
+	if !ok || live.ChildCount != 1 || live.Children[0].(*Item).Id != "08/m1" {
+		t.Fatalf("08 is %+v", GetObject("08"))
+	}
+	metadata := MarshalMetadata("08/m1", "*", Version)
+	for _, property := range []string{"date", "scheduledStartTime", "scheduledEndTime"} {
+		if strings.Contains(metadata, "<"+property) {
+			t.Errorf("channel without a program on air has %s: %s", property, metadata)
+		}
+	}
+	result, _, total, _, err := Browse("031", false, "*", "", 0, 0, Version)
+	if err != nil || total != 2 || !strings.Contains(result, `08/m1"`) {
+		t.Errorf("channel view: %s, %d, %v", result, total, err)
+	}
+
+	source.set([]Content{fakeContent("1", start)})
+	if err := Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if GetObject("08") != nil {
+		t.Error("container of live channels left without channels")
+	}
+}
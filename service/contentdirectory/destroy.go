@@ -0,0 +1,122 @@
+package contentdirectory
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// AllowDestroyObject lets clients delete contents from the Source with
+// DestroyObject. Protected recordings are never deleted. It must be set
+// before Setup.
+var AllowDestroyObject bool
+
+var ErrRestrictedObject = errors.New("restricted object")
+
+// removeItems removes the items remove selects from the tree under
+// container, and returns the IDs of the containers they were removed from.
+func removeItems(container *Container, remove func(item *Item) bool) []ObjectID {
+	var removedFrom []ObjectID
+	children := make([]interface{}, 0, len(container.Children))
+	for _, child := range container.Children {
+		switch c := child.(type) {
+		case *Container:
+			removedFrom = append(removedFrom, removeItems(c, remove)...)
+		case *Item:
+			if remove(c) {
+				continue
+			}
+		}
+		children = append(children, child)
+	}
+	if len(children) < len(container.Children) {
+		removedFrom = append(removedFrom, container.Id)
+	}
+	container.Children = children
+	container.ChildCount = len(children) + len(container.members)
+	return removedFrom
+}
+
+// removeRecording removes the item of a recording and every reference to it
+// from the tree under container.
+func removeRecording(container *Container, contentId ObjectID) []ObjectID {
+	removedFrom := removeItems(container, func(item *Item) bool {
+		return item.ContentId == contentId
+	})
+	return append(removedFrom, removeMembers(container, contentId)...)
+}
+
+// deleteTimeout bounds how long DestroyObject waits for the Source to delete
+// a content.
+const deleteTimeout = 30 * time.Second
+
+// DestroyObject deletes the content of an item or of a reference from the
+// Source, and removes the item and its references from every container.
+// A reference in the お気に入り container only removes the reference.
+func DestroyObject(objectID string) error {
+	id := ObjectID(objectID)
+	item, err := destroyable(load(), id)
+	if err != nil {
+		return err
+	}
+	if item.ParentID == favoritesContainerID {
+		// Only the reference goes, the recording stays.
+		return modify(func(s *snapshot) ([]ObjectID, error) {
+			if _, err := destroyable(s, id); err != nil {
+				return nil, err
+			}
+			if _, err := removeFavorite(s, item.ContentId); err != nil {
+				return nil, err
+			}
+			objectDeleted(s, id, []ObjectID{favoritesContainerID})
+			return []ObjectID{favoritesContainerID}, nil
+		})
+	}
+
+	// Syncs wait, so that none lists the content before it is deleted and
+	// adds it back after it is removed. Other writers go on meanwhile.
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	// The Source checks again, the content may have been protected since
+	// the last sync.
+	ctx, cancel := context.WithTimeout(context.Background(), deleteTimeout)
+	defer cancel()
+	if err := Source.Delete(ctx, string(item.ContentId)); err != nil {
+		return err
+	}
+	return modify(func(s *snapshot) ([]ObjectID, error) {
+		return removeDeleted(s, item.ContentId)
+	})
+}
+
+// destroyable returns the item id names in s if clients may destroy it.
+func destroyable(s *snapshot, id ObjectID) (*Item, error) {
+	item, ok := s.get(id).(*Item)
+	if !ok {
+		if s.get(id) != nil {
+			return nil, ErrRestrictedObject
+		}
+		return nil, ErrNoSuchObject
+	}
+	if item.Restricted == "true" {
+		return nil, ErrRestrictedObject
+	}
+	return item, nil
+}
+
+// removeDeleted removes a content deleted from the Source from s.
+func removeDeleted(s *snapshot, contentId ObjectID) ([]ObjectID, error) {
+	if err := forgetFavorite(contentId); err != nil {
+		log.Printf("could not remove deleted recording %s from favorites: %s", contentId, err)
+	}
+	// The next sync must not remove it again.
+	delete(synced, contentId)
+	if len(removeRecording(s.root, contentId)) == 0 {
+		return nil, errUnchanged
+	}
+	pruneViews(s.root)
+	old := load()
+	*s = *newSnapshot(s.root)
+	return trackChanges(old, s), nil
+}
@@ -0,0 +1,158 @@
+package contentdirectory
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// favoritesFile persists the recordings in the お気に入り container, in the
+// order they were added.
+const favoritesFile = "favorites.json"
+
+const favoritesContainerID = ObjectID("05")
+
+var ErrRestrictedParentObject = errors.New("restricted parent object")
+
+// A favorite is a reference to a recording, by its content ID. Title is set
+// when a client has renamed the reference with UpdateObject.
+type favorite struct {
+	RefID ObjectID `json:"refID"`
+	Title string   `json:"title,omitempty"`
+}
+
+var favorites []favorite
+
+func loadFavorites() {
+	favorites = nil
+	data, err := ioutil.ReadFile(statePath(favoritesFile))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &favorites); err != nil {
+		log.Printf("ignoring broken %s: %s", favoritesFile, err)
+	}
+}
+
+func saveFavorites() error {
+	data, err := json.Marshal(favorites)
+	if err != nil {
+		return err
+	}
+	tmp := statePath(favoritesFile) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath(favoritesFile))
+}
+
+// favoriteID returns the ObjectID of the reference to a recording in the
+// お気に入り container.
+func favoriteID(contentId ObjectID) ObjectID {
+	return itemID(favoritesContainerID, contentId)
+}
+
+// newFavorite appends a reference to item to the お気に入り container.
+func newFavorite(parent *Container, item *Item, title string) *Item {
+	reference := newReference(parent.Id, item)
+	reference.Restricted = "false"
+	if title != "" {
+		reference.Title = title
+	}
+	parent.AppendItem(reference)
+	return reference
+}
+
+// CreateReference adds a recording to the お気に入り container and returns the
+// ObjectID of the reference.
+func CreateReference(containerID string, objectID string) (string, error) {
+	var referenceID ObjectID
+	err := modify(func(s *snapshot) ([]ObjectID, error) {
+		container, ok := s.get(ObjectID(containerID)).(*Container)
+		if !ok {
+			return nil, ErrNoSuchContainer
+		}
+		if container.Id != favoritesContainerID {
+			return nil, ErrRestrictedParentObject
+		}
+		item, ok := s.get(ObjectID(objectID)).(*Item)
+		if !ok {
+			return nil, ErrNoSuchObject
+		}
+		if item.RefID != nil {
+			if item, ok = s.get(*item.RefID).(*Item); !ok {
+				return nil, ErrNoSuchObject
+			}
+		}
+		referenceID = favoriteID(item.ContentId)
+		if s.get(referenceID) != nil {
+			return nil, errUnchanged
+		}
+
+		favorites = append(favorites, favorite{RefID: item.ContentId})
+		if err := saveFavorites(); err != nil {
+			favorites = favorites[:len(favorites)-1]
+			return nil, err
+		}
+		reference := newFavorite(container, item, "")
+		s.objects[reference.Id] = reference
+		objectAdded(container, reference)
+		return []ObjectID{container.Id}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(referenceID), nil
+}
+
+// forgetFavorite removes a recording from the favorites.
+func forgetFavorite(contentId ObjectID) error {
+	for i, f := range favorites {
+		if f.RefID == contentId {
+			favorites = append(favorites[:i:i], favorites[i+1:]...)
+			return saveFavorites()
+		}
+	}
+	return nil
+}
+
+// removeFavorite removes the reference to a recording from the お気に入り
+// container of s. It reports whether the container changed.
+func removeFavorite(s *snapshot, contentId ObjectID) (bool, error) {
+	if err := forgetFavorite(contentId); err != nil {
+		return false, err
+	}
+	id := favoriteID(contentId)
+	if s.get(id) == nil {
+		return false, nil
+	}
+	removeItems(s.get(favoritesContainerID).(*Container), func(item *Item) bool {
+		return item.Id == id
+	})
+	delete(s.objects, id)
+	return true, nil
+}
+
+// renameFavorite changes the title of the reference to a recording.
+func renameFavorite(s *snapshot, contentId ObjectID, title string) error {
+	for i := range favorites {
+		if favorites[i].RefID == contentId {
+			old := favorites[i].Title
+			favorites[i].Title = title
+			if err := saveFavorites(); err != nil {
+				favorites[i].Title = old
+				return err
+			}
+			break
+		}
+	}
+	if reference, ok := s.get(favoriteID(contentId)).(*Item); ok {
+		reference.Title = title
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package contentdirectory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// savedFavorites returns the favorites in favorites.json.
+func savedFavorites(t *testing.T) []favorite {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(StateDir, favoritesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []favorite
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	return saved
+}
+
+// favoriteTitles returns the titles of the references in the お気に入り
+// container, in order.
+func favoriteTitles() []string {
+	var titles []string
+	for _, child := range GetObject(string(favoritesContainerID)).(*Container).Children {
+		titles = append(titles, child.(*Item).Title)
+	}
+	return titles
+}
+
+func TestCreateReference(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	setupTest(t, &fakeSource{contents: []Content{fakeContent("1", start), fakeContent("2", start.Add(time.Hour))}})
+
+	newID, err := CreateReference("05", "01/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newID != "05/2" {
+		t.Errorf("got reference %s, want 05/2", newID)
+	}
+	reference, ok := GetObject(newID).(*Item)
+	if !ok || reference.RefID == nil || *reference.RefID != "01/2" || reference.Restricted != "false" {
+		t.Fatalf("got reference %+v", GetObject(newID))
+	}
+	// A reference to a reference refers to the recording, which is there
+	// already.
+	if newID, err := CreateReference("05", "05/2"); err != nil || newID != "05/2" {
+		t.Errorf("referring to the reference: got %s, %v", newID, err)
+	}
+	if _, err := CreateReference("05", "01/1"); err != nil {
+		t.Fatal(err)
+	}
+	if titles := favoriteTitles(); !reflect.DeepEqual(titles, []string{"番組2", "番組1"}) {
+		t.Errorf("favorites are %v", titles)
+	}
+	if saved := savedFavorites(t); !reflect.DeepEqual(saved, []favorite{{RefID: "2"}, {RefID: "1"}}) {
+		t.Errorf("saved favorites are %+v", saved)
+	}
+
+	for _, test := range []struct {
+		containerID, objectID string
+		err                   error
+	}{
+		{"01", "01/1", ErrRestrictedParentObject},
+		{"09", "01/1", ErrNoSuchContainer},
+		{"05", "01/9", ErrNoSuchObject},
+		{"05", "01", ErrNoSuchObject},
+	} {
+		if _, err := CreateReference(test.containerID, test.objectID); err != test.err {
+			t.Errorf("referring to %s in %s: got %v, want %v", test.objectID, test.containerID, err, test.err)
+		}
+	}
+}
+
+func TestUpdateFavorite(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	setupTest(t, &fakeSource{contents: []Content{fakeContent("1", start)}})
+	if _, err := CreateReference("05", "01/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	before := GetObject("05/1").(*Item).ObjectUpdateID
+	if err := UpdateObject("05/1", "<dc:title>番組1</dc:title>", "<dc:title>好きな番組</dc:title>"); err != nil {
+		t.Fatal(err)
+	}
+	reference := GetObject("05/1").(*Item)
+	if reference.Title != "好きな番組" || reference.ObjectUpdateID <= before {
+		t.Errorf("got reference %q with objectUpdateID %d", reference.Title, reference.ObjectUpdateID)
+	}
+	if title := GetObject("01/1").(*Item).Title; title != "番組1" {
+		t.Errorf("the recording was renamed to %q", title)
+	}
+	if saved := savedFavorites(t); !reflect.DeepEqual(saved, []favorite{{RefID: "1", Title: "好きな番組"}}) {
+		t.Errorf("saved favorites are %+v", saved)
+	}
+
+	for _, test := range []struct {
+		objectID, current, next string
+		err                     error
+	}{
+		{"05/1", "<dc:title>番組1</dc:title>", "<dc:title>別名</dc:title>", ErrInvalidCurrentTagValue},
+		{"05/1", "<dc:title>好きな番組</dc:title>", "", ErrRequiredTag},
+		{"05/1", "<upnp:genre>ニュース</upnp:genre>", "<upnp:genre>ドラマ</upnp:genre>", ErrReadOnlyTag},
+		{"05/1", "<dc:title>好きな番組</dc:title>", "<dc:title>a</dc:title>,<dc:title>b</dc:title>", ErrParameterMismatch},
+		{"01/1", "<dc:title>番組1</dc:title>", "<dc:title>別名</dc:title>", ErrRestrictedObject},
+		{"05/9", "<dc:title>番組1</dc:title>", "<dc:title>別名</dc:title>", ErrNoSuchObject},
+	} {
+		if err := UpdateObject(test.objectID, test.current, test.next); err != test.err {
+			t.Errorf("updating %s from %s to %s: got %v, want %v", test.objectID, test.current, test.next, err, test.err)
+		}
+	}
+}
+
+func TestFavoritesPersist(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	setupTest(t, &fakeSource{contents: []Content{fakeContent("1", start), fakeContent("2", start.Add(time.Hour))}})
+	for _, id := range []string{"01/2", "01/1"} {
+		if _, err := CreateReference("05", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := UpdateObject("05/1", "<dc:title>番組1</dc:title>", "<dc:title>好きな番組</dc:title>"); err != nil {
+		t.Fatal(err)
+	}
+
+	restart()
+	if titles := favoriteTitles(); !reflect.DeepEqual(titles, []string{"番組2", "好きな番組"}) {
+		t.Errorf("favorites after a restart are %v", titles)
+	}
+}
+
+func TestFavoritesOfGoneRecordings(t *testing.T) {
+	AllowDestroyObject = true
+	defer func() { AllowDestroyObject = false }()
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &fakeSource{contents: []Content{fakeContent("1", start), fakeContent("2", start.Add(time.Hour))}}
+	setupTest(t, source)
+	for _, id := range []string{"01/1", "01/2"} {
+		if _, err := CreateReference("05", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A recording gone from the Source leaves the container, but stays
+	// among the favorites in case it shows up again.
+	source.set([]Content{fakeContent("2", start.Add(time.Hour))})
+	if err := Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if GetObject("05/1") != nil {
+		t.Error("reference to a recording gone from the Source still in the tree")
+	}
+	if saved := savedFavorites(t); !reflect.DeepEqual(saved, []favorite{{RefID: "1"}, {RefID: "2"}}) {
+		t.Errorf("saved favorites are %+v", saved)
+	}
+	source.set([]Content{fakeContent("1", start), fakeContent("2", start.Add(time.Hour))})
+	if err := Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if GetObject("05/1") == nil {
+		t.Error("reference missing after the recording showed up again")
+	}
+
+	// A recording deleted with DestroyObject is gone from the favorites.
+	if err := DestroyObject("01/2"); err != nil {
+		t.Fatal(err)
+	}
+	if GetObject("05/2") != nil {
+		t.Error("reference to a destroyed recording still in the tree")
+	}
+	if saved := savedFavorites(t); !reflect.DeepEqual(saved, []favorite{{RefID: "1"}}) {
+		t.Errorf("saved favorites are %+v", saved)
+	}
+
+	// Destroying a reference only removes the reference.
+	if err := DestroyObject("05/1"); err != nil {
+		t.Fatal(err)
+	}
+	if GetObject("05/1") != nil || GetObject("01/1") == nil {
+		t.Error("destroying the reference did not leave just the recording")
+	}
+	if saved := savedFavorites(t); len(saved) != 0 {
+		t.Errorf("saved favorites are %+v", saved)
+	}
+}
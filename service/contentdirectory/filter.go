@@ -0,0 +1,194 @@
+package contentdirectory
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var namespacePrefixes = map[string]string{
+	"http://purl.org/dc/elements/1.1/":        "dc",
+	"urn:schemas-upnp-org:metadata-1-0/upnp/": "upnp",
+	"http://www.sec.co.kr/":                   "sec",
+}
+
+// requiredProperties are always sent regardless of the Filter argument.
+var requiredProperties = map[string]bool{
+	"@id":              true,
+	"@parentID":        true,
+	"@restricted":      true,
+	"dc:title":         true,
+	"upnp:class":       true,
+	"res@protocolInfo": true,
+}
+
+// trackChangesProperties came with the Track Changes option of
+// ContentDirectory:3, and are not sent to clients of earlier versions.
+var trackChangesProperties = map[string]bool{
+	"upnp:objectUpdateID":         true,
+	"upnp:containerUpdateID":      true,
+	"upnp:totalDeletedChildCount": true,
+}
+
+// trackChangesVersion is the version of ContentDirectory which introduced the
+// Track Changes option.
+const trackChangesVersion = 3
+
+// A Filter selects the DIDL-Lite properties to send, as requested by the
+// Filter argument of Browse and Search, among those the version of
+// ContentDirectory the client invoked knows.
+type Filter struct {
+	all        bool
+	properties map[string]bool
+	version    int
+}
+
+// ParseFilter parses "*" or a comma separated list of properties such as
+// "dc:title,upnp:genre,res@size,@childCount".
+func ParseFilter(filter string, version int) Filter {
+	f := Filter{properties: make(map[string]bool), version: version}
+	for _, property := range strings.Split(filter, ",") {
+		property = strings.TrimSpace(property)
+		if property == "*" {
+			f.all = true
+		}
+		// container@childCount and item@refID select the same attributes
+		// as @childCount and @refID.
+		property = strings.TrimPrefix(property, "container")
+		property = strings.TrimPrefix(property, "item")
+		f.properties[property] = true
+		if i := strings.Index(property, "@"); i > 0 {
+			f.properties[property[:i]] = true
+		}
+	}
+	return f
+}
+
+func (f Filter) includes(property string) bool {
+	if f.version < trackChangesVersion && trackChangesProperties[property] {
+		return false
+	}
+	return f.all || f.properties[property] || requiredProperties[property]
+}
+
+// Capabilities returns the properties among capabilities which the version
+// of ContentDirectory a client invoked knows.
+func Capabilities(capabilities []string, version int) []string {
+	if version >= trackChangesVersion {
+		return capabilities
+	}
+	var known []string
+	for _, property := range capabilities {
+		if !trackChangesProperties[property] {
+			known = append(known, property)
+		}
+	}
+	return known
+}
+
+// filteredObject marshals a Container or an Item leaving out the properties
+// not selected by filter.
+type filteredObject struct {
+	object interface{}
+	filter Filter
+}
+
+func (o filteredObject) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	return marshalFiltered(e, reflect.Indirect(reflect.ValueOf(o.object)), "", o.filter)
+}
+
+func parseXMLTag(tag string) (name xml.Name, attr bool, chardata bool) {
+	parts := strings.Split(tag, ",")
+	if i := strings.Index(parts[0], " "); i >= 0 {
+		name = xml.Name{Space: parts[0][:i], Local: parts[0][i+1:]}
+	} else {
+		name.Local = parts[0]
+	}
+	for _, opt := range parts[1:] {
+		attr = attr || opt == "attr"
+		chardata = chardata || opt == "chardata"
+	}
+	return
+}
+
+// propertyName returns the name of a property as used in Filter, e.g.
+// dc:title, @childCount or res@size.
+func propertyName(element string, name xml.Name, attr bool) string {
+	if attr {
+		return element + "@" + name.Local
+	}
+	if prefix, ok := namespacePrefixes[name.Space]; ok {
+		return prefix + ":" + name.Local
+	}
+	return name.Local
+}
+
+// marshalFiltered encodes the struct v the way encoding/xml does, following
+// its xml struct tags, but only with the properties selected by filter.
+// element is the property name of v when v is nested in an object, like res.
+func marshalFiltered(e *xml.Encoder, v reflect.Value, element string, filter Filter) error {
+	t := v.Type()
+	var start xml.StartElement
+	for i := 0; i < t.NumField(); i++ {
+		name, attr, _ := parseXMLTag(t.Field(i).Tag.Get("xml"))
+		fv := v.Field(i)
+		switch {
+		case t.Field(i).PkgPath != "":
+			// Unexported, like the members of a view.
+		case t.Field(i).Name == "XMLName":
+			start.Name = name
+		case attr && filter.includes(propertyName(element, name, true)):
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.IsZero() && strings.Contains(t.Field(i).Tag.Get("xml"), ",omitempty") {
+				continue
+			}
+			start.Attr = append(start.Attr, xml.Attr{Name: name, Value: fmt.Sprint(fv.Interface())})
+		}
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("xml")
+		name, attr, chardata := parseXMLTag(tag)
+		fv := v.Field(i)
+		if t.Field(i).PkgPath != "" || t.Field(i).Name == "XMLName" || attr || tag == "-" {
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		switch {
+		case chardata:
+			if err := e.EncodeToken(xml.CharData(fmt.Sprint(fv.Interface()))); err != nil {
+				return err
+			}
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Struct:
+			// Nested elements such as res are named by their XMLName tag.
+			nestedName, _, _ := parseXMLTag(fv.Type().Elem().Field(0).Tag.Get("xml"))
+			nested := propertyName(element, nestedName, false)
+			if !filter.includes(nested) {
+				continue
+			}
+			for j := 0; j < fv.Len(); j++ {
+				if err := marshalFiltered(e, fv.Index(j), nested, filter); err != nil {
+					return err
+				}
+			}
+		case filter.includes(propertyName(element, name, false)):
+			if err := e.EncodeElement(fv.Interface(), xml.StartElement{Name: name}); err != nil {
+				return err
+			}
+		}
+	}
+	return e.EncodeToken(start.End())
+}
@@ -0,0 +1,438 @@
+package contentdirectory
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// The change journal implements the Track Changes option of ContentDirectory:3.
+// Every change to an object takes the next SystemUpdateID, which becomes the
+// objectUpdateID of the object and the containerUpdateID of its parent.
+// Clients which cached the tree search for upnp:objectUpdateID greater than
+// the last SystemUpdateID they saw, and learn about deletions from
+// upnp:totalDeletedChildCount and the LastChange event.
+
+// A change is an entry of the journal, evented in LastChange.
+type change struct {
+	XMLName  xml.Name
+	ObjID    ObjectID `xml:"objID,attr"`
+	UpdateID int      `xml:"updateID,attr"`
+	StUpdate string   `xml:"stUpdate,attr"`
+	ParentID ObjectID `xml:"objParentID,attr,omitempty"`
+	Class    string   `xml:"objClass,attr,omitempty"`
+}
+
+type stateEvent struct {
+	XMLName xml.Name `xml:"urn:schemas-upnp-org:av:cds-event StateEvent"`
+	Changes []change
+}
+
+// journalFile persists the SystemUpdateID and the ServiceResetToken, so that
+// update IDs keep increasing across restarts.
+const journalFile = "journal.json"
+
+// treeFile persists the contents the tree was built from and the update IDs of
+// its objects. On start the tree is rebuilt from it, so that the first sync
+// records only what changed while the server was down.
+const treeFile = "tree.json"
+
+// updateIDBlock is how many update IDs are reserved in journalFile at a time.
+// Update IDs given out are always below the reservation, so that none is
+// given out twice even if the server stops without saving.
+const updateIDBlock = 1000
+
+type journalState struct {
+	ReservedUpdateID int    `json:"reservedUpdateID"`
+	ResetToken       string `json:"resetToken"`
+}
+
+type treeState struct {
+	Contents  []json.RawMessage            `json:"contents"`
+	UpdateIDs map[ObjectID]objectUpdateIDs `json:"updateIDs"`
+}
+
+// objectUpdateIDs are the update IDs of an object. References in views have
+// only an objectUpdateID.
+type objectUpdateIDs struct {
+	Object          int `json:"object"`
+	Container       int `json:"container,omitempty"`
+	DeletedChildren int `json:"deletedChildren,omitempty"`
+}
+
+var journalMu sync.Mutex
+var systemUpdateID int
+var reservedUpdateID int
+var pendingChanges []change
+
+// serviceResetToken changes whenever update IDs start over, which tells clients
+// to drop what they cached.
+var serviceResetToken = uuid.New().String()
+
+// loadJournal continues the update IDs of the previous run. It reports
+// whether they continue rather than start over.
+func loadJournal() bool {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	data, err := ioutil.ReadFile(statePath(journalFile))
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var state journalState
+	if err := json.Unmarshal(data, &state); err != nil || state.ResetToken == "" {
+		// Update IDs start over, under a new token.
+		log.Printf("ignoring broken %s: %v", journalFile, err)
+		return false
+	}
+	systemUpdateID = state.ReservedUpdateID
+	reservedUpdateID = state.ReservedUpdateID
+	serviceResetToken = state.ResetToken
+	return true
+}
+
+// loadTree publishes the tree of the previous run with its update IDs, as the
+// baseline the first sync tracks changes against. It must be called holding
+// writeMu, after loadJournal continued the update IDs.
+func loadTree() {
+	data, err := ioutil.ReadFile(statePath(treeFile))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var state treeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("ignoring broken %s: %s", treeFile, err)
+		return
+	}
+	contents := make([]Content, len(state.Contents))
+	for i, content := range state.Contents {
+		if err := json.Unmarshal(content, &contents[i]); err != nil {
+			log.Printf("ignoring broken %s: %s", treeFile, err)
+			return
+		}
+	}
+	d, err := diffContents(contents)
+	if err != nil {
+		log.Printf("ignoring broken %s: %s", treeFile, err)
+		return
+	}
+	s := newSnapshot(applyDiff(nil, d))
+	for id, object := range s.objects {
+		updateIDs := state.UpdateIDs[id]
+		switch o := object.(type) {
+		case *Container:
+			o.ObjectUpdateID = updateIDs.Object
+			o.ContainerUpdateID = updateIDs.Container
+			o.TotalDeletedChildCount = updateIDs.DeletedChildren
+			for i := range o.members {
+				o.members[i].updateID = state.UpdateIDs[itemID(o.Id, o.members[i].contentId)].Object
+			}
+		case *Item:
+			o.ObjectUpdateID = updateIDs.Object
+		}
+	}
+	s.updateID = GetSystemUpdateID()
+	published.Store(s)
+}
+
+// saveTree saves the contents s was built from and the update IDs of its
+// objects. It must be called holding writeMu.
+func saveTree(s *snapshot) error {
+	state := treeState{UpdateIDs: make(map[ObjectID]objectUpdateIDs, len(s.objects))}
+	ids := make([]ObjectID, 0, len(synced))
+	for id := range synced {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return lessID(ids[i], ids[j]) })
+	for _, id := range ids {
+		state.Contents = append(state.Contents, json.RawMessage(synced[id].fingerprint))
+	}
+	for id, object := range s.objects {
+		switch o := object.(type) {
+		case *Container:
+			state.UpdateIDs[id] = objectUpdateIDs{o.ObjectUpdateID, o.ContainerUpdateID, o.TotalDeletedChildCount}
+			for _, m := range o.members {
+				state.UpdateIDs[itemID(o.Id, m.contentId)] = objectUpdateIDs{Object: m.updateID}
+			}
+		case *Item:
+			state.UpdateIDs[id] = objectUpdateIDs{Object: o.ObjectUpdateID}
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := statePath(treeFile) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath(treeFile))
+}
+
+func saveJournal() error {
+	data, err := json.Marshal(journalState{ReservedUpdateID: reservedUpdateID, ResetToken: serviceResetToken})
+	if err != nil {
+		return err
+	}
+	tmp := statePath(journalFile) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath(journalFile))
+}
+
+// GetSystemUpdateID returns the SystemUpdateID of the last change.
+func GetSystemUpdateID() int {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	return systemUpdateID
+}
+
+func GetServiceResetToken() string {
+	return serviceResetToken
+}
+
+// record appends a change of kind objAdd, objMod or objDel to the journal and
+// returns its update ID.
+func record(kind string, id ObjectID, parentID ObjectID, class string) int {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	systemUpdateID++
+	if systemUpdateID > reservedUpdateID {
+		reservedUpdateID = systemUpdateID + updateIDBlock
+		if err := saveJournal(); err != nil {
+			log.Printf("could not save %s: %s", journalFile, err)
+		}
+	}
+	c := change{
+		XMLName:  xml.Name{Space: "urn:schemas-upnp-org:av:cds-event", Local: kind},
+		ObjID:    id,
+		UpdateID: systemUpdateID,
+		StUpdate: "0",
+	}
+	if kind == "objAdd" {
+		c.ParentID, c.Class = parentID, class
+	}
+	pendingChanges = append(pendingChanges, c)
+	return systemUpdateID
+}
+
+// flushChanges returns the LastChange of the changes recorded since the last
+// call, or "" if there were none.
+func flushChanges() string {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if len(pendingChanges) == 0 {
+		return ""
+	}
+	data, err := xml.Marshal(stateEvent{Changes: pendingChanges})
+	if err != nil {
+		log.Fatal(err)
+	}
+	pendingChanges = nil
+	return string(data)
+}
+
+// containerModified marks a change in the children of container.
+func containerModified(container *Container, updateID int) {
+	container.ContainerUpdateID = updateID
+}
+
+// objectAdded records a new child of parent.
+func objectAdded(parent *Container, item *Item) {
+	updateID := record("objAdd", item.Id, parent.Id, item.Class)
+	item.ObjectUpdateID = updateID
+	containerModified(parent, updateID)
+}
+
+// itemModified records a change in the properties of the item of a recording
+// and of every reference to it.
+func itemModified(s *snapshot, contentId ObjectID) {
+	class := ""
+	forEachItem(s.root, contentId, func(item *Item) {
+		item.ObjectUpdateID = record("objMod", item.Id, item.ParentID, item.Class)
+		class = item.Class
+	})
+	forEachMember(s.root, contentId, func(container *Container, m *member) {
+		m.updateID = record("objMod", itemID(container.Id, contentId), container.Id, class)
+	})
+}
+
+// objectDeleted records the removal of id from containerIDs.
+func objectDeleted(s *snapshot, id ObjectID, containerIDs []ObjectID) {
+	updateID := record("objDel", id, "", "")
+	for _, containerID := range containerIDs {
+		if container, ok := s.get(containerID).(*Container); ok {
+			container.TotalDeletedChildCount++
+			containerModified(container, updateID)
+		}
+	}
+}
+
+// sameContent reports whether two versions of an object have the same
+// properties, leaving aside where they are in the tree.
+func sameContent(a, b interface{}) bool {
+	switch a := a.(type) {
+	case *Container:
+		b, ok := b.(*Container)
+		return ok && a.Title == b.Title && a.Class == b.Class && a.Restricted == b.Restricted
+	case *Item:
+		b, ok := b.(*Item)
+		if !ok {
+			return false
+		}
+		ca, cb := *a, *b
+		ca.ParentID, cb.ParentID = "", ""
+		ca.ObjectUpdateID, cb.ObjectUpdateID = 0, 0
+		da, errA := xml.Marshal(ca)
+		db, errB := xml.Marshal(cb)
+		return errA == nil && errB == nil && string(da) == string(db)
+	}
+	return false
+}
+
+func objectID(object interface{}) ObjectID {
+	switch o := object.(type) {
+	case *Container:
+		return o.Id
+	case *Item:
+		return o.Id
+	}
+	return ""
+}
+
+// trackChanges compares the snapshot just built with the previous one,
+// carries the update IDs of unchanged objects over and records the changes.
+// It returns the containers whose children changed.
+func trackChanges(previous *snapshot, s *snapshot) []ObjectID {
+	old := previous.objects
+	var deleted []ObjectID
+	for id, object := range old {
+		switch object.(type) {
+		case *Container, *Item:
+			if s.get(id) == nil {
+				deleted = append(deleted, id)
+			}
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i] < deleted[j] })
+	deletedUpdateIDs := make(map[ObjectID]int)
+	for _, id := range deleted {
+		deletedUpdateIDs[id] = record("objDel", id, "", "")
+	}
+
+	var changed []ObjectID
+	updateIDs := make(map[ObjectID]int)
+	// changedIDs holds the objects added or modified by this rebuild.
+	changedIDs := make(map[ObjectID]bool)
+
+	var walk func(container *Container, parent *Container)
+	walk = func(container *Container, parent *Container) {
+		prev, existed := old[container.Id].(*Container)
+		switch {
+		case !existed:
+			parentID := ObjectID("-1")
+			if parent != nil {
+				parentID = parent.Id
+			}
+			container.ObjectUpdateID = record("objAdd", container.Id, parentID, container.Class)
+			container.ContainerUpdateID = container.ObjectUpdateID
+			changedIDs[container.Id] = true
+		case !sameContent(prev, container):
+			container.ContainerUpdateID = prev.ContainerUpdateID
+			container.TotalDeletedChildCount = prev.TotalDeletedChildCount
+			container.ObjectUpdateID = record("objMod", container.Id, container.ParentID, container.Class)
+			changedIDs[container.Id] = true
+		default:
+			container.ContainerUpdateID = prev.ContainerUpdateID
+			container.TotalDeletedChildCount = prev.TotalDeletedChildCount
+			container.ObjectUpdateID = prev.ObjectUpdateID
+		}
+
+		before := make(map[ObjectID]bool)
+		if existed {
+			for _, child := range prev.Children {
+				before[objectID(child)] = true
+			}
+		}
+		after := make(map[ObjectID]bool)
+		lastUpdateID := 0
+		modified := false
+		for _, child := range container.Children {
+			id := objectID(child)
+			after[id] = true
+			switch c := child.(type) {
+			case *Container:
+				walk(c, container)
+				if changedIDs[id] && c.ObjectUpdateID > lastUpdateID {
+					lastUpdateID = c.ObjectUpdateID
+				}
+			case *Item:
+				updateID, done := updateIDs[id]
+				if !done {
+					prevItem, ok := old[id].(*Item)
+					switch {
+					case !ok:
+						updateID = record("objAdd", id, container.Id, c.Class)
+						changedIDs[id] = true
+					case !sameContent(prevItem, c):
+						updateID = record("objMod", id, c.ParentID, c.Class)
+						changedIDs[id] = true
+					default:
+						updateID = prevItem.ObjectUpdateID
+					}
+					updateIDs[id] = updateID
+				}
+				c.ObjectUpdateID = updateID
+				if changedIDs[id] && updateID > lastUpdateID {
+					lastUpdateID = updateID
+				}
+			}
+			if !before[id] || changedIDs[id] {
+				modified = true
+			}
+		}
+		if container.lazy {
+			var members []member
+			if existed {
+				members = prev.members
+			}
+			if updateID := trackMembers(s, container, members, changedIDs); updateID > 0 {
+				modified = true
+				if updateID > lastUpdateID {
+					lastUpdateID = updateID
+				}
+			}
+		}
+		for id := range before {
+			if !after[id] {
+				container.TotalDeletedChildCount++
+				modified = true
+				if deletedUpdateIDs[id] > lastUpdateID {
+					lastUpdateID = deletedUpdateIDs[id]
+				}
+			}
+		}
+		if modified {
+			if lastUpdateID == 0 {
+				// A child moved in from another container.
+				lastUpdateID = GetSystemUpdateID()
+			}
+			containerModified(container, lastUpdateID)
+			changed = append(changed, container.Id)
+		}
+	}
+	walk(s.root, nil)
+	return changed
+}
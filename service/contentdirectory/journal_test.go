@@ -0,0 +1,73 @@
+package contentdirectory
+
+import (
+	"testing"
+	"time"
+)
+
+// viewReference returns a reference in the first genre view.
+func viewReference(t *testing.T) *Item {
+	t.Helper()
+	s := load()
+	genres := s.get("02").(*Container)
+	if len(genres.Children) == 0 {
+		t.Fatal("no genre view")
+	}
+	children := s.children(genres.Children[0].(*Container))
+	if len(children) == 0 {
+		t.Fatal("empty genre view")
+	}
+	return children[0].(*Item)
+}
+
+func TestRestartKeepsUpdateIDs(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &fakeSource{contents: []Content{fakeContent("1", start), fakeContent("2", start.Add(time.Hour))}}
+	setupTest(t, source)
+	if _, err := CreateReference("05", "01/1"); err != nil {
+		t.Fatal(err)
+	}
+	before := make(map[ObjectID]int)
+	for _, id := range []ObjectID{"0", "01", "01/1", "01/2", "05", "05/1"} {
+		switch o := GetObject(string(id)).(type) {
+		case *Container:
+			before[id] = o.ContainerUpdateID
+		case *Item:
+			before[id] = o.ObjectUpdateID
+		}
+	}
+	reference := viewReference(t)
+
+	restart()
+	if updateID, reserved := GetSystemUpdateID(), reservedUpdateID; updateID != reserved {
+		t.Errorf("a restart without changes took update IDs %d to %d", reserved, updateID)
+	}
+	for id, updateID := range before {
+		var after int
+		switch o := GetObject(string(id)).(type) {
+		case *Container:
+			after = o.ContainerUpdateID
+		case *Item:
+			after = o.ObjectUpdateID
+		}
+		if after != updateID {
+			t.Errorf("update ID of %s went from %d to %d on restart", id, updateID, after)
+		}
+	}
+	if after := viewReference(t); after.Id != reference.Id || after.ObjectUpdateID != reference.ObjectUpdateID {
+		t.Errorf("reference %s with objectUpdateID %d became %s with %d", reference.Id, reference.ObjectUpdateID, after.Id, after.ObjectUpdateID)
+	}
+
+	// Changes while the server was down are tracked as such.
+	source.set([]Content{fakeContent("1", start), fakeContent("3", start.Add(2*time.Hour))})
+	restart()
+	if GetObject("01/2") != nil || GetObject("01/3") == nil {
+		t.Fatal("changes of the Source missing after a restart")
+	}
+	if item := GetObject("01/1").(*Item); item.ObjectUpdateID != before["01/1"] {
+		t.Errorf("objectUpdateID of the unchanged 01/1 went from %d to %d", before["01/1"], item.ObjectUpdateID)
+	}
+	if container := GetObject("01").(*Container); container.ContainerUpdateID <= before["01"] || container.TotalDeletedChildCount != 1 {
+		t.Errorf("01 has containerUpdateID %d and totalDeletedChildCount %d after a change", container.ContainerUpdateID, container.TotalDeletedChildCount)
+	}
+}
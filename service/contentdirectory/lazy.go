@@ -0,0 +1,277 @@
+package contentdirectory
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The genre, channel and rule containers are views of the recordings in 01.
+// Rather than a reference per recording, a view holds a member per recording,
+// and makes the references only when a client browses it. The references made
+// are cached for a while, for the containers browsed most recently. Folders
+// are views too, with their subfolders as children ahead of the members.
+
+// childrenCacheSize is how many views keep their references at a time.
+const childrenCacheSize = 32
+
+// childrenCacheTTL is how long the references of a view are kept after it was
+// last browsed.
+const childrenCacheTTL = 10 * time.Minute
+
+// A member is a recording in a view.
+type member struct {
+	contentId ObjectID
+	// key orders the members: the start, newest first like the items in 01,
+	// or in folders the title.
+	key string
+	// updateID is the objectUpdateID of the reference.
+	updateID int
+}
+
+type childrenEntry struct {
+	container *Container
+	children  []interface{}
+	used      time.Time
+}
+
+var childrenCacheMu sync.Mutex
+var childrenCache = make(map[ObjectID]*childrenEntry)
+
+// children returns the children of container, making the references of a view
+// if they are not cached.
+func (s *snapshot) children(container *Container) []interface{} {
+	if !container.lazy {
+		return container.Children
+	}
+	now := time.Now()
+	childrenCacheMu.Lock()
+	// Each snapshot has its own copy of the container, so an entry made for
+	// another snapshot is of no use.
+	if e, ok := childrenCache[container.Id]; ok && e.container == container && now.Sub(e.used) < childrenCacheTTL {
+		e.used = now
+		childrenCacheMu.Unlock()
+		return e.children
+	}
+	childrenCacheMu.Unlock()
+
+	children := s.materialize(container)
+	childrenCacheMu.Lock()
+	defer childrenCacheMu.Unlock()
+	childrenCache[container.Id] = &childrenEntry{container: container, children: children, used: now}
+	evictChildren(now)
+	return children
+}
+
+// evictChildren drops the entries not used for childrenCacheTTL, and then the
+// least recently used ones until childrenCacheSize are left.
+func evictChildren(now time.Time) {
+	for id, e := range childrenCache {
+		if now.Sub(e.used) >= childrenCacheTTL {
+			delete(childrenCache, id)
+		}
+	}
+	for len(childrenCache) > childrenCacheSize {
+		var oldest ObjectID
+		for id, e := range childrenCache {
+			if oldest == "" || e.used.Before(childrenCache[oldest].used) {
+				oldest = id
+			}
+		}
+		delete(childrenCache, oldest)
+	}
+}
+
+// contentItem returns the item of a content, in whichever container of
+// contents it is.
+func (s *snapshot) contentItem(contentId ObjectID) (*Item, bool) {
+	for id := range contentContainers {
+		if item, ok := s.objects[itemID(id, contentId)].(*Item); ok {
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+// materialize makes the references of the members of a view, following the
+// subfolders of a folder.
+func (s *snapshot) materialize(container *Container) []interface{} {
+	children := make([]interface{}, 0, len(container.Children)+len(container.members))
+	children = append(children, container.Children...)
+	for _, m := range container.members {
+		item, ok := s.contentItem(m.contentId)
+		if !ok {
+			continue
+		}
+		reference := newReference(container.Id, item)
+		reference.ObjectUpdateID = m.updateID
+		children = append(children, reference)
+	}
+	return children
+}
+
+// getReference returns the reference id in a view, or nil if there is none.
+func (s *snapshot) getReference(id ObjectID) interface{} {
+	i := strings.LastIndexByte(string(id), '/')
+	if i < 0 {
+		return nil
+	}
+	container, ok := s.objects[id[:i]].(*Container)
+	if !ok || !container.lazy {
+		return nil
+	}
+	for _, child := range s.children(container) {
+		if item, ok := child.(*Item); ok && item.Id == id {
+			return item
+		}
+	}
+	return nil
+}
+
+// newView makes an empty view under parent, keeping the views in order.
+func newView(parent *Container, id ObjectID, title string) *Container {
+	container := NewContainer(id, parent, title)
+	container.lazy = true
+	sort.SliceStable(parent.Children, func(i, j int) bool {
+		return lessContainer(parent.Children[i], parent.Children[j])
+	})
+	return container
+}
+
+// newFolder makes an empty folder under parent, keeping the folders in order
+// of their titles.
+func newFolder(parent *Container, id ObjectID, title string) *Container {
+	container := NewContainer(id, parent, title)
+	container.Class = folderClass
+	container.lazy = true
+	sort.SliceStable(parent.Children, func(i, j int) bool {
+		return lessFolder(parent.Children[i], parent.Children[j])
+	})
+	return container
+}
+
+func lessFolder(a, b interface{}) bool {
+	ca, cb := a.(*Container), b.(*Container)
+	if ca.Title != cb.Title {
+		return ca.Title < cb.Title
+	}
+	return ca.Id < cb.Id
+}
+
+// addMember adds the recording of item to a view, newest first, or to a
+// folder by title.
+func addMember(container *Container, item *Item) {
+	m := member{contentId: item.ContentId, key: item.ScheduledStartTime}
+	less := newerMember
+	if container.Class == folderClass {
+		m.key = item.Title
+		less = lessMember
+	}
+	i := sort.Search(len(container.members), func(i int) bool {
+		return less(m, container.members[i])
+	})
+	container.members = append(container.members, member{})
+	copy(container.members[i+1:], container.members[i:])
+	container.members[i] = m
+	container.ChildCount = len(container.Children) + len(container.members)
+}
+
+func newerMember(a, b member) bool {
+	if a.key != b.key {
+		return a.key > b.key
+	}
+	return lessID(b.contentId, a.contentId)
+}
+
+func lessMember(a, b member) bool {
+	if a.key != b.key {
+		return a.key < b.key
+	}
+	return lessID(a.contentId, b.contentId)
+}
+
+// removeMembers removes a recording from the views under container, and
+// returns the IDs of the views it was removed from.
+func removeMembers(container *Container, contentId ObjectID) []ObjectID {
+	var removedFrom []ObjectID
+	for _, child := range container.Children {
+		if c, ok := child.(*Container); ok {
+			removedFrom = append(removedFrom, removeMembers(c, contentId)...)
+		}
+	}
+	if !container.lazy {
+		return removedFrom
+	}
+	members := make([]member, 0, len(container.members))
+	for _, m := range container.members {
+		if m.contentId != contentId {
+			members = append(members, m)
+		}
+	}
+	if len(members) < len(container.members) {
+		removedFrom = append(removedFrom, container.Id)
+	}
+	container.members = members
+	container.ChildCount = len(container.Children) + len(members)
+	return removedFrom
+}
+
+// forEachMember calls f for every view under container holding a recording,
+// along with its member.
+func forEachMember(container *Container, contentId ObjectID, f func(*Container, *member)) {
+	for _, child := range container.Children {
+		if c, ok := child.(*Container); ok {
+			forEachMember(c, contentId, f)
+		}
+	}
+	for i := range container.members {
+		if container.members[i].contentId == contentId {
+			f(container, &container.members[i])
+		}
+	}
+}
+
+// trackMembers records the references added to, changed in and removed from
+// a view since it had the members previous. changedIDs holds the items of
+// contents which changed. It returns the update ID of the last change, or 0 if there
+// was none.
+func trackMembers(s *snapshot, container *Container, previous []member, changedIDs map[ObjectID]bool) int {
+	before := make(map[ObjectID]int, len(previous))
+	for _, m := range previous {
+		before[m.contentId] = m.updateID
+	}
+	lastUpdateID := 0
+	for i := range container.members {
+		m := &container.members[i]
+		id := itemID(container.Id, m.contentId)
+		var masterID ObjectID
+		updateID, existed := before[m.contentId]
+		delete(before, m.contentId)
+		class := ""
+		if item, ok := s.contentItem(m.contentId); ok {
+			masterID, class = item.Id, item.Class
+		}
+		switch {
+		case !existed:
+			updateID = record("objAdd", id, container.Id, class)
+		case changedIDs[masterID]:
+			updateID = record("objMod", id, container.Id, class)
+		default:
+			m.updateID = updateID
+			continue
+		}
+		m.updateID = updateID
+		lastUpdateID = updateID
+	}
+	var removed []ObjectID
+	for contentId := range before {
+		removed = append(removed, contentId)
+	}
+	sort.Slice(removed, func(i, j int) bool { return lessID(removed[i], removed[j]) })
+	for _, contentId := range removed {
+		container.TotalDeletedChildCount++
+		lastUpdateID = record("objDel", itemID(container.Id, contentId), "", "")
+	}
+	return lastUpdateID
+}
@@ -0,0 +1,189 @@
+package contentdirectory
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// metadataFile persists what is looked up per resource, keyed by resource ID,
+// so that a restart needs not look it up again.
+const metadataFile = "metadata.json"
+
+// probeWorkers is how many resources are probed at once.
+const probeWorkers = 8
+
+// fileMetadata is what is known about a resource. Size, ModTime and Filename
+// tell whether the file is still the one the metadata is of: an encode
+// replacing it changes the size and name, and a file written over in place
+// its modification time. Sources which do not know the modification time
+// leave it zero.
+type fileMetadata struct {
+	Size     int       `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Filename string    `json:"filename"`
+	Probe
+}
+
+var metadataMu sync.Mutex
+var metadata = make(map[string]fileMetadata)
+
+func loadMetadata() {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	data, err := ioutil.ReadFile(statePath(metadataFile))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		log.Printf("ignoring broken %s: %s", metadataFile, err)
+	}
+}
+
+func saveMetadata() error {
+	metadataMu.Lock()
+	data, err := json.Marshal(metadata)
+	metadataMu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := statePath(metadataFile) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath(metadataFile))
+}
+
+// cachedMetadata returns the metadata of a resource, unless it is unknown or
+// of a file since replaced. The source may know it without a lookup, and there
+// is nothing to look up of a live stream.
+func cachedMetadata(resource Resource) (fileMetadata, bool) {
+	if resource.Duration > 0 || resource.Live {
+		return fileMetadata{Size: resource.Size, ModTime: resource.ModTime, Filename: resource.Filename, Probe: Probe{Duration: resource.Duration}}, true
+	}
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	m, ok := metadata[resource.ID]
+	if !ok || m.Size != resource.Size || !m.ModTime.Equal(resource.ModTime) || m.Filename != resource.Filename {
+		return fileMetadata{}, false
+	}
+	return m, true
+}
+
+// uncachedResources returns the resources of contents without valid
+// metadata.
+func uncachedResources(contents []Content) []Resource {
+	var resources []Resource
+	for _, content := range contents {
+		for _, resource := range content.Resources {
+			if _, ok := cachedMetadata(resource); !ok {
+				resources = append(resources, resource)
+			}
+		}
+	}
+	return resources
+}
+
+// cachedProbes returns the probes of the resources of contents known to the
+// cache, and whether it knows them all.
+func cachedProbes(contents []Content) (map[string]Probe, bool) {
+	probes := make(map[string]Probe)
+	complete := true
+	for _, content := range contents {
+		for _, resource := range content.Resources {
+			if m, ok := cachedMetadata(resource); ok {
+				probes[resource.ID] = m.Probe
+			} else {
+				complete = false
+			}
+		}
+	}
+	return probes, complete
+}
+
+// pruneMetadata forgets the metadata of resources no longer among contents.
+// It reports whether any was forgotten.
+func pruneMetadata(contents []Content) bool {
+	current := make(map[string]bool)
+	for _, content := range contents {
+		for _, resource := range content.Resources {
+			current[resource.ID] = true
+		}
+	}
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	pruned := false
+	for id := range metadata {
+		if !current[id] {
+			delete(metadata, id)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// fetchMetadata probes resources, probeWorkers at a time. A failed probe does
+// not stop the others, and what was found is saved either way.
+func fetchMetadata(resources []Resource) error {
+	if len(resources) == 0 {
+		return nil
+	}
+	var mu sync.Mutex
+	var firstErr error
+	jobs := make(chan Resource)
+	var wg sync.WaitGroup
+	for i := 0; i < probeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for resource := range jobs {
+				probe, err := Source.Probe(context.Background(), resource)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					continue
+				}
+				metadataMu.Lock()
+				metadata[resource.ID] = fileMetadata{Size: resource.Size, ModTime: resource.ModTime, Filename: resource.Filename, Probe: probe}
+				metadataMu.Unlock()
+			}
+		}()
+	}
+	for _, resource := range resources {
+		jobs <- resource
+	}
+	close(jobs)
+	wg.Wait()
+	if err := saveMetadata(); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+// warmMetadata looks up the metadata missing from the cache, without holding
+// up writers, and then syncs the contents which were published without it.
+func warmMetadata() error {
+	contents, err := Source.Contents(context.Background())
+	if err != nil {
+		return err
+	}
+	resources := uncachedResources(contents)
+	if len(resources) == 0 {
+		return nil
+	}
+	log.Printf("looking up metadata of %d resources", len(resources))
+	if err := fetchMetadata(resources); err != nil {
+		log.Printf("could not look up resource metadata: %s", err)
+	}
+	return Sync()
+}
@@ -0,0 +1,73 @@
+package contentdirectory
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// probingSource is a fakeSource which counts the probes of each resource.
+type probingSource struct {
+	*fakeSource
+	mu     sync.Mutex
+	probed map[string]int
+}
+
+func (p *probingSource) Probe(ctx context.Context, resource Resource) (Probe, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.probed[resource.ID]++
+	return Probe{Duration: time.Hour, Resolution: "1920x1080", Bitrate: 2000000, AudioChannels: 2, SampleFrequency: 48000}, nil
+}
+
+func (p *probingSource) count(id string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.probed[id]
+}
+
+func TestMetadataCache(t *testing.T) {
+	modTime := time.Date(2021, 1, 1, 21, 0, 0, 0, JST)
+	content := fakeContent("1", modTime)
+	content.Resources[0].Duration = 0
+	content.Resources[0].ModTime = modTime
+	source := &probingSource{fakeSource: &fakeSource{}, probed: make(map[string]int)}
+	source.set([]Content{content})
+	setupTest(t, source)
+	if err := Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := source.count("1"); n != 1 {
+		t.Fatalf("probed %d times, want 1", n)
+	}
+	result, _, _, _, err := Browse(string(recordedContainerID), false, "*", "", 0, 0, Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, attr := range []string{`duration="1:00:00`, `resolution="1920x1080"`, `bitrate="2000000"`, `nrAudioChannels="2"`, `sampleFrequency="48000"`} {
+		if !strings.Contains(result, attr) {
+			t.Errorf("res lacks %s: %s", attr, result)
+		}
+	}
+
+	// The probe is persisted, and not done again after a restart.
+	restart()
+	if err := Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := source.count("1"); n != 1 {
+		t.Errorf("probed %d times after a restart, want 1", n)
+	}
+
+	// A file written over in place keeps its size and name.
+	content.Resources[0].ModTime = modTime.Add(time.Minute)
+	source.set([]Content{content})
+	if err := Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := source.count("1"); n != 2 {
+		t.Errorf("probed %d times after the file changed, want 2", n)
+	}
+}
@@ -0,0 +1,144 @@
+package contentdirectory
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+// bookmarksFile persists the resume positions set by Samsung TVs with
+// X_SetBookmark, keyed by content ID.
+const bookmarksFile = "bookmarks.json"
+
+var bookmarksMu sync.Mutex
+var bookmarks = make(map[ObjectID]int)
+
+func loadBookmarks() {
+	bookmarksMu.Lock()
+	defer bookmarksMu.Unlock()
+	data, err := ioutil.ReadFile(statePath(bookmarksFile))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &bookmarks); err != nil {
+		log.Printf("ignoring broken %s: %s", bookmarksFile, err)
+	}
+}
+
+func saveBookmarks() error {
+	data, err := json.Marshal(bookmarks)
+	if err != nil {
+		return err
+	}
+	tmp := statePath(bookmarksFile) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath(bookmarksFile))
+}
+
+// dcmInfo returns the sec:dcmInfo of an item, which tells Samsung TVs where
+// to resume playback.
+func dcmInfo(id ObjectID) *string {
+	bookmarksMu.Lock()
+	defer bookmarksMu.Unlock()
+	pos, ok := bookmarks[id]
+	if !ok || pos == 0 {
+		return nil
+	}
+	info := fmt.Sprintf("BM=%d", pos)
+	return &info
+}
+
+// forEachItem calls f for the item of a recording and for every reference to
+// it, under any container.
+func forEachItem(container *Container, contentId ObjectID, f func(*Item)) {
+	for _, child := range container.Children {
+		switch c := child.(type) {
+		case *Container:
+			forEachItem(c, contentId, f)
+		case *Item:
+			if c.ContentId == contentId {
+				f(c)
+			}
+		}
+	}
+}
+
+// SetBookmark stores the position in seconds where playback of an item
+// stopped. A position of 0 removes the bookmark.
+func SetBookmark(objectID string, posSecond int) error {
+	return modify(func(s *snapshot) ([]ObjectID, error) {
+		item, ok := s.get(ObjectID(objectID)).(*Item)
+		if !ok {
+			return nil, ErrNoSuchObject
+		}
+		// Bookmarks are kept by recorded ID, so that every reference
+		// resumes at the same position.
+		id := item.ContentId
+		bookmarksMu.Lock()
+		if posSecond == 0 {
+			delete(bookmarks, id)
+		} else {
+			bookmarks[id] = posSecond
+		}
+		err := saveBookmarks()
+		bookmarksMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		info := dcmInfo(id)
+		forEachItem(s.root, id, func(item *Item) {
+			item.DcmInfo = info
+		})
+		itemModified(s, id)
+		return nil, nil
+	})
+}
+
+type featureContainer struct {
+	XMLName xml.Name `xml:"container"`
+	Id      ObjectID `xml:"id,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+type feature struct {
+	XMLName    xml.Name           `xml:"Feature"`
+	Name       string             `xml:"name,attr"`
+	Version    int                `xml:"version,attr"`
+	Containers []featureContainer `xml:"container"`
+}
+
+type featureList struct {
+	XMLName  xml.Name  `xml:"urn:schemas-upnp-org:av:avs Features"`
+	Features []feature `xml:"Feature"`
+}
+
+// MarshalFeatureList returns the GetFeatureList and X_GetFeatureList result.
+// Samsung TVs use the BASICVIEW feature to find the container holding every
+// video, instead of walking the whole tree. Only 01 is listed, as the media
+// files in 07 are of any class.
+func MarshalFeatureList() string {
+	list := featureList{
+		Features: []feature{{
+			Name:    "samsung.com_BASICVIEW",
+			Version: 1,
+			Containers: []featureContainer{
+				{Id: recordedContainerID, Type: "object.item.videoItem"},
+			},
+		}},
+	}
+	data, err := xml.Marshal(list)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(data)
+}
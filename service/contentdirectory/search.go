@@ -0,0 +1,483 @@
+package contentdirectory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchCapabilities lists the properties which may appear in SearchCriteria.
+var SearchCapabilities = []string{"dc:title", "upnp:class", "upnp:genre", "dc:date", "upnp:channelName", "dc:description", "upnp:objectUpdateID", "upnp:containerUpdateID"}
+
+// keywordSearchTimeout bounds how long Search waits for a KeywordSearcher.
+const keywordSearchTimeout = 10 * time.Second
+
+// numericProperties are compared as integers, so that Track Changes clients
+// can search for upnp:objectUpdateID > their last SystemUpdateID.
+var numericProperties = map[string]bool{
+	"upnp:objectUpdateID":    true,
+	"upnp:containerUpdateID": true,
+}
+
+// SearchCriteriaError reports a SearchCriteria string which is malformed or
+// uses a property not listed in SearchCapabilities.
+type SearchCriteriaError struct {
+	Criteria string
+	Reason   string
+}
+
+func (e *SearchCriteriaError) Error() string {
+	return fmt.Sprintf("invalid search criteria %q: %s", e.Criteria, e.Reason)
+}
+
+// searchExp is a node of a parsed SearchCriteria expression.
+type searchExp interface {
+	match(object interface{}) bool
+}
+
+type matchAll struct{}
+
+func (matchAll) match(object interface{}) bool {
+	return true
+}
+
+type logExp struct {
+	and         bool
+	left, right searchExp
+}
+
+func (e *logExp) match(object interface{}) bool {
+	if e.and {
+		return e.left.match(object) && e.right.match(object)
+	}
+	return e.left.match(object) || e.right.match(object)
+}
+
+type existsExp struct {
+	property string
+	exists   bool
+}
+
+func (e *existsExp) match(object interface{}) bool {
+	_, ok := propertyValue(object, e.property)
+	return ok == e.exists
+}
+
+type relExp struct {
+	property string
+	op       string
+	value    string
+
+	// keywordMatches holds the contents the Source returned for a
+	// dc:title contains clause, see resolveKeywords.
+	keywordMatches map[ObjectID]bool
+}
+
+func (e *relExp) match(object interface{}) bool {
+	v, ok := propertyValue(object, e.property)
+	switch e.op {
+	case "contains":
+		if item, isItem := object.(*Item); isItem && e.keywordMatches[item.ContentId] {
+			return true
+		}
+		return ok && strings.Contains(strings.ToLower(v), strings.ToLower(e.value))
+	case "doesnotcontain":
+		return !ok || !strings.Contains(strings.ToLower(v), strings.ToLower(e.value))
+	case "derivedfrom":
+		v, value := strings.ToLower(v), strings.ToLower(e.value)
+		return ok && (v == value || strings.HasPrefix(v, value+"."))
+	case "startswith":
+		return ok && strings.HasPrefix(strings.ToLower(v), strings.ToLower(e.value))
+	}
+	if !ok {
+		return e.op == "!="
+	}
+	c := strings.Compare(v, e.value)
+	switch {
+	case numericProperties[e.property]:
+		a, errA := strconv.Atoi(v)
+		b, errB := strconv.Atoi(e.value)
+		if errA != nil || errB != nil {
+			return false
+		}
+		c = a - b
+	case e.property != "dc:date":
+		c = strings.Compare(strings.ToLower(v), strings.ToLower(e.value))
+	}
+	switch e.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// propertyValue returns the value of a searchable property of a container or
+// an item, and whether the object has the property at all.
+func propertyValue(object interface{}, property string) (string, bool) {
+	switch o := object.(type) {
+	case *Container:
+		switch property {
+		case "dc:title":
+			return o.Title, true
+		case "upnp:class":
+			return o.Class, true
+		case "upnp:objectUpdateID":
+			return strconv.Itoa(o.ObjectUpdateID), true
+		case "upnp:containerUpdateID":
+			return strconv.Itoa(o.ContainerUpdateID), true
+		}
+	case *Item:
+		switch property {
+		case "dc:title":
+			return o.Title, true
+		case "upnp:class":
+			return o.Class, true
+		case "dc:date":
+			return o.Date, o.Date != ""
+		case "upnp:genre":
+			if o.Genre != nil {
+				return *o.Genre, true
+			}
+		case "upnp:channelName":
+			if o.ChannelName != nil {
+				return *o.ChannelName, true
+			}
+		case "dc:description":
+			if o.Description != nil {
+				return *o.Description, true
+			}
+		case "upnp:scheduledStartTime":
+			return o.ScheduledStartTime, o.ScheduledStartTime != ""
+		case "upnp:objectUpdateID":
+			return strconv.Itoa(o.ObjectUpdateID), true
+		}
+	}
+	return "", false
+}
+
+type searchToken struct {
+	text   string
+	quoted bool
+}
+
+func isRelOpChar(r rune) bool {
+	return r == '=' || r == '!' || r == '<' || r == '>'
+}
+
+func tokenizeSearchCriteria(criteria string) ([]searchToken, error) {
+	var tokens []searchToken
+	runes := []rune(criteria)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, searchToken{text: string(r)})
+			i++
+		case r == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, errors.New("unterminated quoted value")
+			}
+			tokens = append(tokens, searchToken{text: b.String(), quoted: true})
+			i++
+		case isRelOpChar(r):
+			j := i + 1
+			if j < len(runes) && runes[j] == '=' {
+				j++
+			}
+			op := string(runes[i:j])
+			if op == "!" {
+				return nil, errors.New("unknown operator !")
+			}
+			tokens = append(tokens, searchToken{text: op})
+			i = j
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !isRelOpChar(runes[j]) && runes[j] != '(' && runes[j] != ')' && runes[j] != '"' {
+				j++
+			}
+			tokens = append(tokens, searchToken{text: string(runes[i:j])})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type searchParser struct {
+	tokens []searchToken
+	pos    int
+	// capabilities are the properties the version of ContentDirectory the
+	// client invoked may search on.
+	capabilities []string
+}
+
+func (p *searchParser) peek() (searchToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return searchToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *searchParser) next() (searchToken, error) {
+	t, ok := p.peek()
+	if !ok {
+		return t, errors.New("unexpected end of criteria")
+	}
+	p.pos++
+	return t, nil
+}
+
+func (p *searchParser) acceptKeyword(keyword string) bool {
+	t, ok := p.peek()
+	if ok && !t.quoted && strings.EqualFold(t.text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// parseOr parses searchExp, where "and" binds tighter than "or".
+func (p *searchParser) parseOr() (searchExp, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logExp{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *searchParser) parseAnd() (searchExp, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("and") {
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logExp{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *searchParser) parsePrimary() (searchExp, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if !t.quoted && t.text == "(" {
+		exp, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, err := p.next(); err != nil || t.quoted || t.text != ")" {
+			return nil, errors.New("missing )")
+		}
+		return exp, nil
+	}
+	return p.parseRelExp(t)
+}
+
+func (p *searchParser) parseRelExp(property searchToken) (searchExp, error) {
+	if property.quoted {
+		return nil, fmt.Errorf("expected property, got %q", property.text)
+	}
+	supported := false
+	for _, capability := range p.capabilities {
+		supported = supported || capability == property.text
+	}
+	if !supported {
+		return nil, fmt.Errorf("unsupported property %s", property.text)
+	}
+	opToken, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	op := strings.ToLower(opToken.text)
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case opToken.quoted:
+		return nil, fmt.Errorf("expected operator, got %q", opToken.text)
+	case op == "exists":
+		// The grammar allows only these two spellings.
+		if value.quoted || (value.text != "true" && value.text != "false") {
+			return nil, fmt.Errorf("exists requires true or false, got %q", value.text)
+		}
+		return &existsExp{property: property.text, exists: value.text == "true"}, nil
+	case !value.quoted:
+		return nil, fmt.Errorf("expected quoted value, got %s", value.text)
+	}
+	switch op {
+	case "=", "!=", "<", "<=", ">", ">=", "contains", "doesnotcontain", "derivedfrom", "startswith":
+		return &relExp{property: property.text, op: op, value: value.text}, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", opToken.text)
+}
+
+func parseSearchCriteria(criteria string, version int) (searchExp, error) {
+	if strings.TrimSpace(criteria) == "*" {
+		return matchAll{}, nil
+	}
+	tokens, err := tokenizeSearchCriteria(criteria)
+	if err != nil {
+		return nil, &SearchCriteriaError{criteria, err.Error()}
+	}
+	p := &searchParser{tokens: tokens, capabilities: Capabilities(SearchCapabilities, version)}
+	exp, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, &SearchCriteriaError{criteria, err.Error()}
+	}
+	return exp, nil
+}
+
+// resolveKeywords asks a Source which is a KeywordSearcher for the contents
+// matching every dc:title contains clause. EPGStation, for one, normalizes
+// full-width and half-width characters, which a plain substring match on the
+// title cannot do. The searches end with ctx or after keywordSearchTimeout.
+func resolveKeywords(ctx context.Context, exp searchExp) {
+	searcher, ok := Source.(KeywordSearcher)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, keywordSearchTimeout)
+	defer cancel()
+	resolveKeywordsWith(ctx, searcher, exp)
+}
+
+func resolveKeywordsWith(ctx context.Context, searcher KeywordSearcher, exp searchExp) {
+	switch e := exp.(type) {
+	case *logExp:
+		resolveKeywordsWith(ctx, searcher, e.left)
+		resolveKeywordsWith(ctx, searcher, e.right)
+	case *relExp:
+		if e.property != "dc:title" || e.op != "contains" {
+			return
+		}
+		ids, err := searcher.SearchKeyword(ctx, e.value)
+		if err != nil {
+			log.Printf("keyword search for %q failed, falling back to title match: %v", e.value, err)
+			return
+		}
+		e.keywordMatches = make(map[ObjectID]bool)
+		for _, id := range ids {
+			e.keywordMatches[ObjectID(id)] = true
+		}
+	}
+}
+
+// seenObjects are the containers, by ID, and the recordings, by ContentId,
+// collectDescendants has already collected.
+type seenObjects struct {
+	containers map[ObjectID]bool
+	contents   map[ObjectID]bool
+}
+
+// collectDescendants appends every object below container, skipping objects
+// already seen under another parent.
+func collectDescendants(s *snapshot, container *Container, seen seenObjects, objects []interface{}) []interface{} {
+	if container.lazy && allSeen(container, seen.contents) {
+		// Nothing new, so there is no need to make the references.
+		return objects
+	}
+	for _, child := range s.children(container) {
+		switch c := child.(type) {
+		case *Container:
+			if !seen.containers[c.Id] {
+				seen.containers[c.Id] = true
+				objects = append(objects, c)
+			}
+			objects = collectDescendants(s, c, seen, objects)
+		case *Item:
+			// A reference is the same recording as the item it refers to.
+			if !seen.contents[c.ContentId] {
+				seen.contents[c.ContentId] = true
+				objects = append(objects, c)
+			}
+		}
+	}
+	return objects
+}
+
+// allSeen reports whether every recording in a view is in seen. Folders with
+// subfolders have more to see.
+func allSeen(container *Container, seen map[ObjectID]bool) bool {
+	if len(container.Children) > 0 {
+		return false
+	}
+	for _, m := range container.members {
+		if !seen[m.contentId] {
+			return false
+		}
+	}
+	return true
+}
+
+// Search returns the DIDL-Lite of the objects below containerID matching
+// criteria in SortCriteria order, along with NumberReturned and TotalMatches.
+// The DIDL-Lite has the properties the version of ContentDirectory the client
+// invoked knows, which also limits the properties criteria may use. ctx is the
+// context of the request.
+func Search(ctx context.Context, containerID string, criteria string, Filter string, SortCriteria string, StartingIndex int, RequestedCount int, version int) (string, int, int, int, error) {
+	s := load()
+	container, ok := s.get(ObjectID(containerID)).(*Container)
+	if !ok {
+		return "", 0, 0, 0, ErrNoSuchContainer
+	}
+	exp, err := parseSearchCriteria(criteria, version)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+	keys, err := parseSortCriteria(SortCriteria)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+	resolveKeywords(ctx, exp)
+
+	var matches []interface{}
+	for _, object := range collectDescendants(s, container, seenObjects{make(map[ObjectID]bool), make(map[ObjectID]bool)}, nil) {
+		if exp.match(object) {
+			matches = append(matches, object)
+		}
+	}
+	sortObjects(matches, keys)
+
+	result, returned := marshalPage(matches, Filter, StartingIndex, RequestedCount, version)
+	return result, returned, len(matches), s.updateID, nil
+}
@@ -0,0 +1,183 @@
+package contentdirectory
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseExists(t *testing.T) {
+	for _, criteria := range []string{"upnp:genre exists true", "upnp:genre exists false"} {
+		if _, err := parseSearchCriteria(criteria, Version); err != nil {
+			t.Errorf("parse %q: %v", criteria, err)
+		}
+	}
+	for _, criteria := range []string{"upnp:genre exists 1", "upnp:genre exists TRUE", "upnp:genre exists t", `upnp:genre exists "true"`} {
+		if _, err := parseSearchCriteria(criteria, Version); err == nil {
+			t.Errorf("parse %q: want error", criteria)
+		}
+	}
+}
+
+// searchObjects are matched against the criteria of TestSearchCriteria.
+func searchObjects() []interface{} {
+	news, drama := "ニュース・報道", "ドラマ"
+	quoted := `彼は "やあ" と言った`
+	return []interface{}{
+		&Container{
+			Id:                "01",
+			Title:             "録画済み",
+			Class:             "object.container.storageFolder",
+			ObjectUpdateID:    3,
+			ContainerUpdateID: 12,
+		},
+		&Item{
+			Id:             "01/1",
+			Title:          "ニュース7",
+			Class:          "object.item.videoItem",
+			Date:           "2021-01-02",
+			Genre:          &news,
+			Description:    &quoted,
+			ObjectUpdateID: 9,
+		},
+		&Item{
+			Id:             "01/2",
+			Title:          "Drama",
+			Class:          "object.item.videoItem.movie",
+			Date:           "2021-01-10",
+			Genre:          &drama,
+			ObjectUpdateID: 10,
+		},
+		&Item{
+			Id:             "01/3",
+			Title:          "Radio",
+			Class:          "object.item.audioItem",
+			ObjectUpdateID: 11,
+		},
+	}
+}
+
+func TestSearchCriteria(t *testing.T) {
+	for _, test := range []struct {
+		criteria string
+		want     []ObjectID
+	}{
+		{"*", []ObjectID{"01", "01/1", "01/2", "01/3"}},
+
+		{`dc:title = "drama"`, []ObjectID{"01/2"}},
+		{`dc:title != "drama"`, []ObjectID{"01", "01/1", "01/3"}},
+		{`dc:date = "2021-01-02"`, []ObjectID{"01/1"}},
+		{`dc:date != "2021-01-02"`, []ObjectID{"01", "01/2", "01/3"}},
+		{`dc:date < "2021-01-10"`, []ObjectID{"01/1"}},
+		{`dc:date <= "2021-01-10"`, []ObjectID{"01/1", "01/2"}},
+		{`dc:date > "2021-01-02"`, []ObjectID{"01/2"}},
+		{`dc:date >= "2021-01-02"`, []ObjectID{"01/1", "01/2"}},
+		// Update IDs compare as numbers, 10 is after 9.
+		{`upnp:objectUpdateID > "9"`, []ObjectID{"01/2", "01/3"}},
+		{`upnp:objectUpdateID >= "9"`, []ObjectID{"01/1", "01/2", "01/3"}},
+		{`upnp:objectUpdateID < "10"`, []ObjectID{"01", "01/1"}},
+		{`upnp:objectUpdateID <= "10"`, []ObjectID{"01", "01/1", "01/2"}},
+		{`upnp:objectUpdateID = "10"`, []ObjectID{"01/2"}},
+		{`upnp:containerUpdateID > "11"`, []ObjectID{"01"}},
+		{`dc:title contains "ニュース"`, []ObjectID{"01/1"}},
+		{`dc:title contains "DRAMA"`, []ObjectID{"01/2"}},
+		{`dc:title doesNotContain "a"`, []ObjectID{"01", "01/1"}},
+		{`upnp:genre doesNotContain "ドラマ"`, []ObjectID{"01", "01/1", "01/3"}},
+		{`upnp:class derivedfrom "object.item.videoItem"`, []ObjectID{"01/1", "01/2"}},
+		{`upnp:class derivedFrom "object.container"`, []ObjectID{"01"}},
+		// derivedfrom matches whole class names only.
+		{`upnp:class derivedfrom "object.item.video"`, nil},
+		{`upnp:genre exists true`, []ObjectID{"01/1", "01/2"}},
+		{`upnp:genre exists false`, []ObjectID{"01", "01/3"}},
+
+		// and binds tighter than or.
+		{`dc:title = "Radio" or upnp:class derivedfrom "object.item.videoItem" and dc:date > "2021-01-05"`, []ObjectID{"01/2", "01/3"}},
+		{`upnp:class derivedfrom "object.item.videoItem" and dc:date > "2021-01-05" or dc:title = "Radio"`, []ObjectID{"01/2", "01/3"}},
+		{`(dc:title = "Radio" or upnp:class derivedfrom "object.item.videoItem") and dc:date > "2021-01-05"`, []ObjectID{"01/2"}},
+		{`upnp:class derivedfrom "object.item" AND (upnp:genre exists false OR dc:title contains "7")`, []ObjectID{"01/1", "01/3"}},
+		{`((dc:title = "Drama"))`, []ObjectID{"01/2"}},
+		{`dc:description = "彼は \"やあ\" と言った"`, []ObjectID{"01/1"}},
+		{`dc:title="Drama"and dc:date>="2021-01-10"`, []ObjectID{"01/2"}},
+	} {
+		exp, err := parseSearchCriteria(test.criteria, Version)
+		if err != nil {
+			t.Errorf("parse %q: %v", test.criteria, err)
+			continue
+		}
+		var got []ObjectID
+		for _, object := range searchObjects() {
+			if exp.match(object) {
+				switch o := object.(type) {
+				case *Container:
+					got = append(got, o.Id)
+				case *Item:
+					got = append(got, o.Id)
+				}
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s matched %v, want %v", test.criteria, got, test.want)
+		}
+	}
+}
+
+func TestInvalidSearchCriteria(t *testing.T) {
+	for _, criteria := range []string{
+		"",
+		"dc:title",
+		`dc:title =`,
+		`dc:title = drama`,
+		`dc:title = "drama`,
+		`dc:title ~ "drama"`,
+		`dc:title ! "drama"`,
+		`"dc:title" = "drama"`,
+		`dc:title "=" "drama"`,
+		`dc:creator = "drama"`,
+		`(dc:title = "drama"`,
+		`dc:title = "drama")`,
+		`dc:title = "drama" and`,
+		`dc:title = "drama" xor dc:date = "2021-01-02"`,
+		`and dc:title = "drama"`,
+		`()`,
+	} {
+		_, err := parseSearchCriteria(criteria, Version)
+		var criteriaErr *SearchCriteriaError
+		if !errors.As(err, &criteriaErr) {
+			t.Errorf("parse %q: want a SearchCriteriaError, got %v", criteria, err)
+		}
+	}
+}
+
+func TestSearchCapabilitiesOfVersion(t *testing.T) {
+	for _, criteria := range []string{`upnp:objectUpdateID > "1"`, `upnp:containerUpdateID > "1"`} {
+		if _, err := parseSearchCriteria(criteria, 3); err != nil {
+			t.Errorf("parse %q at version 3: %v", criteria, err)
+		}
+		for _, version := range []int{1, 2} {
+			if _, err := parseSearchCriteria(criteria, version); err == nil {
+				t.Errorf("parse %q at version %d: want error", criteria, version)
+			}
+		}
+	}
+}
+
+func TestCollectDescendantsOfSameIDs(t *testing.T) {
+	// A container and a recording may have the same ID, which must not hide
+	// one another.
+	root := NewContainer("0", nil, "Root")
+	recorded := NewContainer("01", root, "録画済み")
+	recorded.AppendItem(&Item{Id: "01/01", ParentID: "01", Title: "番組", Class: "object.item.videoItem", ContentId: "01"})
+	recorded.AppendItem(&Item{Id: "01/x", ParentID: "01", Title: "番組", Class: "object.item.videoItem", ContentId: "01"})
+
+	s := newSnapshot(root)
+	objects := collectDescendants(s, root, seenObjects{make(map[ObjectID]bool), make(map[ObjectID]bool)}, nil)
+	if len(objects) != 2 {
+		t.Fatalf("want the container and one item, got %d objects", len(objects))
+	}
+	if c, ok := objects[0].(*Container); !ok || c.Id != "01" {
+		t.Errorf("want container 01 first, got %+v", objects[0])
+	}
+	if item, ok := objects[1].(*Item); !ok || item.ContentId != "01" {
+		t.Errorf("want the recording 01, got %+v", objects[1])
+	}
+}
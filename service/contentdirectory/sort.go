@@ -0,0 +1,101 @@
+package contentdirectory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SortCapabilities lists the properties which may appear in SortCriteria.
+var SortCapabilities = []string{"dc:title", "dc:date", "upnp:genre", "upnp:channelName", "upnp:scheduledStartTime", "res@duration"}
+
+// SortCriteriaError reports a SortCriteria string which is malformed or uses
+// a property not listed in SortCapabilities.
+type SortCriteriaError struct {
+	Criteria string
+	Reason   string
+}
+
+func (e *SortCriteriaError) Error() string {
+	return fmt.Sprintf("invalid sort criteria %q: %s", e.Criteria, e.Reason)
+}
+
+type sortKey struct {
+	property   string
+	descending bool
+}
+
+func parseSortCriteria(criteria string) ([]sortKey, error) {
+	var keys []sortKey
+	if strings.TrimSpace(criteria) == "" {
+		return keys, nil
+	}
+	for _, field := range strings.Split(criteria, ",") {
+		field = strings.TrimSpace(field)
+		var key sortKey
+		switch {
+		case strings.HasPrefix(field, "+"):
+			key.property = field[1:]
+		case strings.HasPrefix(field, "-"):
+			key.property, key.descending = field[1:], true
+		default:
+			// The direction is not optional.
+			return nil, &SortCriteriaError{criteria, fmt.Sprintf("%q lacks + or -", field)}
+		}
+		supported := false
+		for _, capability := range SortCapabilities {
+			supported = supported || capability == key.property
+		}
+		if !supported {
+			return nil, &SortCriteriaError{criteria, fmt.Sprintf("unsupported property %q", key.property)}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// compareProperty orders two objects by one property. Objects without the
+// property sort before objects with it.
+func compareProperty(a, b interface{}, property string) int {
+	if property == "res@duration" {
+		da, db := resourceDuration(a), resourceDuration(b)
+		switch {
+		case da < db:
+			return -1
+		case da > db:
+			return 1
+		}
+		return 0
+	}
+	va, _ := propertyValue(a, property)
+	vb, _ := propertyValue(b, property)
+	return strings.Compare(va, vb)
+}
+
+func resourceDuration(object interface{}) int64 {
+	item, ok := object.(*Item)
+	if !ok || item.Resources == nil || len(*item.Resources) == 0 {
+		return -1
+	}
+	return int64((*item.Resources)[0].DurationNS)
+}
+
+// sortObjects sorts objects in place. Objects which compare equal on every
+// key keep their original order.
+func sortObjects(objects []interface{}, keys []sortKey) {
+	if len(keys) == 0 {
+		return
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		for _, key := range keys {
+			c := compareProperty(objects[i], objects[j], key.property)
+			if key.descending {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,97 @@
+package contentdirectory
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSortCriteria(t *testing.T) {
+	for _, test := range []struct {
+		criteria string
+		want     []sortKey
+	}{
+		{"", nil},
+		{"+dc:title", []sortKey{{"dc:title", false}}},
+		{"-dc:date", []sortKey{{"dc:date", true}}},
+		{"-dc:date,+dc:title", []sortKey{{"dc:date", true}, {"dc:title", false}}},
+		{" +upnp:genre , -res@duration ", []sortKey{{"upnp:genre", false}, {"res@duration", true}}},
+	} {
+		keys, err := parseSortCriteria(test.criteria)
+		if err != nil {
+			t.Errorf("parse %q: %v", test.criteria, err)
+			continue
+		}
+		if !reflect.DeepEqual(keys, test.want) {
+			t.Errorf("parse %q: got %v, want %v", test.criteria, keys, test.want)
+		}
+	}
+}
+
+func TestInvalidSortCriteria(t *testing.T) {
+	for _, criteria := range []string{
+		"dc:title",
+		"+dc:title,dc:date",
+		"+dc:creator",
+		"-upnp:class",
+		"+",
+		"+dc:title,",
+		"*dc:title",
+	} {
+		_, err := parseSortCriteria(criteria)
+		var sortErr *SortCriteriaError
+		if !errors.As(err, &sortErr) {
+			t.Errorf("parse %q: want a SortCriteriaError, got %v", criteria, err)
+		}
+	}
+}
+
+func TestSortObjects(t *testing.T) {
+	news, drama := "ニュース", "ドラマ"
+	item := func(id ObjectID, title string, date string, genre *string, duration time.Duration) *Item {
+		return &Item{
+			Id:        id,
+			Title:     title,
+			Date:      date,
+			Genre:     genre,
+			Resources: &[]Res{{DurationNS: duration}},
+		}
+	}
+	objects := func() []interface{} {
+		return []interface{}{
+			item("1", "B", "2021-01-02", &news, time.Hour),
+			item("2", "A", "2021-01-01", &drama, time.Minute),
+			item("3", "C", "2021-01-02", nil, time.Second),
+			item("4", "A", "2021-01-03", &news, time.Minute),
+		}
+	}
+	for _, test := range []struct {
+		criteria string
+		want     []ObjectID
+	}{
+		{"", []ObjectID{"1", "2", "3", "4"}},
+		{"+dc:title", []ObjectID{"2", "4", "1", "3"}},
+		{"-dc:title", []ObjectID{"3", "1", "2", "4"}},
+		{"+dc:title,-dc:date", []ObjectID{"4", "2", "1", "3"}},
+		{"-dc:date,+dc:title", []ObjectID{"4", "1", "3", "2"}},
+		// Objects without the property sort first.
+		{"+upnp:genre,+dc:title", []ObjectID{"3", "2", "4", "1"}},
+		{"-res@duration", []ObjectID{"1", "2", "4", "3"}},
+	} {
+		keys, err := parseSortCriteria(test.criteria)
+		if err != nil {
+			t.Errorf("parse %q: %v", test.criteria, err)
+			continue
+		}
+		sorted := objects()
+		sortObjects(sorted, keys)
+		var got []ObjectID
+		for _, object := range sorted {
+			got = append(got, object.(*Item).Id)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("sorted by %q: got %v, want %v", test.criteria, got, test.want)
+		}
+	}
+}
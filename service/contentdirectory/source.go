@@ -0,0 +1,168 @@
+package contentdirectory
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// A ContentSource is the backend the content directory is built from. The
+// sync engine lists its contents every pass and diffs them with the tree, so
+// a source needs not track what changed itself.
+type ContentSource interface {
+	// Contents returns every content, newest first.
+	Contents(ctx context.Context) ([]Content, error)
+	// Probe looks up what reading a resource whose Duration is 0 tells of it.
+	// The result is cached as long as the Size, ModTime and Filename stay
+	// the same.
+	Probe(ctx context.Context, resource Resource) (Probe, error)
+	// ServeResource streams a resource. The Range header of r is set for
+	// time seek requests.
+	ServeResource(w http.ResponseWriter, r *http.Request, resourceID string)
+	// Delete deletes a content for good. Sources which cannot return
+	// ErrRestrictedObject.
+	Delete(ctx context.Context, contentID string) error
+}
+
+// A KeywordSearcher is a ContentSource which finds contents by keyword
+// better than a match of the title, as a dc:title contains search.
+type KeywordSearcher interface {
+	SearchKeyword(ctx context.Context, keyword string) ([]string, error)
+}
+
+// A ChangeNotifier is a ContentSource which tells when its contents changed,
+// so that they are synced without waiting for the next poll.
+type ChangeNotifier interface {
+	Changes(ctx context.Context) <-chan struct{}
+}
+
+// A Content is an item of a ContentSource, such as a recording.
+type Content struct {
+	// ID is unique within the source and stays the same as long as the
+	// content exists. It must not contain a slash.
+	ID              string
+	Title           string
+	Description     *string
+	LongDescription *string
+	Genre           *string
+	ChannelName     *string
+	Start           time.Time
+	End             time.Time
+	// Thumbnail is the URL of an image of the content, if any.
+	Thumbnail string
+	// Protected contents are never deleted.
+	Protected bool
+	// Class is the upnp:class of the item, object.item.videoItem if empty.
+	Class string
+	// Kind is the container of the item, ContentRecording if empty.
+	Kind      ContentKind
+	Resources []Resource
+	// Views are the views besides the container of the item the content is
+	// in.
+	Views []View
+	// Folder is the path of folders under 06 the content is in, from the
+	// top. Contents with no Folder are in no folder.
+	Folder []Folder
+}
+
+// A Resource is a file of a content.
+type Resource struct {
+	// ID is unique within the source. It must not contain a slash.
+	ID       string
+	Filename string
+	Size     int
+	// ModTime is when the file was last written, or zero if the source does
+	// not know.
+	ModTime time.Time
+	// Duration is 0 when the source must look it up with Probe.
+	Duration time.Duration
+	// Live resources are streams without a size or a duration, which
+	// cannot be seeked.
+	Live bool
+}
+
+// A Probe is what reading a resource tells of it. Values which are unknown
+// are zero.
+type Probe struct {
+	Duration time.Duration `json:"duration"`
+	// Resolution is the width and height of a video or an image, such as
+	// "1920x1080".
+	Resolution string `json:"resolution,omitempty"`
+	// Bitrate is in bytes per second, as in DIDL-Lite.
+	Bitrate         int `json:"bitrate,omitempty"`
+	AudioChannels   int `json:"audioChannels,omitempty"`
+	SampleFrequency int `json:"sampleFrequency,omitempty"`
+}
+
+// A ContentKind is the container the item of a content is in. Everywhere else
+// the content appears as references to that item.
+type ContentKind ObjectID
+
+const (
+	// ContentRecording is the kind of recorded broadcasts, which are all
+	// video.
+	ContentRecording ContentKind = "01"
+	// ContentFile is the kind of media files, of any class.
+	ContentFile ContentKind = "07"
+	// ContentLive is the kind of live channels.
+	ContentLive ContentKind = "08"
+)
+
+// A ViewKind is the container a view is in.
+type ViewKind ObjectID
+
+const (
+	ViewGenre   ViewKind = "02"
+	ViewChannel ViewKind = "03"
+	ViewRule    ViewKind = "04"
+)
+
+// A View is a container grouping contents by genre, channel or rule.
+type View struct {
+	Kind ViewKind
+	// Key tells the views of a kind apart. It must not be empty or contain a
+	// slash.
+	Key   string
+	Title string
+}
+
+func (v View) id() ObjectID {
+	return ObjectID(v.Kind) + ObjectID(v.Key)
+}
+
+// A Folder is a container of contents and of further folders, such as a
+// directory.
+type Folder struct {
+	// Key is unique among the folders of every source, and stays the same
+	// as long as the folder does. It must not be empty or contain a slash.
+	Key   string
+	Title string
+}
+
+func (f Folder) id() ObjectID {
+	return foldersContainerID + ObjectID(f.Key)
+}
+
+// Source is where contents come from. It must be set before Setup.
+var Source ContentSource
+
+// GenreNames are the names of the ARIB content genres of broadcasts, by the
+// major category.
+var GenreNames = map[int]string{
+	0x0: "ニュース・報道",
+	0x1: "スポーツ",
+	0x2: "情報・ワイドショー",
+	0x3: "ドラマ",
+	0x4: "音楽",
+	0x5: "バラエティ",
+	0x6: "映画",
+	0x7: "アニメ・特撮",
+	0x8: "ドキュメンタリー・教養",
+	0x9: "劇場・公演",
+	0xa: "趣味・教育",
+	0xb: "福祉",
+	0xc: "予備",
+	0xd: "予備",
+	0xe: "拡張",
+	0xf: "その他",
+}
@@ -0,0 +1,129 @@
+package contentdirectory
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// A snapshot is a complete content tree along with an index of its containers
+// and items by ObjectID, and of the resources of items by video file ID. Once
+// published a snapshot is never changed, so readers may use it without
+// locking. Writers build a new snapshot off to the side and publish it in one
+// step.
+type snapshot struct {
+	root      *Container
+	objects   map[ObjectID]interface{}
+	resources map[ObjectID]*Res
+	// updateID is the SystemUpdateID the snapshot was published at.
+	updateID int
+}
+
+// published holds the current *snapshot.
+var published atomic.Value
+
+// writeMu serializes writers, so that none of them loses the change of
+// another by copying a snapshot which is about to be replaced.
+var writeMu sync.Mutex
+
+// load returns the current snapshot, which is empty before Setup.
+func load() *snapshot {
+	s, ok := published.Load().(*snapshot)
+	if !ok {
+		return newSnapshot(nil)
+	}
+	return s
+}
+
+// get returns the object id, or nil if there is none. The references in views
+// are made if need be.
+func (s *snapshot) get(id ObjectID) interface{} {
+	if object, ok := s.objects[id]; ok {
+		return object
+	}
+	return s.getReference(id)
+}
+
+// newSnapshot indexes the tree under root.
+func newSnapshot(root *Container) *snapshot {
+	s := &snapshot{root: root, objects: make(map[ObjectID]interface{}), resources: make(map[ObjectID]*Res)}
+	if root != nil {
+		s.objects[root.Id] = root
+		s.index(root)
+	}
+	return s
+}
+
+func (s *snapshot) index(container *Container) {
+	for _, child := range container.Children {
+		switch c := child.(type) {
+		case *Container:
+			s.objects[c.Id] = c
+			s.index(c)
+		case *Item:
+			s.objects[c.Id] = c
+			if c.Resources != nil && c.RefID == nil {
+				for i := range *c.Resources {
+					res := &(*c.Resources)[i]
+					s.resources[res.ResourceId] = res
+				}
+			}
+		}
+	}
+}
+
+// copyTree copies the containers, items and members under container, so that
+// they can be changed without touching a published snapshot. Resources are
+// shared, as nothing changes them.
+func copyTree(container *Container) *Container {
+	if container == nil {
+		return nil
+	}
+	c := *container
+	c.members = append([]member(nil), container.members...)
+	c.Children = make([]interface{}, len(container.Children))
+	for i, child := range container.Children {
+		switch o := child.(type) {
+		case *Container:
+			c.Children[i] = copyTree(o)
+		case *Item:
+			item := *o
+			c.Children[i] = &item
+		}
+	}
+	return &c
+}
+
+// errUnchanged is returned by the function passed to modify when there is
+// nothing to change.
+var errUnchanged = errors.New("unchanged")
+
+// modify runs f on a copy of the current snapshot. Unless f fails, the copy is
+// published and listeners are told about the containers f returns.
+func modify(f func(s *snapshot) ([]ObjectID, error)) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	return modifyLocked(f)
+}
+
+// modifyLocked is modify for writers already holding writeMu.
+func modifyLocked(f func(s *snapshot) ([]ObjectID, error)) error {
+	s := newSnapshot(copyTree(load().root))
+	containerIDs, err := f(s)
+	if err == errUnchanged {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Every change is recorded under writeMu, so the SystemUpdateID is the
+	// one of the last change in s.
+	s.updateID = GetSystemUpdateID()
+	published.Store(s)
+	if err := saveTree(s); err != nil {
+		log.Printf("could not save %s: %s", treeFile, err)
+	}
+	notifyUpdate(s, containerIDs)
+	return nil
+}
@@ -0,0 +1,98 @@
+package contentdirectory
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// testTree returns a tree of n recordings, numbered from first.
+func testTree(first int, n int) *Container {
+	root := NewContainer("0", nil, "Root")
+	recorded := NewContainer("01", root, "録画済み")
+	for i := first; i < first+n; i++ {
+		id := strconv.Itoa(i)
+		recorded.AppendItem(&Item{
+			Id:         itemID(recorded.Id, ObjectID(id)),
+			ParentID:   recorded.Id,
+			Title:      "番組" + id,
+			Class:      "object.item.videoItem",
+			Restricted: "true",
+			Date:       "2021-01-01",
+		})
+	}
+	return root
+}
+
+// TestModifyWhileBrowsing browses, searches and looks up objects while writers
+// replace the tree, for go test -race. Every answer must be of one snapshot.
+func TestModifyWhileBrowsing(t *testing.T) {
+	// The journal and the tree are saved in StateDir.
+	StateDir = t.TempDir()
+
+	writeMu.Lock()
+	published.Store(newSnapshot(testTree(0, 1)))
+	writeMu.Unlock()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			err := modify(func(s *snapshot) ([]ObjectID, error) {
+				old := load()
+				*s = *newSnapshot(testTree(i, i%10+1))
+				return trackChanges(old, s), nil
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lastUpdateID := 0
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for _, id := range []string{"0", "01"} {
+					_, returned, total, updateID, err := Browse(id, false, "*", "", 0, 0, Version)
+					if err != nil {
+						t.Errorf("browsing %s: %s", id, err)
+						return
+					}
+					if returned != total {
+						t.Errorf("browsing %s returned %d of %d", id, returned, total)
+					}
+					if updateID < lastUpdateID {
+						t.Errorf("UpdateID went from %d to %d", lastUpdateID, updateID)
+					}
+					lastUpdateID = updateID
+					if _, _, _, _, err := Browse(id, true, "*", "", 0, 0, Version); err != nil {
+						t.Errorf("browsing metadata of %s: %s", id, err)
+					}
+				}
+				_, returned, total, _, err := Search(context.Background(), "0", `upnp:class derivedfrom "object.item"`, "*", "", 0, 0, Version)
+				if err != nil {
+					t.Errorf("searching: %s", err)
+					return
+				}
+				if returned != total {
+					t.Errorf("search returned %d of %d", returned, total)
+				}
+				GetObject("01/1")
+			}
+		}()
+	}
+	wg.Wait()
+}
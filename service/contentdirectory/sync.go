@@ -0,0 +1,445 @@
+package contentdirectory
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The sync engine keeps the tree in step with the Source. Each pass lists the
+// contents, diffs them by content ID with those the tree was built from and
+// applies the additions, updates and removals to a copy of the tree. The
+// journal then bumps the update IDs of what changed.
+
+// syncInterval is how often RunSync polls the Source.
+const syncInterval = 1 * time.Minute
+
+// contentsTimeout is how long a pass waits for the Source to list its
+// contents.
+const contentsTimeout = 30 * time.Second
+
+// A recording is a content of the Source as the tree last saw it.
+type recording struct {
+	// fingerprint is the JSON of the content, which changes with any edit
+	// and with every resource added by an encode.
+	fingerprint string
+	// views are the containers besides that of its item the content is in.
+	views []ObjectID
+	// complete tells whether the metadata of every resource was known.
+	complete bool
+}
+
+// synced holds the contents the tree was built from, by content ID, and
+// viewTitles the titles of the views of the last pass. Only writers use them.
+var synced = make(map[ObjectID]recording)
+var viewTitles = make(map[ObjectID]string)
+
+var syncRunning int32
+
+// syncMu serializes passes, so that an older listing is never applied over a
+// newer one. Passes list the Source holding only syncMu, not writeMu, so that
+// other writers go on meanwhile.
+var syncMu sync.Mutex
+
+// passed tells whether a pass has listed the Source. syncMu guards it.
+var passed bool
+
+// RunSync warms the metadata cache, then polls the Source every syncInterval,
+// or whenever a ChangeNotifier tells of a change, and applies what changed,
+// until ctx is done. Only one loop runs at a time; further calls return at once.
+func RunSync(ctx context.Context) {
+	if !atomic.CompareAndSwapInt32(&syncRunning, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&syncRunning, 0)
+	if err := warmMetadata(); err != nil {
+		log.Printf("warming resource metadata failed: %s", err)
+	}
+	var changes <-chan struct{}
+	if notifier, ok := Source.(ChangeNotifier); ok {
+		changes = notifier.Changes(ctx)
+	}
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changes:
+		}
+		if err := Sync(); err != nil {
+			log.Printf("sync failed: %s", err)
+		}
+	}
+}
+
+// Sync applies the changes of the Source since the last pass to the tree. The
+// tree is copied only when something changed.
+func Sync() error {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), contentsTimeout)
+	defer cancel()
+	contents, err := Source.Contents(ctx)
+	if err != nil {
+		return err
+	}
+	if passed {
+		// The first pass makes do with the cache, and RunSync warms it
+		// afterwards. Later passes find few resources missing from it.
+		if err := fetchMetadata(uncachedResources(contents)); err != nil {
+			log.Printf("could not look up resource metadata: %s", err)
+		}
+	}
+	passed = true
+	if pruneMetadata(contents) {
+		if err := saveMetadata(); err != nil {
+			log.Printf("could not save %s: %s", metadataFile, err)
+		}
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	d, err := diffContents(contents)
+	if err != nil {
+		return err
+	}
+	if load().root != nil && d.empty() {
+		return nil
+	}
+	return modifyLocked(func(s *snapshot) ([]ObjectID, error) {
+		return syncSnapshot(s, d)
+	})
+}
+
+// viewsOf returns the IDs of the views a content is in, leaving out those of
+// an unknown kind.
+func viewsOf(content *Content) []ObjectID {
+	var ids []ObjectID
+	for _, v := range content.Views {
+		switch v.Kind {
+		case ViewGenre, ViewChannel, ViewRule:
+			ids = append(ids, v.id())
+		}
+	}
+	return ids
+}
+
+func sameIDs(a, b []ObjectID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// recordedContainerID is the container of every recording, filesContainerID
+// of every media file and liveContainerID of every live channel. Everywhere
+// else contents appear as references to their items. The containers of files
+// and of live channels are only there while they have items.
+const (
+	recordedContainerID = ObjectID(ContentRecording)
+	filesContainerID    = ObjectID(ContentFile)
+	liveContainerID     = ObjectID(ContentLive)
+)
+
+// contentContainers are the titles of the containers of the items of
+// contents.
+var contentContainers = map[ObjectID]string{
+	recordedContainerID: "録画済み",
+	filesContainerID:    "ファイル",
+	liveContainerID:     "ライブ",
+}
+
+// contentContainerID returns the container of the item of content.
+func contentContainerID(content *Content) ObjectID {
+	id := ObjectID(content.Kind)
+	if _, ok := contentContainers[id]; !ok {
+		return recordedContainerID
+	}
+	return id
+}
+
+// foldersContainerID is the container of the folders of contents. It is only
+// there while some content is in a folder.
+const foldersContainerID = ObjectID("06")
+
+const folderClass = "object.container.storageFolder"
+
+// newTree returns the containers every tree starts with.
+func newTree() *Container {
+	root := NewContainer("0", nil, "Root")
+	NewContainer(recordedContainerID, root, contentContainers[recordedContainerID])
+	NewContainer("02", root, "ジャンル別")
+	NewContainer("03", root, "チャンネル別")
+	NewContainer("04", root, "ルール別")
+	favoritesContainer := NewContainer(favoritesContainerID, root, "お気に入り")
+	favoritesContainer.Restricted = "false"
+	return root
+}
+
+func childContainer(parent *Container, id ObjectID) *Container {
+	for _, child := range parent.Children {
+		if c, ok := child.(*Container); ok && c.Id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// lessID orders numeric ObjectIDs sharing a prefix, such as 021 and 0210.
+func lessID(a, b ObjectID) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// insertChild inserts child into container before the first child it sorts
+// before, keeping the children in order.
+func insertChild(container *Container, child interface{}, less func(a, b interface{}) bool) {
+	i := sort.Search(len(container.Children), func(i int) bool {
+		return less(child, container.Children[i])
+	})
+	container.Children = append(container.Children, nil)
+	copy(container.Children[i+1:], container.Children[i:])
+	container.Children[i] = child
+	container.ChildCount = len(container.Children)
+}
+
+// newerItem orders recordings newest first.
+func newerItem(a, b interface{}) bool {
+	ia, ib := a.(*Item), b.(*Item)
+	if ia.ScheduledStartTime != ib.ScheduledStartTime {
+		return ia.ScheduledStartTime > ib.ScheduledStartTime
+	}
+	return lessID(ib.ContentId, ia.ContentId)
+}
+
+func lessContainer(a, b interface{}) bool {
+	return lessID(a.(*Container).Id, b.(*Container).Id)
+}
+
+// topContainer returns the container id under root, creating it with title
+// among the others in order if it does not exist yet.
+func topContainer(root *Container, id ObjectID, title string) *Container {
+	if container := childContainer(root, id); container != nil {
+		return container
+	}
+	container := NewContainer(id, root, title)
+	sort.SliceStable(root.Children, func(i, j int) bool {
+		return lessContainer(root.Children[i], root.Children[j])
+	})
+	return container
+}
+
+// addRecording adds the item of a content to the container of its kind and
+// the content to its views, creating the views it is the first content of.
+func addRecording(root *Container, content *Content, probes map[string]Probe) {
+	parentID := contentContainerID(content)
+	item := newItem(parentID, content, probes)
+	insertChild(topContainer(root, parentID, contentContainers[parentID]), item, newerItem)
+	for _, id := range viewsOf(content) {
+		parent := childContainer(root, id[:2])
+		container := childContainer(parent, id)
+		if container == nil {
+			container = newView(parent, id, viewTitles[id])
+		}
+		addMember(container, item)
+	}
+	if len(content.Folder) > 0 {
+		addMember(folderOf(root, content.Folder), item)
+	}
+}
+
+// folderOf returns the container of the folder at path, creating the folders
+// on the way which do not exist yet.
+func folderOf(root *Container, path []Folder) *Container {
+	container := topContainer(root, foldersContainerID, "フォルダ")
+	for _, f := range path {
+		folder := childContainer(container, f.id())
+		if folder == nil {
+			folder = newFolder(container, f.id(), f.Title)
+		}
+		container = folder
+	}
+	return container
+}
+
+// pruneFolders removes the folders under container left without contents or
+// subfolders. It reports whether container is left empty itself.
+func pruneFolders(container *Container) bool {
+	children := container.Children[:0]
+	for _, child := range container.Children {
+		if pruneFolders(child.(*Container)) {
+			continue
+		}
+		children = append(children, child)
+	}
+	container.Children = children
+	container.ChildCount = len(children) + len(container.members)
+	return container.ChildCount == 0
+}
+
+// pruneViews removes the views, folders and containers of files and live
+// channels left without contents and renames the views whose channel or rule was renamed.
+func pruneViews(root *Container) {
+	for _, parentID := range []ObjectID{"02", "03", "04"} {
+		parent := childContainer(root, parentID)
+		children := parent.Children[:0]
+		for _, child := range parent.Children {
+			container := child.(*Container)
+			if container.ChildCount == 0 {
+				continue
+			}
+			container.Title = viewTitles[container.Id]
+			children = append(children, child)
+		}
+		parent.Children = children
+		parent.ChildCount = len(children)
+	}
+	folders := childContainer(root, foldersContainerID)
+	children := root.Children[:0]
+	for _, child := range root.Children {
+		container := child.(*Container)
+		switch {
+		case container == folders && pruneFolders(folders):
+		case container.Id != recordedContainerID && contentContainers[container.Id] != "" && container.ChildCount == 0:
+		default:
+			children = append(children, child)
+		}
+	}
+	root.Children = children
+	root.ChildCount = len(children)
+}
+
+// refreshFavorites puts the references of the お気に入り container in the order
+// of the favorites, leaving out contents which are gone. They are kept among
+// the favorites in case they show up again.
+func refreshFavorites(root *Container) {
+	favoritesContainer := childContainer(root, favoritesContainerID)
+	recordings := make(map[ObjectID]*Item)
+	for id := range contentContainers {
+		container := childContainer(root, id)
+		if container == nil {
+			continue
+		}
+		for _, child := range container.Children {
+			item := child.(*Item)
+			recordings[item.ContentId] = item
+		}
+	}
+	favoritesContainer.Children = favoritesContainer.Children[:0]
+	favoritesContainer.ChildCount = 0
+	for _, f := range favorites {
+		if item, ok := recordings[f.RefID]; ok {
+			newFavorite(favoritesContainer, item, f.Title)
+		}
+	}
+}
+
+// A syncDiff is what changed in the Source since the last pass.
+type syncDiff struct {
+	contents []Content
+	// current are the recordings of contents and titles the titles of
+	// their views, which the pass leaves in synced and viewTitles.
+	current        map[ObjectID]recording
+	titles         map[ObjectID]string
+	added, updated []*Content
+	removed        []ObjectID
+}
+
+func (d *syncDiff) empty() bool {
+	return len(d.added)+len(d.updated)+len(d.removed) == 0
+}
+
+// diffContents compares contents with those the tree was built from. It must
+// be called holding writeMu.
+func diffContents(contents []Content) (*syncDiff, error) {
+	d := &syncDiff{
+		contents: contents,
+		current:  make(map[ObjectID]recording, len(contents)),
+		titles:   make(map[ObjectID]string),
+	}
+	for i := range contents {
+		content := &contents[i]
+		id := ObjectID(content.ID)
+		if _, ok := d.current[id]; ok {
+			log.Printf("skipping content %s listed twice", id)
+			continue
+		}
+		data, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range content.Views {
+			d.titles[v.id()] = v.Title
+		}
+		_, complete := cachedProbes(contents[i : i+1])
+		r := recording{fingerprint: string(data), views: viewsOf(content), complete: complete}
+		d.current[id] = r
+		previous, ok := synced[id]
+		switch {
+		case !ok:
+			d.added = append(d.added, content)
+		case previous.fingerprint != r.fingerprint || !sameIDs(previous.views, r.views) || previous.complete != r.complete:
+			d.updated = append(d.updated, content)
+		}
+	}
+	for id := range synced {
+		if _, ok := d.current[id]; !ok {
+			d.removed = append(d.removed, id)
+		}
+	}
+	return d, nil
+}
+
+// applyDiff applies d to the tree under root, or to a new tree if root is nil,
+// and returns the root.
+func applyDiff(root *Container, d *syncDiff) *Container {
+	probes, _ := cachedProbes(d.contents)
+	viewTitles = d.titles
+
+	if root == nil {
+		root = newTree()
+	}
+	for _, id := range d.removed {
+		removeRecording(root, id)
+	}
+	for _, content := range d.updated {
+		removeRecording(root, ObjectID(content.ID))
+		addRecording(root, content, probes)
+	}
+	for _, content := range d.added {
+		addRecording(root, content, probes)
+	}
+	synced = d.current
+	pruneViews(root)
+	refreshFavorites(root)
+	return root
+}
+
+// syncSnapshot applies d to a copy of the tree and has the journal track what
+// changed.
+func syncSnapshot(s *snapshot, d *syncDiff) ([]ObjectID, error) {
+	s.root = applyDiff(s.root, d)
+	log.Printf("synced: %d added, %d updated, %d removed", len(d.added), len(d.updated), len(d.removed))
+
+	old := load()
+	*s = *newSnapshot(s.root)
+	containerIDs := trackChanges(old, s)
+	if len(old.objects) == 0 {
+		// Nobody has seen the tree yet, there is nothing to tell about.
+		flushChanges()
+	}
+	return containerIDs, nil
+}
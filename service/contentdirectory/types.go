@@ -3,17 +3,17 @@ package contentdirectory
 import (
 	"encoding/xml"
 	"fmt"
-	"go-upnp-playground/epgstation"
 	"log"
+	"net/url"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type ObjectID string
 
 var JST = time.FixedZone("Asia/Tokyo", 9*60*60)
-var registory = make(map[ObjectID]interface{})
 
 type Container struct {
 	XMLName xml.Name `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ container"`
@@ -26,42 +26,73 @@ type Container struct {
 
 	ChildCount int           `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ childCount,attr"`
 	Children   []interface{} `xml:"-"`
+
+	// A lazy container is a view, whose children are made from its members
+	// when browsed, see lazy.go.
+	lazy    bool
+	members []member
+
+	// Track Changes properties, see journal.go.
+	ObjectUpdateID         int `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ objectUpdateID"`
+	ContainerUpdateID      int `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ containerUpdateID"`
+	TotalDeletedChildCount int `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ totalDeletedChildCount"`
 }
 
 func (c *Container) AppendContainer(child *Container) {
 	c.Children = append(c.Children, child)
 	c.ChildCount++
-	registory[child.Id] = child
 }
 
 func (c *Container) AppendItem(item *Item) {
 	c.Children = append(c.Children, item)
 	c.ChildCount++
-	registory[item.Id] = item
 }
 
 type Item struct {
 	XMLName xml.Name `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ item"`
 
-	Id         ObjectID `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ id,attr"`
-	ParentID   ObjectID `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ parentID,attr"`
-	Title      string   `xml:"http://purl.org/dc/elements/1.1/ title"`
-	Class      string   `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ class"`
-	Restricted string   `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ restricted,attr"`
+	Id         ObjectID  `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ id,attr"`
+	ParentID   ObjectID  `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ parentID,attr"`
+	Title      string    `xml:"http://purl.org/dc/elements/1.1/ title"`
+	Class      string    `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ class"`
+	Restricted string    `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ restricted,attr"`
+	RefID      *ObjectID `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ refID,attr"`
+
+	Date        string  `xml:"http://purl.org/dc/elements/1.1/ date,omitempty"`
+	Genre       *string `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ genre"`
+	ChannelName *string `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ channelName"`
 
-	Date      string `xml:"http://purl.org/dc/elements/1.1/ date"`
-	Resources *[]Res
+	Description        *string `xml:"http://purl.org/dc/elements/1.1/ description"`
+	LongDescription    *string `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ longDescription"`
+	ScheduledStartTime string  `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ scheduledStartTime,omitempty"`
+	ScheduledEndTime   string  `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ scheduledEndTime,omitempty"`
+	Resources          *[]Res
 
 	AlbumArtURI *string `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ albumArtURI"`
+
+	// DcmInfo carries the resume position for Samsung TVs, see samsung.go.
+	DcmInfo *string `xml:"http://www.sec.co.kr/ dcmInfo"`
+
+	ObjectUpdateID int `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ objectUpdateID"`
+
+	// ContentId is the ID of the content in the source, shared by the item
+	// and every reference to it.
+	ContentId ObjectID `xml:"-"`
 }
 
 type Res struct {
-	XMLName      xml.Name      `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ res"`
-	ProtocolInfo string        `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ protocolInfo,attr"`
-	Size         int           `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ size,attr"`
-	Duration     string        `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ duration,attr"`
-	DurationNS   time.Duration `xml:"-"`
-	URL          string        `xml:",chardata"`
+	XMLName      xml.Name `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ res"`
+	ProtocolInfo string   `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ protocolInfo,attr"`
+	Size         int      `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ size,attr,omitempty"`
+	Duration     string   `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ duration,attr,omitempty"`
+	Resolution   string   `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ resolution,attr,omitempty"`
+	Bitrate      int      `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ bitrate,attr,omitempty"`
+	// NrAudioChannels and SampleFrequency are of audio.
+	NrAudioChannels int           `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ nrAudioChannels,attr,omitempty"`
+	SampleFrequency int           `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ sampleFrequency,attr,omitempty"`
+	DurationNS      time.Duration `xml:"-"`
+	ResourceId      ObjectID      `xml:"-"`
+	URL             string        `xml:",chardata"`
 }
 
 // <DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">
@@ -88,18 +119,17 @@ func NewContainer(Id ObjectID, Parent *Container, Title string) *Container {
 		Children:   make([]interface{}, 0),
 		ChildCount: 0,
 	}
-	registory[container.Id] = container
 	if Parent != nil {
 		Parent.AppendContainer(container)
 	}
 	return container
 }
 
-func fmtProtocolInfo(videoFile *epgstation.VideoFile) (string, error) {
+func fmtProtocolInfo(resource *Resource) (string, error) {
 	var mime, pn, op, ci string
 
-	switch filepath.Ext(*videoFile.Filename) {
-	case ".m2ts":
+	switch strings.ToLower(filepath.Ext(resource.Filename)) {
+	case ".m2ts", ".ts":
 		mime = "video/mpeg"
 		pn = "MPEG_PS_NTSC"
 		op = "10"
@@ -114,10 +144,34 @@ func fmtProtocolInfo(videoFile *epgstation.VideoFile) (string, error) {
 		pn = "AVC_MKV_HP_HD_AAC_MULT5"
 		op = "01"
 		ci = "1"
+	case ".mp3":
+		mime = "audio/mpeg"
+		pn = "MP3"
+		op = "01"
+		ci = "0"
+	case ".m4a":
+		mime = "audio/mp4"
+		pn = "AAC_ISO_320"
+		op = "01"
+		ci = "0"
+	case ".jpg", ".jpeg":
+		mime = "image/jpeg"
+		pn = "JPEG_LRG"
+		op = "00"
+		ci = "0"
+	case ".png":
+		mime = "image/png"
+		pn = "PNG_LRG"
+		op = "00"
+		ci = "0"
 	default:
-		return "", fmt.Errorf("unknown filetype %s", filepath.Ext(*videoFile.Filename))
+		return "", fmt.Errorf("unknown filetype %s", filepath.Ext(resource.Filename))
 	}
-	return fmt.Sprintf("http-get:*:%s:DLNA_ORG.PN=%s;DLNA.ORG_OP=%s;DLNA.ORG_CI=%s;DLNA.ORG_FLAGS=01118000000000000000000000000000", mime, pn, op, ci), nil
+	if resource.Live {
+		// A live stream can be neither seeked by range nor by time.
+		op = "00"
+	}
+	return fmt.Sprintf("http-get:*:%s:DLNA.ORG_PN=%s;DLNA.ORG_OP=%s;DLNA.ORG_CI=%s;DLNA.ORG_FLAGS=01118000000000000000000000000000", mime, pn, op, ci), nil
 }
 
 func fmtDuration(d time.Duration) string {
@@ -132,47 +186,92 @@ func fmtDuration(d time.Duration) string {
 	return fmt.Sprintf("%d:%02d:%02d.%03d", h, m, s, ms)
 }
 
-func NewResource(videoFile *epgstation.VideoFile, duration time.Duration) Res {
-	protocolInfo, err := fmtProtocolInfo(videoFile)
+func NewResource(resource *Resource, probe Probe) (Res, error) {
+	protocolInfo, err := fmtProtocolInfo(resource)
 	if err != nil {
-		log.Fatal(err)
+		return Res{}, err
 	}
 	res := Res{
-		ProtocolInfo: protocolInfo,
-		URL:          fmt.Sprintf("%svideos/recorded?videoFileId=%d", serviceURLBase, videoFile.Id),
-		Size:         videoFile.Size,
-		Duration:     fmtDuration(duration),
-		DurationNS:   duration,
+		ProtocolInfo:    protocolInfo,
+		URL:             fmt.Sprintf("%svideos/recorded?videoFileId=%s", serviceURLBase, url.QueryEscape(resource.ID)),
+		Size:            resource.Size,
+		Resolution:      probe.Resolution,
+		Bitrate:         probe.Bitrate,
+		NrAudioChannels: probe.AudioChannels,
+		SampleFrequency: probe.SampleFrequency,
+		DurationNS:      probe.Duration,
+		ResourceId:      ObjectID(resource.ID),
+	}
+	// The duration is unknown until the metadata cache is warm.
+	if probe.Duration > 0 {
+		res.Duration = fmtDuration(probe.Duration)
 	}
-	objectId := strconv.Itoa(int(videoFile.Id))
-	registory[ObjectID(objectId)] = &res
-	return res
+	return res, nil
 }
 
-func NewItem(Parent *Container, recordedItem *epgstation.RecordedItem, videoFileIdDurationMap map[epgstation.VideoFileId]time.Duration) *Item {
-	if Parent == nil {
-		log.Fatal("container is required for item")
-	}
+// itemID returns the ObjectID of the item of a content in the container
+// parentID. Container IDs never contain a slash, so the ID is unique to the
+// container path and stays the same as long as the content does.
+func itemID(parentID ObjectID, contentId ObjectID) ObjectID {
+	return parentID + "/" + contentId
+}
 
-	resources := make([]Res, len(*recordedItem.VideoFiles))
-	for i, videoFile := range *recordedItem.VideoFiles {
-		resources[i] = NewResource(&videoFile, videoFileIdDurationMap[videoFile.Id])
+// newItem makes the item of a content in the container parentID without
+// adding it there. Resources of unknown types are left out.
+func newItem(parentID ObjectID, content *Content, probes map[string]Probe) *Item {
+	resources := make([]Res, 0, len(content.Resources))
+	for i := range content.Resources {
+		res, err := NewResource(&content.Resources[i], probes[content.Resources[i].ID])
+		if err != nil {
+			log.Printf("leaving out resource %s of %s: %s", content.Resources[i].ID, content.ID, err)
+			continue
+		}
+		resources = append(resources, res)
 	}
+	contentId := ObjectID(content.ID)
 	item := &Item{
-		Id:         ObjectID(strconv.Itoa(int(recordedItem.Id))),
-		ParentID:   Parent.Id,
-		Title:      recordedItem.Name,
+		Id:         itemID(parentID, contentId),
+		ParentID:   parentID,
+		Title:      content.Title,
 		Class:      "object.item.videoItem",
-		Restricted: "true",
+		Restricted: strconv.FormatBool(!AllowDestroyObject || content.Protected),
 
 		Resources: &resources,
 
-		Date: time.Unix(int64(recordedItem.StartAt)/1000, 0).In(JST).Format("2006-01-02"),
+		Genre:       content.Genre,
+		ChannelName: content.ChannelName,
+
+		Description:     content.Description,
+		LongDescription: content.LongDescription,
+
+		ContentId: contentId,
+	}
+	// A live channel with no program on air has no start or end.
+	if !content.Start.IsZero() {
+		item.Date = content.Start.In(JST).Format("2006-01-02")
+		item.ScheduledStartTime = content.Start.In(JST).Format(time.RFC3339)
+	}
+	if !content.End.IsZero() {
+		item.ScheduledEndTime = content.End.In(JST).Format(time.RFC3339)
 	}
-	if len(*recordedItem.Thumbnails) > 0 {
-		albumArtURI := fmt.Sprintf("%s/thumbnails/%d", epgstation.ServerAPIRoot, (*recordedItem.Thumbnails)[0])
+	if content.Class != "" {
+		item.Class = content.Class
+	}
+	if content.Thumbnail != "" {
+		albumArtURI := content.Thumbnail
 		item.AlbumArtURI = &albumArtURI
 	}
-	Parent.AppendItem(item)
+	item.DcmInfo = dcmInfo(contentId)
 	return item
 }
+
+// newReference makes a reference to item in the container parentID without
+// adding it there.
+func newReference(parentID ObjectID, item *Item) *Item {
+	refID := item.Id
+	reference := *item
+	reference.Id = itemID(parentID, item.ContentId)
+	reference.ParentID = parentID
+	reference.RefID = &refID
+	return &reference
+}
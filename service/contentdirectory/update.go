@@ -0,0 +1,140 @@
+package contentdirectory
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrInvalidCurrentTagValue = errors.New("invalid currentTagValue")
+	ErrInvalidNewTagValue     = errors.New("invalid newTagValue")
+	ErrRequiredTag            = errors.New("required tag")
+	ErrReadOnlyTag            = errors.New("read only tag")
+	ErrParameterMismatch      = errors.New("parameter mismatch")
+)
+
+// splitTagValueList splits a CSV list of XML fragments as used by
+// UpdateObject, where commas inside a fragment are escaped as "\,".
+func splitTagValueList(list string) []string {
+	var values []string
+	var value strings.Builder
+	for i := 0; i < len(list); i++ {
+		switch {
+		case list[i] == '\\' && i+1 < len(list) && list[i+1] == ',':
+			value.WriteByte(',')
+			i++
+		case list[i] == ',':
+			values = append(values, value.String())
+			value.Reset()
+		default:
+			value.WriteByte(list[i])
+		}
+	}
+	return append(values, value.String())
+}
+
+// A tagValue is one fragment of a tag value list such as
+// "<dc:title>News</dc:title>". An empty fragment has no property.
+type tagValue struct {
+	property string
+	value    string
+}
+
+func parseTagValue(fragment string) (tagValue, bool) {
+	if strings.TrimSpace(fragment) == "" {
+		return tagValue{}, true
+	}
+	// The dc and upnp prefixes are not declared in the fragment, so the
+	// decoder leaves them in Name.Space.
+	d := xml.NewDecoder(strings.NewReader(fragment))
+	var tag tagValue
+	depth := 0
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return tagValue{}, false
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth > 0 || tag.property != "" {
+				return tagValue{}, false
+			}
+			depth++
+			tag.property = t.Name.Local
+			if t.Name.Space != "" {
+				tag.property = t.Name.Space + ":" + t.Name.Local
+			}
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 1 {
+				tag.value += string(t)
+			}
+		}
+	}
+	return tag, tag.property != ""
+}
+
+// UpdateObject changes the properties of an object. Only the dc:title of the
+// references in the お気に入り container can be changed.
+func UpdateObject(objectID string, currentTagValue string, newTagValue string) error {
+	return modify(func(s *snapshot) ([]ObjectID, error) {
+		return updateObject(s, objectID, currentTagValue, newTagValue)
+	})
+}
+
+func updateObject(s *snapshot, objectID string, currentTagValue string, newTagValue string) ([]ObjectID, error) {
+	object := s.get(ObjectID(objectID))
+	if object == nil {
+		return nil, ErrNoSuchObject
+	}
+	item, ok := object.(*Item)
+	if !ok || item.Restricted == "true" {
+		return nil, ErrRestrictedObject
+	}
+	currents, news := splitTagValueList(currentTagValue), splitTagValueList(newTagValue)
+	if len(currents) != len(news) {
+		return nil, ErrParameterMismatch
+	}
+
+	title := item.Title
+	for i := range currents {
+		current, ok := parseTagValue(currents[i])
+		if !ok {
+			return nil, ErrInvalidCurrentTagValue
+		}
+		next, ok := parseTagValue(news[i])
+		if !ok {
+			return nil, ErrInvalidNewTagValue
+		}
+		property := current.property
+		if property == "" {
+			property = next.property
+		}
+		switch {
+		case current.property != "" && next.property != "" && current.property != next.property:
+			return nil, ErrParameterMismatch
+		case property != "dc:title" || item.ParentID != favoritesContainerID:
+			// Recordings are never changed, even when they may be deleted.
+			return nil, ErrReadOnlyTag
+		case current.property == "" || current.value != title:
+			return nil, ErrInvalidCurrentTagValue
+		case next.property == "" || next.value == "":
+			return nil, ErrRequiredTag
+		}
+		title = next.value
+	}
+
+	if err := renameFavorite(s, item.ContentId, title); err != nil {
+		return nil, err
+	}
+	updateID := record("objMod", item.Id, item.ParentID, item.Class)
+	item.ObjectUpdateID = updateID
+	containerModified(s.get(favoritesContainerID).(*Container), updateID)
+	return []ObjectID{favoritesContainerID}, nil
+}
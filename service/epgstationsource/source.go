@@ -0,0 +1,319 @@
+// Package epgstationsource makes the recordings of EPGStation the contents of
+// the content directory.
+package epgstationsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-upnp-playground/epgstation"
+	"go-upnp-playground/service/contentdirectory"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// recordedPageSize is how many recordings each GetRecorded request asks for.
+const recordedPageSize = 100
+
+// rulePageSize is how many rule keywords each GetRulesKeyword request asks for.
+const rulePageSize = 100
+
+// requestInterval spaces the requests to EPGStation, so that a sync of a
+// large library does not swamp it.
+const requestInterval = 5 * time.Millisecond
+
+var errRecordedChanged = errors.New("recordings changed while paging")
+
+// A Source is the recordings of EPGStation. epgstation.Setup must have been
+// called before it is used.
+type Source struct {
+	requestTicker *time.Ticker
+
+	// channelsMu guards channels, which are looked up again when a
+	// recording is on a channel not seen before.
+	channelsMu sync.Mutex
+	channels   map[epgstation.ChannelId]epgstation.ChannelItem
+}
+
+func New() *Source {
+	return &Source{requestTicker: time.NewTicker(requestInterval)}
+}
+
+// throttle waits until the next request to EPGStation may be made.
+func (s *Source) throttle() {
+	<-s.requestTicker.C
+}
+
+// Contents returns every recording along with its genres, channel and rule.
+func (s *Source) Contents(ctx context.Context) ([]contentdirectory.Content, error) {
+	recordedItems, err := s.fetchRecorded(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	keywords, err := s.fetchRuleKeywords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := s.channelsOf(ctx, recordedItems)
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]contentdirectory.Content, len(recordedItems))
+	for i := range recordedItems {
+		contents[i] = newContent(&recordedItems[i], channels, keywords)
+	}
+	return contents, nil
+}
+
+func newContent(recordedItem *epgstation.RecordedItem, channels map[epgstation.ChannelId]epgstation.ChannelItem, keywords map[epgstation.RuleId]string) contentdirectory.Content {
+	content := contentdirectory.Content{
+		ID:              strconv.Itoa(int(recordedItem.Id)),
+		Title:           recordedItem.Name,
+		Description:     recordedItem.Description,
+		LongDescription: recordedItem.Extended,
+		Start:           time.Unix(int64(recordedItem.StartAt)/1000, 0),
+		End:             time.Unix(int64(recordedItem.EndAt)/1000, 0),
+		Protected:       recordedItem.IsProtected,
+	}
+	if recordedItem.VideoFiles != nil {
+		for _, videoFile := range *recordedItem.VideoFiles {
+			resource := contentdirectory.Resource{
+				ID:   strconv.Itoa(int(videoFile.Id)),
+				Size: videoFile.Size,
+			}
+			if videoFile.Filename != nil {
+				resource.Filename = *videoFile.Filename
+			}
+			content.Resources = append(content.Resources, resource)
+		}
+	}
+	if recordedItem.Thumbnails != nil && len(*recordedItem.Thumbnails) > 0 {
+		content.Thumbnail = fmt.Sprintf("%s/thumbnails/%d", epgstation.ServerAPIRoot, (*recordedItem.Thumbnails)[0])
+	}
+
+	seen := make(map[epgstation.ProgramGenreLv1]bool)
+	for _, genre := range []*epgstation.ProgramGenreLv1{recordedItem.Genre1, recordedItem.Genre2, recordedItem.Genre3} {
+		if genre == nil || seen[*genre] {
+			continue
+		}
+		seen[*genre] = true
+		name := contentdirectory.GenreNames[int(*genre)]
+		if content.Genre == nil {
+			content.Genre = &name
+		}
+		content.Views = append(content.Views, contentdirectory.View{Kind: contentdirectory.ViewGenre, Key: strconv.Itoa(int(*genre)), Title: name})
+	}
+	if recordedItem.ChannelId != nil {
+		channelName := channels[*recordedItem.ChannelId].HalfWidthName
+		if channelName != "" {
+			content.ChannelName = &channelName
+		}
+		content.Views = append(content.Views, contentdirectory.View{Kind: contentdirectory.ViewChannel, Key: strconv.Itoa(int(*recordedItem.ChannelId)), Title: channelName})
+	}
+	if recordedItem.RuleId != nil {
+		// Recordings of a deleted rule are in no rule view.
+		if keyword, ok := keywords[*recordedItem.RuleId]; ok {
+			content.Views = append(content.Views, contentdirectory.View{Kind: contentdirectory.ViewRule, Key: strconv.Itoa(int(*recordedItem.RuleId)), Title: keyword})
+		}
+	}
+	return content
+}
+
+// fetchRecorded pages through every recording, or those matching keyword if
+// it is not nil. The pass starts over should the total change in between, as
+// the pages would have shifted.
+func (s *Source) fetchRecorded(ctx context.Context, keyword *epgstation.QueryKeyword) ([]epgstation.RecordedItem, error) {
+	for tries := 0; tries < 3; tries++ {
+		recordedItems, err := s.fetchRecordedPages(ctx, keyword)
+		if err == errRecordedChanged {
+			continue
+		}
+		return recordedItems, err
+	}
+	return nil, errRecordedChanged
+}
+
+func (s *Source) fetchRecordedPages(ctx context.Context, keyword *epgstation.QueryKeyword) ([]epgstation.RecordedItem, error) {
+	var recordedItems []epgstation.RecordedItem
+	total := -1
+	for {
+		offset, limit := epgstation.Offset(len(recordedItems)), epgstation.Limit(recordedPageSize)
+		s.throttle()
+		res, err := epgstation.EPGStation.GetRecordedWithResponse(ctx, &epgstation.GetRecordedParams{
+			IsHalfWidth: false,
+			Offset:      &offset,
+			Limit:       &limit,
+			Keyword:     keyword,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.JSON200 == nil {
+			return nil, fmt.Errorf("get recorded: %s", res.Status())
+		}
+		if total >= 0 && res.JSON200.Total != total {
+			return nil, errRecordedChanged
+		}
+		total = res.JSON200.Total
+		recordedItems = append(recordedItems, res.JSON200.Records...)
+		if len(res.JSON200.Records) == 0 || len(recordedItems) >= total {
+			return recordedItems, nil
+		}
+	}
+}
+
+// fetchRuleKeywords pages through the keywords of the rules. EPGStation does
+// not tell their total, so a page shorter than asked for is the last.
+func (s *Source) fetchRuleKeywords(ctx context.Context) (map[epgstation.RuleId]string, error) {
+	keywords := make(map[epgstation.RuleId]string)
+	for fetched := 0; ; {
+		offset, limit := epgstation.Offset(fetched), epgstation.Limit(rulePageSize)
+		s.throttle()
+		res, err := epgstation.EPGStation.GetRulesKeywordWithResponse(ctx, &epgstation.GetRulesKeywordParams{
+			Offset: &offset,
+			Limit:  &limit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.JSON200 == nil {
+			return nil, fmt.Errorf("get rules: %s", res.Status())
+		}
+		for _, ruleItem := range res.JSON200.Items {
+			keywords[ruleItem.Id] = ruleItem.Keyword
+		}
+		fetched += len(res.JSON200.Items)
+		if len(res.JSON200.Items) < rulePageSize {
+			return keywords, nil
+		}
+	}
+}
+
+// channelsOf returns the channels, looking them up again if a recording is on
+// one not seen before.
+func (s *Source) channelsOf(ctx context.Context, recordedItems []epgstation.RecordedItem) (map[epgstation.ChannelId]epgstation.ChannelItem, error) {
+	s.channelsMu.Lock()
+	defer s.channelsMu.Unlock()
+	for _, recordedItem := range recordedItems {
+		if recordedItem.ChannelId == nil {
+			continue
+		}
+		if _, ok := s.channels[*recordedItem.ChannelId]; !ok {
+			if err := s.fetchChannels(ctx); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return s.channels, nil
+}
+
+func (s *Source) fetchChannels(ctx context.Context) error {
+	s.throttle()
+	res, err := epgstation.EPGStation.GetChannelsWithResponse(ctx)
+	if err != nil {
+		return err
+	}
+	if res.JSON200 == nil {
+		return fmt.Errorf("get channels: %s", res.Status())
+	}
+	channels := make(map[epgstation.ChannelId]epgstation.ChannelItem)
+	for _, channelItem := range *res.JSON200 {
+		channels[channelItem.Id] = channelItem
+	}
+	s.channels = channels
+	return nil
+}
+
+// Probe looks up the duration of a video file, which is all EPGStation tells
+// of it.
+func (s *Source) Probe(ctx context.Context, resource contentdirectory.Resource) (contentdirectory.Probe, error) {
+	videoFileId, err := strconv.Atoi(resource.ID)
+	if err != nil {
+		return contentdirectory.Probe{}, err
+	}
+	s.throttle()
+	res, err := epgstation.EPGStation.GetVideosVideoFileIdDurationWithResponse(ctx, epgstation.PathVideoFileId(videoFileId))
+	if err != nil {
+		return contentdirectory.Probe{}, err
+	}
+	if res.JSON200 == nil {
+		return contentdirectory.Probe{}, fmt.Errorf("get duration of video file %d: %s", videoFileId, res.Status())
+	}
+	return contentdirectory.Probe{Duration: time.Duration(res.JSON200.Duration * float32(time.Second))}, nil
+}
+
+// ServeResource streams a video file from EPGStation.
+func (s *Source) ServeResource(w http.ResponseWriter, r *http.Request, resourceID string) {
+	req, err := http.NewRequestWithContext(r.Context(), "GET", fmt.Sprintf("%s/videos/%s", epgstation.ServerAPIRoot, resourceID), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for k, vs := range r.Header {
+		req.Header.Set(k, vs[0])
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("stream video file %s: %s", resourceID, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	for k, vs := range res.Header {
+		if k == "Content-Type" && vs[0] == "video/mp2t" {
+			vs[0] = "video/mpeg"
+		}
+		w.Header().Set(k, vs[0])
+	}
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+}
+
+// Delete deletes a recording, unless it is protected. The recording may have
+// been protected since it was last listed, so that is looked up again.
+func (s *Source) Delete(ctx context.Context, contentID string) error {
+	recordedId, err := strconv.Atoi(contentID)
+	if err != nil {
+		return contentdirectory.ErrNoSuchObject
+	}
+	res, err := epgstation.EPGStation.GetRecordedRecordedIdWithResponse(ctx, epgstation.PathRecordedId(recordedId), &epgstation.GetRecordedRecordedIdParams{
+		IsHalfWidth: false,
+	})
+	if err != nil {
+		return err
+	}
+	if res.JSON200 == nil {
+		return contentdirectory.ErrNoSuchObject
+	}
+	if res.JSON200.IsProtected {
+		return contentdirectory.ErrRestrictedObject
+	}
+	resDelete, err := epgstation.EPGStation.DeleteRecordedRecordedIdWithResponse(ctx, epgstation.PathRecordedId(recordedId))
+	if err != nil {
+		return err
+	}
+	if resDelete.StatusCode() != http.StatusOK {
+		return fmt.Errorf("delete recorded %d: %s", recordedId, resDelete.Status())
+	}
+	return nil
+}
+
+// SearchKeyword finds recordings the way EPGStation does, which also matches
+// descriptions and ignores the width of characters.
+func (s *Source) SearchKeyword(ctx context.Context, keyword string) ([]string, error) {
+	queryKeyword := epgstation.QueryKeyword(keyword)
+	recordedItems, err := s.fetchRecorded(ctx, &queryKeyword)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(recordedItems))
+	for i, recordedItem := range recordedItems {
+		ids[i] = strconv.Itoa(int(recordedItem.Id))
+	}
+	return ids, nil
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go-upnp-playground/gena"
+	"go-upnp-playground/service/contentdirectory"
+	"go-upnp-playground/service/scheduledrecording"
+	"go-upnp-playground/soap"
+)
+
+// ContentDirectory moderates SystemUpdateID and ContainerUpdateIDs to an event
+// every 2 seconds, and LastChange to one every 0.2 seconds.
+var contentDirectoryEvents = gena.NewService(
+	gena.Variable{Name: "TransferIDs"},
+	gena.Variable{Name: "SystemUpdateID", Value: "0", Moderation: 2 * time.Second},
+	gena.Variable{Name: "ContainerUpdateIDs", Moderation: 2 * time.Second, Accumulate: coalesceContainerUpdateIDs},
+	gena.Variable{Name: "LastChange", Moderation: 200 * time.Millisecond, Accumulate: mergeLastChange},
+)
+
+var connectionManagerEvents = gena.NewService(
+	gena.Variable{Name: "SourceProtocolInfo"},
+	gena.Variable{Name: "SinkProtocolInfo"},
+	gena.Variable{Name: "CurrentConnectionIDs", Value: "0"},
+)
+
+var mediaReceiverRegistrarEvents = gena.NewService(
+	gena.Variable{Name: "AuthorizationGrantedUpdateID", Value: "0"},
+	gena.Variable{Name: "AuthorizationDeniedUpdateID", Value: "0"},
+	gena.Variable{Name: "ValidationSucceededUpdateID", Value: "0"},
+	gena.Variable{Name: "ValidationRevokedUpdateID", Value: "0"},
+)
+
+var scheduledRecordingEvents = gena.NewService(
+	gena.Variable{Name: "LastChange"},
+)
+
+// coalesceContainerUpdateIDs adds the "id,updateID" pairs of next to those of
+// value. Each container is listed once, with its latest containerUpdateID.
+func coalesceContainerUpdateIDs(value string, next string) string {
+	var ids []string
+	updateIDs := make(map[string]string)
+	fields := strings.Split(value+","+next, ",")
+	for i := 0; i+1 < len(fields); i += 2 {
+		id := fields[i]
+		if _, ok := updateIDs[id]; !ok {
+			ids = append(ids, id)
+		}
+		updateIDs[id] = fields[i+1]
+	}
+	pairs := make([]string, len(ids))
+	for i, id := range ids {
+		pairs[i] = id + "," + updateIDs[id]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// mergeLastChange appends the changes of the StateEvent next to those of the
+// StateEvent value.
+func mergeLastChange(value string, next string) string {
+	end := strings.LastIndex(value, "</")
+	start := strings.Index(next, ">") + 1
+	stop := strings.LastIndex(next, "</")
+	if end < 0 || start == 0 || stop < start {
+		return next
+	}
+	return value[:end] + next[start:stop] + value[end:]
+}
+
+func setupEvents() {
+	source, sink, _ := soap.ConnectionManagerAction{}.GetProtocolInfo()
+	connectionManagerEvents.SetVariable("SourceProtocolInfo", source)
+	connectionManagerEvents.SetVariable("SinkProtocolInfo", sink)
+
+	contentdirectory.OnUpdate(func(systemUpdateID int, containers []contentdirectory.ContainerUpdate, lastChange string) {
+		contentDirectoryEvents.SetVariable("SystemUpdateID", fmt.Sprint(systemUpdateID))
+		if len(containers) > 0 {
+			pairs := make([]string, len(containers))
+			for i, c := range containers {
+				pairs[i] = fmt.Sprintf("%s,%d", c.Id, c.UpdateID)
+			}
+			contentDirectoryEvents.SetVariable("ContainerUpdateIDs", strings.Join(pairs, ","))
+		}
+		if lastChange != "" {
+			contentDirectoryEvents.SetVariable("LastChange", lastChange)
+		}
+	})
+
+	scheduledrecording.OnUpdate(func(stateUpdateID int, lastChange string) {
+		scheduledRecordingEvents.SetVariable("LastChange", lastChange)
+	})
+}
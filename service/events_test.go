@@ -0,0 +1,26 @@
+package service
+
+import "testing"
+
+func TestCoalesceContainerUpdateIDs(t *testing.T) {
+	for _, test := range []struct {
+		value, next, want string
+	}{
+		{"01,3", "05,1", "01,3,05,1"},
+		{"01,3,05,1", "01,4", "01,4,05,1"},
+		{"01,3", "05,1,01,5,0,2", "01,5,05,1,0,2"},
+	} {
+		if got := coalesceContainerUpdateIDs(test.value, test.next); got != test.want {
+			t.Errorf("coalesce %q and %q: got %q, want %q", test.value, test.next, got, test.want)
+		}
+	}
+}
+
+func TestMergeLastChange(t *testing.T) {
+	value := `<StateEvent xmlns="urn:schemas-upnp-org:av:cds-event"><objMod objID="01/1" updateID="3"></objMod></StateEvent>`
+	next := `<StateEvent xmlns="urn:schemas-upnp-org:av:cds-event"><objDel objID="01/2" updateID="4"></objDel></StateEvent>`
+	want := `<StateEvent xmlns="urn:schemas-upnp-org:av:cds-event"><objMod objID="01/1" updateID="3"></objMod><objDel objID="01/2" updateID="4"></objDel></StateEvent>`
+	if got := mergeLastChange(value, next); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
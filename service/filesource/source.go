@@ -0,0 +1,262 @@
+// Package filesource makes the video, audio and image files under a set of
+// directories the contents of the content directory, in folders following
+// the directories.
+package filesource
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-upnp-playground/service/contentdirectory"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	videoClass = "object.item.videoItem"
+	audioClass = "object.item.audioItem.musicTrack"
+	imageClass = "object.item.imageItem.photo"
+)
+
+type fileType struct {
+	class       string
+	contentType string
+}
+
+// fileTypes are the files served, by extension. The content directory must
+// know the protocolInfo of each.
+var fileTypes = map[string]fileType{
+	".m2ts": {videoClass, "video/mpeg"},
+	".ts":   {videoClass, "video/mpeg"},
+	".mp4":  {videoClass, "video/mp4"},
+	".mkv":  {videoClass, "video/x-matroska"},
+	".mp3":  {audioClass, "audio/mpeg"},
+	".m4a":  {audioClass, "audio/mp4"},
+	".jpg":  {imageClass, "image/jpeg"},
+	".jpeg": {imageClass, "image/jpeg"},
+	".png":  {imageClass, "image/png"},
+}
+
+// A Source is the files under some directories. Each directory is a folder of
+// its own, and files are never deleted by clients.
+type Source struct {
+	dirs []string
+	// ffprobe is the path of ffprobe, which probes files, or "" if there is
+	// none.
+	ffprobe string
+
+	// pathsMu guards paths, the files of the last scan by resource ID.
+	pathsMu sync.Mutex
+	paths   map[string]string
+}
+
+func New(dirs []string) *Source {
+	s := &Source{paths: make(map[string]string)}
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.dirs = append(s.dirs, abs)
+	}
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		log.Printf("ffprobe not found, durations and formats of media files will be unknown")
+	}
+	s.ffprobe = ffprobe
+	return s
+}
+
+// idOf returns the ID of a file or directory, which stays the same as long as
+// its path does. The prefix keeps it apart from the IDs of other sources.
+func idOf(path string) string {
+	sum := sha1.Sum([]byte(path))
+	return "f" + hex.EncodeToString(sum[:8])
+}
+
+func fileTypeOf(path string) (fileType, bool) {
+	t, ok := fileTypes[strings.ToLower(filepath.Ext(path))]
+	return t, ok
+}
+
+func (s *Source) path(resourceID string) (string, bool) {
+	s.pathsMu.Lock()
+	defer s.pathsMu.Unlock()
+	path, ok := s.paths[resourceID]
+	return path, ok
+}
+
+// Contents scans the directories. A directory which cannot be read fails the
+// scan, so that an unmounted share does not empty the tree, while unreadable
+// files and subdirectories are only left out.
+func (s *Source) Contents(ctx context.Context) ([]contentdirectory.Content, error) {
+	var contents []contentdirectory.Content
+	paths := make(map[string]string)
+	for _, dir := range s.dirs {
+		// Walk does not follow a directory which is a symlink itself.
+		root, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return nil, err
+		}
+		folders := make(map[string][]contentdirectory.Folder)
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil {
+				log.Printf("leaving out %s: %s", path, err)
+				return nil
+			}
+			if path != root && strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				folder := contentdirectory.Folder{Key: idOf(path), Title: info.Name()}
+				folders[path] = append(append([]contentdirectory.Folder(nil), folders[filepath.Dir(path)]...), folder)
+				return nil
+			}
+			t, ok := fileTypeOf(path)
+			if !ok || !info.Mode().IsRegular() {
+				return nil
+			}
+			id := idOf(path)
+			paths[id] = path
+			contents = append(contents, contentdirectory.Content{
+				ID:        id,
+				Title:     strings.TrimSuffix(info.Name(), filepath.Ext(info.Name())),
+				Start:     info.ModTime(),
+				End:       info.ModTime(),
+				Protected: true,
+				Class:     t.class,
+				Kind:      contentdirectory.ContentFile,
+				Resources: []contentdirectory.Resource{{
+					ID:       id,
+					Filename: info.Name(),
+					Size:     int(info.Size()),
+					ModTime:  info.ModTime(),
+				}},
+				Folder: folders[filepath.Dir(path)],
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(contents, func(i, j int) bool {
+		return contents[i].Start.After(contents[j].Start)
+	})
+	s.pathsMu.Lock()
+	s.paths = paths
+	s.pathsMu.Unlock()
+	return contents, nil
+}
+
+// ffprobeOutput is what Probe asks ffprobe for. Durations, rates and
+// frequencies are strings, and "N/A" when unknown.
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType  string `json:"codec_type"`
+		Width      int    `json:"width"`
+		Height     int    `json:"height"`
+		Channels   int    `json:"channels"`
+		SampleRate string `json:"sample_rate"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+		BitRate  string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// parseProbe reads the JSON output of ffprobe. Images have no duration, even
+// if ffprobe gives them the length of a frame.
+func parseProbe(data []byte, class string) (contentdirectory.Probe, error) {
+	var out ffprobeOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return contentdirectory.Probe{}, err
+	}
+	var probe contentdirectory.Probe
+	if seconds, err := strconv.ParseFloat(out.Format.Duration, 64); err == nil && class != imageClass {
+		probe.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	if bitsPerSecond, err := strconv.Atoi(out.Format.BitRate); err == nil {
+		probe.Bitrate = bitsPerSecond / 8
+	}
+	for _, stream := range out.Streams {
+		switch {
+		case stream.CodecType == "video" && stream.Width > 0 && probe.Resolution == "":
+			probe.Resolution = fmt.Sprintf("%dx%d", stream.Width, stream.Height)
+		case stream.CodecType == "audio" && probe.AudioChannels == 0:
+			probe.AudioChannels = stream.Channels
+			probe.SampleFrequency, _ = strconv.Atoi(stream.SampleRate)
+		}
+	}
+	return probe, nil
+}
+
+// Probe looks up the duration, resolution, bitrate and audio format of a
+// file with ffprobe. Nothing is known of files when there is no ffprobe.
+func (s *Source) Probe(ctx context.Context, resource contentdirectory.Resource) (contentdirectory.Probe, error) {
+	path, ok := s.path(resource.ID)
+	if !ok {
+		return contentdirectory.Probe{}, contentdirectory.ErrNoSuchObject
+	}
+	if s.ffprobe == "" {
+		return contentdirectory.Probe{}, nil
+	}
+	out, err := exec.CommandContext(ctx, s.ffprobe, "-v", "error",
+		"-show_entries", "format=duration,bit_rate:stream=codec_type,width,height,channels,sample_rate",
+		"-of", "json", path).Output()
+	if err != nil {
+		return contentdirectory.Probe{}, fmt.Errorf("ffprobe %s: %s", path, err)
+	}
+	t, _ := fileTypeOf(path)
+	probe, err := parseProbe(out, t.class)
+	if err != nil {
+		return contentdirectory.Probe{}, fmt.Errorf("ffprobe %s: %s", path, err)
+	}
+	return probe, nil
+}
+
+// ServeResource serves a file, with support for byte ranges.
+func (s *Source) ServeResource(w http.ResponseWriter, r *http.Request, resourceID string) {
+	path, ok := s.path(resourceID)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("serve %s: %s", path, err)
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if t, ok := fileTypeOf(path); ok {
+		w.Header().Set("Content-Type", t.contentType)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// Delete never deletes a file.
+func (s *Source) Delete(ctx context.Context, contentID string) error {
+	return contentdirectory.ErrRestrictedObject
+}
@@ -0,0 +1,39 @@
+package filesource
+
+import (
+	"go-upnp-playground/service/contentdirectory"
+	"testing"
+	"time"
+)
+
+func TestParseProbe(t *testing.T) {
+	for _, test := range []struct {
+		output string
+		class  string
+		want   contentdirectory.Probe
+	}{
+		{
+			`{"streams":[{"codec_type":"video","width":1920,"height":1080},{"codec_type":"audio","channels":2,"sample_rate":"48000"},{"codec_type":"audio","channels":6,"sample_rate":"44100"}],"format":{"duration":"90.5","bit_rate":"16000000"}}`,
+			"object.item.videoItem",
+			contentdirectory.Probe{Duration: 90500 * time.Millisecond, Resolution: "1920x1080", Bitrate: 2000000, AudioChannels: 2, SampleFrequency: 48000},
+		},
+		{
+			`{"streams":[{"codec_type":"audio","channels":2,"sample_rate":"44100"}],"format":{"duration":"180.000000","bit_rate":"320000"}}`,
+			"object.item.audioItem.musicTrack",
+			contentdirectory.Probe{Duration: 3 * time.Minute, Bitrate: 40000, AudioChannels: 2, SampleFrequency: 44100},
+		},
+		{
+			`{"streams":[{"codec_type":"video","width":640,"height":480}],"format":{"duration":"0.040000","bit_rate":"N/A"}}`,
+			imageClass,
+			contentdirectory.Probe{Resolution: "640x480"},
+		},
+	} {
+		probe, err := parseProbe([]byte(test.output), test.class)
+		if err != nil || probe != test.want {
+			t.Errorf("%s: got %+v, %v, want %+v", test.output, probe, err, test.want)
+		}
+	}
+	if _, err := parseProbe([]byte("Invalid data"), imageClass); err == nil {
+		t.Error("unparsable output: want error")
+	}
+}
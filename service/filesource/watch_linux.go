@@ -0,0 +1,163 @@
+//go:build linux
+// +build linux
+
+package filesource
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// changeQuiet is how long the directories must be left alone before a change
+// is told, so that a file being copied in is synced once, when complete.
+const changeQuiet = 2 * time.Second
+
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE
+
+// A watcher watches directories with inotify.
+type watcher struct {
+	fd   int
+	file *os.File
+	// dirs are the directories watched, by watch descriptor. Only the
+	// goroutine reading events uses it once watching has started.
+	dirs map[int32]string
+}
+
+// Changes watches the directories and tells of a change once they have been
+// quiet for changeQuiet. Network mounts do not tell of the changes made by
+// other hosts, which wait for the next poll instead.
+func (s *Source) Changes(ctx context.Context) <-chan struct{} {
+	w, err := newWatcher(s.dirs)
+	if err != nil {
+		log.Printf("cannot watch media directories: %s", err)
+		return nil
+	}
+	events := make(chan struct{}, 1)
+	go w.read(events)
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer w.file.Close()
+		var quiet <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-events:
+				if !ok {
+					return
+				}
+				quiet = time.After(changeQuiet)
+			case <-quiet:
+				quiet = nil
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes
+}
+
+// newWatcher watches dirs and the directories under them.
+func newWatcher(dirs []string) (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// A non-blocking file is read through the poller, so that closing it
+	// ends a pending read.
+	w := &watcher{fd: fd, file: os.NewFile(uintptr(fd), "inotify"), dirs: make(map[int32]string)}
+	for _, dir := range dirs {
+		w.addTree(dir)
+	}
+	return w, nil
+}
+
+// addTree watches dir and the directories under it.
+func (w *watcher) addTree(dir string) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		log.Printf("cannot watch %s: %s", dir, err)
+		return
+	}
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, watchMask)
+		if err != nil {
+			log.Printf("cannot watch %s: %s", path, err)
+			return nil
+		}
+		w.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+// An event is an inotify event of the watch wd. name is the name of the file
+// in the watched directory the event is of, if any.
+type event struct {
+	wd   int32
+	mask uint32
+	name string
+}
+
+// parseEvents parses the inotify events read into buf. Each is a
+// syscall.InotifyEvent followed by Len bytes of name, padded with NULs. A
+// truncated event at the end is left out; the kernel never returns one.
+func parseEvents(buf []byte) []event {
+	var events []event
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + syscall.SizeofInotifyEvent
+		nameEnd := nameStart + int(raw.Len)
+		if nameEnd > len(buf) {
+			break
+		}
+		events = append(events, event{
+			wd:   raw.Wd,
+			mask: raw.Mask,
+			name: strings.TrimRight(string(buf[nameStart:nameEnd]), "\x00"),
+		})
+		offset = nameEnd
+	}
+	return events
+}
+
+// read reads events until the file is closed, watching the directories which
+// appear, and signals events after each read.
+func (w *watcher) read(events chan<- struct{}) {
+	defer close(events)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, e := range parseEvents(buf[:n]) {
+			switch {
+			case e.mask&syscall.IN_IGNORED != 0:
+				delete(w.dirs, e.wd)
+			case e.mask&syscall.IN_ISDIR != 0 && e.mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+				if dir, ok := w.dirs[e.wd]; ok {
+					w.addTree(filepath.Join(dir, e.name))
+				}
+			}
+		}
+		select {
+		case events <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,126 @@
+//go:build linux
+// +build linux
+
+package filesource
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// appendEvent appends an inotify event to buf, with name padded with NULs to
+// a multiple of the size of an event as the kernel does.
+func appendEvent(buf []byte, wd int32, mask uint32, name string) []byte {
+	n := 0
+	if name != "" {
+		n = (len(name)/syscall.SizeofInotifyEvent + 1) * syscall.SizeofInotifyEvent
+	}
+	header := make([]byte, syscall.SizeofInotifyEvent)
+	*(*syscall.InotifyEvent)(unsafe.Pointer(&header[0])) = syscall.InotifyEvent{Wd: wd, Mask: mask, Len: uint32(n)}
+	buf = append(buf, header...)
+	buf = append(buf, name...)
+	return append(buf, make([]byte, n-len(name))...)
+}
+
+func TestParseEvents(t *testing.T) {
+	var buf []byte
+	buf = appendEvent(buf, 1, syscall.IN_IGNORED, "")
+	buf = appendEvent(buf, 2, syscall.IN_CREATE, "photo.jpg")
+	// A name of 16 bytes is padded to 32, to end with a NUL.
+	buf = appendEvent(buf, 2, syscall.IN_CREATE|syscall.IN_ISDIR, "0123456789abcdef")
+	all := []event{
+		{wd: 1, mask: syscall.IN_IGNORED},
+		{wd: 2, mask: syscall.IN_CREATE, name: "photo.jpg"},
+		{wd: 2, mask: syscall.IN_CREATE | syscall.IN_ISDIR, name: "0123456789abcdef"},
+	}
+	last := len(buf) - syscall.SizeofInotifyEvent - 32
+	for _, test := range []struct {
+		n    int
+		want []event
+	}{
+		{0, nil},
+		{len(buf), all},
+		// The name of the last event is cut short.
+		{len(buf) - 1, all[:2]},
+		{last + syscall.SizeofInotifyEvent, all[:2]},
+		// So is its header.
+		{last + 8, all[:2]},
+		{last, all[:2]},
+	} {
+		if got := parseEvents(buf[:test.n]); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d bytes: got %+v, want %+v", test.n, got, test.want)
+		}
+	}
+}
+
+// TestParseKernelEvents parses events read from the kernel.
+func TestParseKernelEvents(t *testing.T) {
+	dir := t.TempDir()
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer syscall.Close(fd)
+	wd, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "photo.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "0123456789abcdef"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64*1024)
+	n, err := syscall.Read(fd, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []event{
+		{wd: int32(wd), mask: syscall.IN_CREATE, name: "photo.jpg"},
+		{wd: int32(wd), mask: syscall.IN_CREATE | syscall.IN_ISDIR, name: "0123456789abcdef"},
+	}
+	if got := parseEvents(buf[:n]); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestWatchNewDirectories(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newWatcher([]string{dir})
+	if err != nil {
+		t.Skip(err)
+	}
+	events := make(chan struct{}, 1)
+	go w.read(events)
+	next := func(what string) {
+		t.Helper()
+		select {
+		case <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event for %s", what)
+		}
+	}
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	next("a new directory")
+	// The new directory is watched by the time its event is told.
+	if err := ioutil.WriteFile(filepath.Join(sub, "photo.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	next("a file in the new directory")
+
+	w.file.Close()
+	for range events {
+	}
+}
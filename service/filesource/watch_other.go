@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package filesource
+
+import "context"
+
+// Changes tells of no change, as only Linux has inotify. The directories are
+// synced on every poll instead.
+func (s *Source) Changes(ctx context.Context) <-chan struct{} {
+	return nil
+}
@@ -0,0 +1,239 @@
+// Package mirakurunsource makes the live channels of Mirakurun the contents of
+// the content directory. Each TV service is an item streaming its MPEG-TS,
+// described by the program on air.
+package mirakurunsource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-upnp-playground/service/contentdirectory"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serviceTypeTV is the ARIB service type of digital TV services, leaving out
+// radio and data services.
+const serviceTypeTV = 0x01
+
+// idPrefix keeps the content and resource IDs apart from those of other
+// sources.
+const idPrefix = "m"
+
+// A Source is the live channels of a Mirakurun.
+type Source struct {
+	baseURL string
+	// client is for API requests, which unlike streams should not take
+	// long.
+	client *http.Client
+
+	// servicesMu guards services, the IDs of the services of the last
+	// listing by resource ID. Only those are tuned.
+	servicesMu sync.Mutex
+	services   map[string]int64
+}
+
+// New returns the Source of the Mirakurun at baseURL, such as
+// http://localhost:40772.
+func New(baseURL string) *Source {
+	return &Source{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+		services: make(map[string]int64),
+	}
+}
+
+type service struct {
+	ID          int64  `json:"id"`
+	ServiceID   int    `json:"serviceId"`
+	NetworkID   int    `json:"networkId"`
+	Name        string `json:"name"`
+	Type        int    `json:"type"`
+	HasLogoData bool   `json:"hasLogoData"`
+}
+
+type program struct {
+	ServiceID   int    `json:"serviceId"`
+	NetworkID   int    `json:"networkId"`
+	StartAt     int64  `json:"startAt"`
+	Duration    int64  `json:"duration"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Genres      []struct {
+		Lv1 int `json:"lv1"`
+	} `json:"genres"`
+}
+
+func (p *program) start() time.Time {
+	return time.Unix(0, p.StartAt*int64(time.Millisecond))
+}
+
+func (p *program) end() time.Time {
+	return p.start().Add(time.Duration(p.Duration) * time.Millisecond)
+}
+
+func (s *Source) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.baseURL+"/api"+path, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: %s", path, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// Contents returns the TV services along with the programs on air. The
+// programs are asked for by service, as all of them would also take in those
+// of radio and data services.
+func (s *Source) Contents(ctx context.Context) ([]contentdirectory.Content, error) {
+	var services []service
+	if err := s.get(ctx, "/services", &services); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var contents []contentdirectory.Content
+	ids := make(map[string]int64)
+	for _, svc := range services {
+		if svc.Type != serviceTypeTV {
+			continue
+		}
+		p, err := s.onAir(ctx, svc, now)
+		if err != nil {
+			return nil, err
+		}
+		content := s.newContent(svc, p)
+		contents = append(contents, content)
+		ids[content.ID] = svc.ID
+	}
+	s.servicesMu.Lock()
+	s.services = ids
+	s.servicesMu.Unlock()
+	return contents, nil
+}
+
+// onAir returns the program of svc on air at now, or nil if there is none.
+func (s *Source) onAir(ctx context.Context, svc service, now time.Time) (*program, error) {
+	query := url.Values{
+		"networkId": {strconv.Itoa(svc.NetworkID)},
+		"serviceId": {strconv.Itoa(svc.ServiceID)},
+	}
+	var programs []program
+	if err := s.get(ctx, "/programs?"+query.Encode(), &programs); err != nil {
+		return nil, err
+	}
+	for i := range programs {
+		p := &programs[i]
+		if p.NetworkID == svc.NetworkID && p.ServiceID == svc.ServiceID && !p.start().After(now) && p.end().After(now) {
+			return p, nil
+		}
+	}
+	return nil, nil
+}
+
+// newContent makes the content of a service. Its view is keyed by the service
+// ID, which EPGStation uses as the channel ID, so that the live channel shares
+// the view of the recordings of its channel.
+func (s *Source) newContent(svc service, p *program) contentdirectory.Content {
+	id := idPrefix + strconv.FormatInt(svc.ID, 10)
+	channelName := svc.Name
+	content := contentdirectory.Content{
+		ID:          id,
+		Title:       svc.Name,
+		ChannelName: &channelName,
+		Protected:   true,
+		Class:       "object.item.videoItem.videoBroadcast",
+		Kind:        contentdirectory.ContentLive,
+		Resources: []contentdirectory.Resource{{
+			ID:       id,
+			Filename: id + ".ts",
+			Live:     true,
+		}},
+		Views: []contentdirectory.View{{
+			Kind:  contentdirectory.ViewChannel,
+			Key:   strconv.FormatInt(svc.ID, 10),
+			Title: svc.Name,
+		}},
+	}
+	if svc.HasLogoData {
+		content.Thumbnail = fmt.Sprintf("%s/api/services/%d/logo", s.baseURL, svc.ID)
+	}
+	if p != nil {
+		name := p.Name
+		content.Description = &name
+		if p.Description != "" {
+			description := p.Description
+			content.LongDescription = &description
+		}
+		if len(p.Genres) > 0 {
+			genre := contentdirectory.GenreNames[p.Genres[0].Lv1]
+			content.Genre = &genre
+		}
+		content.Start, content.End = p.start(), p.end()
+	}
+	return content
+}
+
+// Probe is never called, as the resources are live.
+func (s *Source) Probe(ctx context.Context, resource contentdirectory.Resource) (contentdirectory.Probe, error) {
+	return contentdirectory.Probe{}, nil
+}
+
+func (s *Source) service(resourceID string) (int64, bool) {
+	s.servicesMu.Lock()
+	defer s.servicesMu.Unlock()
+	id, ok := s.services[resourceID]
+	return id, ok
+}
+
+// ServeResource streams a service of the last listing, which takes a tuner for
+// as long as the client keeps reading. A HEAD request only tells the type,
+// without tuning.
+func (s *Source) ServeResource(w http.ResponseWriter, r *http.Request, resourceID string) {
+	serviceID, ok := s.service(resourceID)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "video/mpeg")
+		return
+	}
+	u := fmt.Sprintf("%s/api/services/%d/stream?%s", s.baseURL, serviceID, url.Values{"decode": {"1"}}.Encode())
+	req, err := http.NewRequestWithContext(r.Context(), "GET", u, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("stream service %d: %s", serviceID, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		// Such as 503 when no tuner is free.
+		http.Error(w, res.Status, res.StatusCode)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mpeg")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, res.Body)
+}
+
+// Delete never deletes a channel.
+func (s *Source) Delete(ctx context.Context, contentID string) error {
+	return contentdirectory.ErrRestrictedObject
+}
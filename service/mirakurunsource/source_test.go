@@ -0,0 +1,168 @@
+package mirakurunsource
+
+import (
+	"context"
+	"encoding/json"
+	"go-upnp-playground/service/contentdirectory"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubMirakurun serves two TV services, one of them busy, and a radio service,
+// recording the requests made.
+type stubMirakurun struct {
+	*httptest.Server
+	now time.Time
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func newStubMirakurun(t *testing.T) *stubMirakurun {
+	stub := &stubMirakurun{now: time.Now()}
+	services := []service{
+		{ID: 3273601024, ServiceID: 1024, NetworkID: 32736, Name: "NHK総合", Type: serviceTypeTV},
+		{ID: 3273701032, ServiceID: 1032, NetworkID: 32737, Name: "NHKEテレ", Type: serviceTypeTV},
+		{ID: 3273601408, ServiceID: 1408, NetworkID: 32736, Name: "NHKラジオ", Type: 0x02},
+	}
+	ms := func(t time.Time) int64 { return t.UnixNano() / int64(time.Millisecond) }
+	hour := int64(time.Hour / time.Millisecond)
+	programs := []program{
+		{ServiceID: 1024, NetworkID: 32736, StartAt: ms(stub.now.Add(-2 * time.Hour)), Duration: hour, Name: "前の番組"},
+		{ServiceID: 1024, NetworkID: 32736, StartAt: ms(stub.now.Add(-30 * time.Minute)), Duration: hour, Name: "ニュース", Description: "今日の出来事"},
+		{ServiceID: 1024, NetworkID: 32736, StartAt: ms(stub.now.Add(30 * time.Minute)), Duration: hour, Name: "次の番組"},
+		{ServiceID: 1408, NetworkID: 32736, StartAt: ms(stub.now.Add(-30 * time.Minute)), Duration: hour, Name: "ラジオ番組"},
+	}
+	programs[1].Genres = append(programs[1].Genres, struct {
+		Lv1 int `json:"lv1"`
+	}{0})
+
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		stub.requests = append(stub.requests, r)
+		stub.mu.Unlock()
+		switch r.URL.Path {
+		case "/api/services":
+			json.NewEncoder(w).Encode(services)
+		case "/api/programs":
+			var matched []program
+			for _, p := range programs {
+				if r.URL.Query().Get("serviceId") == "" || r.URL.Query().Get("serviceId") == strconv.Itoa(p.ServiceID) && r.URL.Query().Get("networkId") == strconv.Itoa(p.NetworkID) {
+					matched = append(matched, p)
+				}
+			}
+			json.NewEncoder(w).Encode(matched)
+		case "/api/services/3273601024/stream":
+			if r.URL.Query().Get("decode") != "1" {
+				t.Errorf("stream without decode: %s", r.URL)
+			}
+			io.WriteString(w, "MPEG-TS")
+		case "/api/services/3273701032/stream":
+			http.Error(w, "no tuner available", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return stub
+}
+
+// requested returns the requests made to path.
+func (stub *stubMirakurun) requested(path string) []*http.Request {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	var requests []*http.Request
+	for _, r := range stub.requests {
+		if r.URL.Path == path {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+func TestContents(t *testing.T) {
+	stub := newStubMirakurun(t)
+	defer stub.Close()
+
+	contents, err := New(stub.URL + "/").Contents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 2 || contents[0].ID != "m3273601024" || contents[1].ID != "m3273701032" {
+		t.Fatalf("want the two TV services, got %+v", contents)
+	}
+	news := contents[0]
+	if news.Description == nil || *news.Description != "ニュース" {
+		t.Errorf("want the program on air, got %v", news.Description)
+	}
+	if news.LongDescription == nil || *news.LongDescription != "今日の出来事" {
+		t.Errorf("want the description of the program on air, got %v", news.LongDescription)
+	}
+	if news.Genre == nil || *news.Genre != "ニュース・報道" {
+		t.Errorf("want the genre of the program on air, got %v", news.Genre)
+	}
+	if !news.Start.Before(stub.now) || !news.End.After(stub.now) {
+		t.Errorf("program on air from %s to %s", news.Start, news.End)
+	}
+	if contents[1].Description != nil {
+		t.Errorf("service without a program on air described as %q", *contents[1].Description)
+	}
+	if !contents[1].Start.IsZero() || !contents[1].End.IsZero() {
+		t.Errorf("service without a program on air from %s to %s", contents[1].Start, contents[1].End)
+	}
+	for _, content := range contents {
+		if content.Kind != contentdirectory.ContentLive {
+			t.Errorf("%s is of kind %q, want live", content.ID, content.Kind)
+		}
+	}
+
+	requests := stub.requested("/api/programs")
+	if len(requests) != 2 {
+		t.Errorf("want programs of the two TV services, got %d requests", len(requests))
+	}
+	for _, r := range requests {
+		if r.URL.Query().Get("serviceId") == "" || r.URL.Query().Get("networkId") == "" {
+			t.Errorf("programs not asked for by service: %s", r.URL)
+		}
+	}
+}
+
+func TestServeResource(t *testing.T) {
+	stub := newStubMirakurun(t)
+	defer stub.Close()
+	source := New(stub.URL)
+
+	serve := func(resourceID string) *http.Response {
+		w := httptest.NewRecorder()
+		source.ServeResource(w, httptest.NewRequest("GET", "/videos/recorded?videoFileId="+resourceID, nil), resourceID)
+		return w.Result()
+	}
+	if res := serve("m3273601024"); res.StatusCode != http.StatusNotFound {
+		t.Errorf("service tuned before the listing: %s", res.Status)
+	}
+	if _, err := source.Contents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	res := serve("m3273601024")
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(body) != "MPEG-TS" || res.Header.Get("Content-Type") != "video/mpeg" {
+		t.Errorf("streaming: %s %s %q", res.Status, res.Header.Get("Content-Type"), body)
+	}
+	if res := serve("m3273701032"); res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("want 503 when no tuner is free, got %s", res.Status)
+	}
+	for _, id := range []string{"m3273601408", "m1", "3273601024", "m3273601024/../logo"} {
+		if res := serve(id); res.StatusCode != http.StatusNotFound {
+			t.Errorf("serving %s: %s", id, res.Status)
+		}
+	}
+	if n := len(stub.requested("/api/services/3273601408/stream")); n != 0 {
+		t.Errorf("tuned the radio service %d times", n)
+	}
+}
@@ -0,0 +1,123 @@
+package playto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const discoverTimeout = 3 * time.Second
+
+// Handler serves the Play to TV API:
+//
+//	GET  /api/renderers                    list renderers, searching the network first with ?discover=1
+//	POST /api/renderers                    add the renderer described at location=, an http URL on the local link
+//	POST /api/renderers/{udn}/play         play objectID=
+//	POST /api/renderers/{udn}/pause
+//	POST /api/renderers/{udn}/resume
+//	POST /api/renderers/{udn}/stop
+//	POST /api/renderers/{udn}/seek         seek to position= in seconds
+var Handler http.Handler = http.HandlerFunc(serveAPI)
+
+const apiPath = "/api/renderers"
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNoSuchRenderer), errors.Is(err, ErrNoSuchItem):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrUnsupportedFormat):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, ErrNotLocal):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		// The renderer failed or could not be reached.
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+func serveAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path == apiPath {
+		serveRenderers(w, r)
+		return
+	}
+	rest := strings.TrimPrefix(path, apiPath+"/")
+	i := strings.LastIndex(rest, "/")
+	if rest == path || i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	renderer, err := getRenderer(rest[:i])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	ctx := r.Context()
+	switch rest[i+1:] {
+	case "play":
+		err = renderer.Play(ctx, r.FormValue("objectID"))
+	case "pause":
+		err = renderer.Pause(ctx)
+	case "resume":
+		err = renderer.Resume(ctx)
+	case "stop":
+		err = renderer.Stop(ctx)
+	case "seek":
+		var seconds int
+		if _, err := fmt.Sscan(r.FormValue("position"), &seconds); err != nil || seconds < 0 {
+			http.Error(w, "position must be a number of seconds", http.StatusBadRequest)
+			return
+		}
+		err = renderer.Seek(ctx, time.Duration(seconds)*time.Second)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func serveRenderers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if r.FormValue("discover") != "" {
+			ctx, cancel := context.WithTimeout(r.Context(), discoverTimeout)
+			defer cancel()
+			if err := Discover(ctx); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		writeJSON(w, Renderers())
+	case http.MethodPost:
+		location := r.FormValue("location")
+		if location == "" {
+			http.Error(w, "location is required", http.StatusBadRequest)
+			return
+		}
+		renderer, err := AddRenderer(r.Context(), location)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, renderer)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
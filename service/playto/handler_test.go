@@ -0,0 +1,53 @@
+package playto
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAddRendererLocalOnly(t *testing.T) {
+	stub := newStubRenderer(t, "http-get:*:*:*")
+	if code, body := callAPI(t, apiPath, url.Values{"location": {stub.URL + "/desc.xml"}}); code != http.StatusOK {
+		t.Errorf("adding a local renderer: %d %s", code, body)
+	}
+
+	port := stub.URL[strings.LastIndex(stub.URL, ":"):]
+	for _, location := range []string{
+		"http://203.0.113.1/desc.xml",
+		"http://localhost" + port + "/desc.xml",
+		"https://127.0.0.1" + port + "/desc.xml",
+		"file:///etc/passwd",
+		"",
+	} {
+		code, body := callAPI(t, apiPath, url.Values{"location": {location}})
+		if code != http.StatusForbidden && !(location == "" && code == http.StatusBadRequest) {
+			t.Errorf("adding %q: %d %s", location, code, body)
+		}
+	}
+}
+
+func TestAddRendererForeignServices(t *testing.T) {
+	var controlled int32
+	desc := strings.Replace(stubDescription, "<device>", "<URLBase>http://203.0.113.1/</URLBase><device>", 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/desc.xml" {
+			atomic.AddInt32(&controlled, 1)
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, desc)
+	}))
+	defer server.Close()
+
+	if code, body := callAPI(t, apiPath, url.Values{"location": {server.URL + "/desc.xml"}}); code != http.StatusForbidden {
+		t.Errorf("adding a renderer with services elsewhere: %d %s", code, body)
+	}
+	if atomic.LoadInt32(&controlled) != 0 {
+		t.Error("invoked a service of the renderer")
+	}
+}
@@ -0,0 +1,283 @@
+// Package playto pushes recordings to MediaRenderers such as TVs, acting as a
+// UPnP AV control point.
+package playto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go-upnp-playground/service/contentdirectory"
+	"go-upnp-playground/soap"
+	"go-upnp-playground/ssdp"
+)
+
+const (
+	mediaRenderer     = "urn:schemas-upnp-org:device:MediaRenderer:1"
+	connectionManager = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	avTransport       = "urn:schemas-upnp-org:service:AVTransport:1"
+)
+
+var (
+	ErrNoSuchRenderer    = errors.New("no such renderer")
+	ErrNoSuchItem        = errors.New("no such item")
+	ErrUnsupportedFormat = errors.New("renderer supports none of the formats of the item")
+	ErrNotLocal          = errors.New("renderer is not on the local network")
+)
+
+// A Renderer is a MediaRenderer found on the network.
+type Renderer struct {
+	UDN          string `json:"udn"`
+	FriendlyName string `json:"friendlyName"`
+	Location     string `json:"location"`
+
+	sinkProtocolInfo []string
+	avTransport      *soap.Client
+}
+
+var renderersMu sync.Mutex
+var renderers = make(map[string]*Renderer)
+
+// Renderers returns the renderers found so far.
+func Renderers() []*Renderer {
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
+	list := make([]*Renderer, 0, len(renderers))
+	for _, r := range renderers {
+		list = append(list, r)
+	}
+	return list
+}
+
+func getRenderer(udn string) (*Renderer, error) {
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
+	r, ok := renderers[udn]
+	if !ok {
+		return nil, ErrNoSuchRenderer
+	}
+	return r, nil
+}
+
+// search finds devices with SSDP. Tests replace it.
+var search = ssdp.Search
+
+// Discover searches the network for MediaRenderers and adds those which
+// answer.
+func Discover(ctx context.Context) error {
+	responses, err := search(ctx, mediaRenderer, 2)
+	if err != nil {
+		return err
+	}
+	for _, res := range responses {
+		if _, err := AddRenderer(ctx, res.Location); err != nil {
+			log.Printf("playto: skipping renderer at %s: %s", res.Location, err)
+		}
+	}
+	return nil
+}
+
+// localHost returns the host of an http URL if it is on the local link: this
+// host, or one in the subnet of a network interface. Host names are refused,
+// as they may resolve elsewhere by the time the URL is fetched.
+func localHost(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "http" {
+		return "", ErrNotLocal
+	}
+	ip := net.ParseIP(u.Hostname())
+	if ip == nil {
+		return "", ErrNotLocal
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return u.Hostname(), nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.Contains(ip) {
+			return u.Hostname(), nil
+		}
+	}
+	return "", ErrNotLocal
+}
+
+// AddRenderer reads the description of the renderer at location and asks
+// which formats it plays. Renderers which SSDP cannot reach, such as local
+// stubs, can be added this way. The renderer must be on the local link, and
+// so must its services, so that neither a client of the API nor an SSDP
+// response can make us send requests elsewhere.
+func AddRenderer(ctx context.Context, location string) (*Renderer, error) {
+	host, err := localHost(location)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := soap.FetchDevice(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := desc.Client(connectionManager)
+	if err != nil {
+		return nil, err
+	}
+	av, err := desc.Client(avTransport)
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range []*soap.Client{cm, av} {
+		if controlHost, err := localHost(client.ControlURL); err != nil || controlHost != host {
+			return nil, ErrNotLocal
+		}
+	}
+	var protocolInfo soap.ConnectionManagerGetProtocolInfoResponse
+	if err := cm.Invoke(ctx, "GetProtocolInfo", nil, &protocolInfo); err != nil {
+		return nil, err
+	}
+	r := &Renderer{
+		UDN:          desc.Device.UDN,
+		FriendlyName: desc.Device.FriendlyName,
+		Location:     location,
+		avTransport:  av,
+	}
+	for _, info := range strings.Split(protocolInfo.Sink, ",") {
+		if info = strings.TrimSpace(info); info != "" {
+			r.sinkProtocolInfo = append(r.sinkProtocolInfo, info)
+		}
+	}
+	renderersMu.Lock()
+	renderers[r.UDN] = r
+	renderersMu.Unlock()
+	return r, nil
+}
+
+// dlnaProfile returns the DLNA.ORG_PN parameter of the fourth field of a
+// protocolInfo.
+func dlnaProfile(additionalInfo string) string {
+	for _, param := range strings.Split(additionalInfo, ";") {
+		if strings.HasPrefix(param, "DLNA.ORG_PN=") {
+			return strings.TrimPrefix(param, "DLNA.ORG_PN=")
+		}
+	}
+	return ""
+}
+
+// protocolInfoMatches reports whether a renderer accepting sink can play a
+// resource with the source protocolInfo.
+func protocolInfoMatches(sink string, source string) bool {
+	s, r := strings.SplitN(sink, ":", 4), strings.SplitN(source, ":", 4)
+	if len(s) != 4 || len(r) != 4 {
+		return false
+	}
+	if s[0] != r[0] {
+		return false
+	}
+	if s[2] != "*" && !strings.EqualFold(s[2], r[2]) {
+		return false
+	}
+	if s[3] == "*" {
+		return true
+	}
+	profile := dlnaProfile(s[3])
+	return profile == "" || profile == dlnaProfile(r[3])
+}
+
+// Supports reports whether the renderer can play a resource with the given
+// protocolInfo.
+func (r *Renderer) Supports(protocolInfo string) bool {
+	for _, sink := range r.sinkProtocolInfo {
+		if protocolInfoMatches(sink, protocolInfo) {
+			return true
+		}
+	}
+	return false
+}
+
+type setAVTransportURI struct {
+	InstanceID         uint32
+	CurrentURI         string
+	CurrentURIMetaData string
+}
+
+type play struct {
+	InstanceID uint32
+	Speed      string
+}
+
+type instance struct {
+	InstanceID uint32
+}
+
+type seek struct {
+	InstanceID uint32
+	Unit       string
+	Target     string
+}
+
+// getObject and marshalMetadata look up the items to play in the content
+// directory. Tests replace them.
+var getObject = contentdirectory.GetObject
+var marshalMetadata = contentdirectory.MarshalMetadata
+
+// metadataVersion is the version of ContentDirectory whose properties go in
+// CurrentURIMetaData. Renderers are not told which versions we serve, so only
+// the properties of version 1 are sent.
+const metadataVersion = 1
+
+// Play makes the renderer play the first resource of an item it supports.
+func (r *Renderer) Play(ctx context.Context, objectID string) error {
+	item, ok := getObject(objectID).(*contentdirectory.Item)
+	if !ok || item.Resources == nil {
+		return ErrNoSuchItem
+	}
+	var uri string
+	for _, res := range *item.Resources {
+		if r.Supports(res.ProtocolInfo) {
+			uri = res.URL
+			break
+		}
+	}
+	if uri == "" {
+		return ErrUnsupportedFormat
+	}
+	if err := r.avTransport.Invoke(ctx, "SetAVTransportURI", &setAVTransportURI{
+		InstanceID:         0,
+		CurrentURI:         uri,
+		CurrentURIMetaData: marshalMetadata(objectID, "*", metadataVersion),
+	}, nil); err != nil {
+		return err
+	}
+	return r.avTransport.Invoke(ctx, "Play", &play{InstanceID: 0, Speed: "1"}, nil)
+}
+
+// Resume resumes playback after Pause.
+func (r *Renderer) Resume(ctx context.Context) error {
+	return r.avTransport.Invoke(ctx, "Play", &play{InstanceID: 0, Speed: "1"}, nil)
+}
+
+func (r *Renderer) Pause(ctx context.Context) error {
+	return r.avTransport.Invoke(ctx, "Pause", &instance{InstanceID: 0}, nil)
+}
+
+func (r *Renderer) Stop(ctx context.Context) error {
+	return r.avTransport.Invoke(ctx, "Stop", &instance{InstanceID: 0}, nil)
+}
+
+// Seek moves playback to position from the start of the recording.
+func (r *Renderer) Seek(ctx context.Context, position time.Duration) error {
+	h := position / time.Hour
+	m := (position % time.Hour) / time.Minute
+	s := (position % time.Minute) / time.Second
+	return r.avTransport.Invoke(ctx, "Seek", &seek{
+		InstanceID: 0,
+		Unit:       "REL_TIME",
+		Target:     fmt.Sprintf("%d:%02d:%02d", h, m, s),
+	}, nil)
+}
@@ -0,0 +1,351 @@
+package playto
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-upnp-playground/service/contentdirectory"
+	"go-upnp-playground/soap"
+	"go-upnp-playground/ssdp"
+)
+
+func TestProtocolInfoMatches(t *testing.T) {
+	source := testResource().ProtocolInfo
+	if got := dlnaProfile(source[len("http-get:*:video/mpeg:"):]); got != "MPEG_PS_NTSC" {
+		t.Fatalf("profile of %s is %q", source, got)
+	}
+	for _, test := range []struct {
+		sink string
+		want bool
+	}{
+		{"http-get:*:video/mpeg:*", true},
+		{"http-get:*:*:*", true},
+		{"http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_PS_NTSC", true},
+		{"http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_PS_NTSC;DLNA.ORG_OP=01", true},
+		{"http-get:*:VIDEO/MPEG:DLNA.ORG_OP=01", true},
+		{"http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_TS_HD_NA_ISO", false},
+		{"http-get:*:video/mp4:*", false},
+		{"rtsp-rtp-udp:*:video/mpeg:*", false},
+		{"http-get:*:video/mpeg", false},
+	} {
+		if got := protocolInfoMatches(test.sink, source); got != test.want {
+			t.Errorf("protocolInfoMatches(%q, %q) = %v, want %v", test.sink, source, got, test.want)
+		}
+	}
+}
+
+const stubDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:MediaRenderer:1</deviceType>
+    <friendlyName>Stub TV</friendlyName>
+    <UDN>uuid:stub-renderer</UDN>
+    <serviceList>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:ConnectionManager:1</serviceType>
+        <serviceId>urn:upnp-org:serviceId:ConnectionManager</serviceId>
+        <SCPDURL>/cm.xml</SCPDURL>
+        <controlURL>/cm</controlURL>
+        <eventSubURL>/cm/event</eventSubURL>
+      </service>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:AVTransport:1</serviceType>
+        <serviceId>urn:upnp-org:serviceId:AVTransport</serviceId>
+        <SCPDURL>/av.xml</SCPDURL>
+        <controlURL>/av</controlURL>
+        <eventSubURL>/av/event</eventSubURL>
+      </service>
+    </serviceList>
+  </device>
+</root>`
+
+// A stubRenderer is a MediaRenderer serving its description, GetProtocolInfo
+// and AVTransport, which records the AVTransport actions invoked.
+type stubRenderer struct {
+	*httptest.Server
+	sink string
+
+	mu    sync.Mutex
+	calls []stubCall
+	// faults are the UPnP errors actions fail with, by action.
+	faults map[string]int
+}
+
+type stubCall struct {
+	action string
+	args   map[string]string
+}
+
+func newStubRenderer(t *testing.T, sink string) *stubRenderer {
+	stub := &stubRenderer{sink: sink, faults: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/desc.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		io.WriteString(w, stubDescription)
+	})
+	mux.HandleFunc("/cm", func(w http.ResponseWriter, r *http.Request) {
+		stub.respond(w, r, connectionManager, "<Source></Source><Sink>"+html.EscapeString(stub.sink)+"</Sink>")
+	})
+	mux.HandleFunc("/av", func(w http.ResponseWriter, r *http.Request) {
+		stub.respond(w, r, avTransport, "")
+	})
+	stub.Server = httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// respond answers the action of r with the out arguments, or with a fault,
+// recording it if it is an AVTransport action.
+func (s *stubRenderer) respond(w http.ResponseWriter, r *http.Request, serviceType string, out string) {
+	header := strings.Trim(r.Header.Get("SOAPACTION"), `"`)
+	if !strings.HasPrefix(header, serviceType+"#") {
+		http.Error(w, "bad SOAPACTION "+header, http.StatusBadRequest)
+		return
+	}
+	action := strings.TrimPrefix(header, serviceType+"#")
+	var envelope struct {
+		Body struct {
+			Action struct {
+				Args []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			} `xml:",any"`
+		}
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&envelope); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	args := make(map[string]string)
+	for _, arg := range envelope.Body.Action.Args {
+		args[arg.XMLName.Local] = arg.Value
+	}
+	s.mu.Lock()
+	if serviceType == avTransport {
+		s.calls = append(s.calls, stubCall{action, args})
+	}
+	code := s.faults[action]
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	body := fmt.Sprintf(`<u:%sResponse xmlns:u="%s">%s</u:%sResponse>`, action, serviceType, out, action)
+	if code != 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		body = fmt.Sprintf(`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+			`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>Stub failure</errorDescription></UPnPError>`+
+			`</detail></s:Fault>`, code)
+	}
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>%s</s:Body></s:Envelope>`, body)
+}
+
+func (s *stubRenderer) takeCalls() []stubCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := s.calls
+	s.calls = nil
+	return calls
+}
+
+// testResource is the resource of the recording the tests play.
+func testResource() contentdirectory.Res {
+	res, err := contentdirectory.NewResource(&contentdirectory.Resource{ID: "1", Filename: "1.m2ts", Size: 1000}, contentdirectory.Probe{Duration: time.Hour})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return res
+}
+
+// testItem is the recording the tests play.
+func testItem() *contentdirectory.Item {
+	res := testResource()
+	res.URL = "http://192.0.2.1:8080/videos/recorded?videoFileId=1"
+	return &contentdirectory.Item{
+		Id:         "01/1",
+		ParentID:   "01",
+		Title:      "ニュース",
+		Class:      "object.item.videoItem",
+		Restricted: "true",
+		Resources:  &[]contentdirectory.Res{res},
+	}
+}
+
+// marshalledVersion is the version the metadata was last marshalled at.
+var marshalledVersion int
+
+func TestMain(m *testing.M) {
+	getObject = func(objectID string) interface{} {
+		if objectID != "01/1" {
+			return nil
+		}
+		return testItem()
+	}
+	marshalMetadata = func(objectID string, filter string, version int) string {
+		marshalledVersion = version
+		data, _ := xml.Marshal(contentdirectory.DIDLLite{Objects: []interface{}{getObject(objectID)}})
+		return string(data)
+	}
+	os.Exit(m.Run())
+}
+
+func TestAddRenderer(t *testing.T) {
+	stub := newStubRenderer(t, "http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_PS_NTSC,http-get:*:video/mp4:*")
+	r, err := AddRenderer(context.Background(), stub.URL+"/desc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.UDN != "uuid:stub-renderer" || r.FriendlyName != "Stub TV" {
+		t.Errorf("got renderer %+v", r)
+	}
+	if len(r.sinkProtocolInfo) != 2 {
+		t.Errorf("got sink protocolInfo %q", r.sinkProtocolInfo)
+	}
+	if found, err := getRenderer("uuid:stub-renderer"); err != nil || found != r {
+		t.Errorf("renderer not listed: %v", err)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	stub := newStubRenderer(t, "http-get:*:video/mpeg:*")
+	defer func(s func(context.Context, string, int) ([]ssdp.SearchResponse, error)) { search = s }(search)
+	search = func(ctx context.Context, target string, mx int) ([]ssdp.SearchResponse, error) {
+		if target != mediaRenderer {
+			t.Errorf("searched for %s", target)
+		}
+		return []ssdp.SearchResponse{
+			{ST: mediaRenderer, USN: "uuid:stub-renderer::" + mediaRenderer, Location: stub.URL + "/desc.xml"},
+			// Devices which do not answer are skipped.
+			{ST: mediaRenderer, USN: "uuid:gone::" + mediaRenderer, Location: stub.URL + "/gone.xml"},
+		}, nil
+	}
+
+	req := httptest.NewRequest("GET", apiPath+"?discover=1", nil)
+	w := httptest.NewRecorder()
+	Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("discover: %d %s", w.Code, w.Body)
+	}
+	var listed []Renderer
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, r := range listed {
+		found = found || r.UDN == "uuid:stub-renderer" && r.Location == stub.URL+"/desc.xml"
+	}
+	if !found {
+		t.Errorf("stub not among %+v", listed)
+	}
+}
+
+// callAPI invokes the Play to TV API and returns the status code.
+func callAPI(t *testing.T, path string, form url.Values) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	Handler.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func TestControl(t *testing.T) {
+	stub := newStubRenderer(t, "http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_PS_NTSC")
+	r, err := AddRenderer(context.Background(), stub.URL+"/desc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := apiPath + "/" + r.UDN
+
+	if code, body := callAPI(t, base+"/play", url.Values{"objectID": {"01/1"}}); code != http.StatusNoContent {
+		t.Fatalf("play: %d %s", code, body)
+	}
+	calls := stub.takeCalls()
+	if len(calls) != 2 || calls[0].action != "SetAVTransportURI" || calls[1].action != "Play" {
+		t.Fatalf("play invoked %+v", calls)
+	}
+	if uri := calls[0].args["CurrentURI"]; uri != "http://192.0.2.1:8080/videos/recorded?videoFileId=1" {
+		t.Errorf("CurrentURI is %q", uri)
+	}
+	if metadata := calls[0].args["CurrentURIMetaData"]; !strings.Contains(metadata, "ニュース") {
+		t.Errorf("CurrentURIMetaData is %q", metadata)
+	}
+	if marshalledVersion != 1 {
+		t.Errorf("CurrentURIMetaData marshalled at version %d", marshalledVersion)
+	}
+	if calls[0].args["InstanceID"] != "0" || calls[1].args["Speed"] != "1" {
+		t.Errorf("got arguments %+v", calls)
+	}
+
+	for _, test := range []struct {
+		path   string
+		form   url.Values
+		action string
+		args   map[string]string
+	}{
+		{"/pause", nil, "Pause", map[string]string{"InstanceID": "0"}},
+		{"/resume", nil, "Play", map[string]string{"InstanceID": "0", "Speed": "1"}},
+		{"/seek", url.Values{"position": {"3725"}}, "Seek", map[string]string{"InstanceID": "0", "Unit": "REL_TIME", "Target": "1:02:05"}},
+		{"/stop", nil, "Stop", map[string]string{"InstanceID": "0"}},
+	} {
+		if code, body := callAPI(t, base+test.path, test.form); code != http.StatusNoContent {
+			t.Errorf("%s: %d %s", test.path, code, body)
+			continue
+		}
+		calls := stub.takeCalls()
+		if len(calls) != 1 || calls[0].action != test.action {
+			t.Errorf("%s invoked %+v", test.path, calls)
+			continue
+		}
+		for name, value := range test.args {
+			if calls[0].args[name] != value {
+				t.Errorf("%s: %s is %q, want %q", test.path, name, calls[0].args[name], value)
+			}
+		}
+	}
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	stub := newStubRenderer(t, "http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_TS_HD_NA_ISO,http-get:*:video/mp4:*")
+	r, err := AddRenderer(context.Background(), stub.URL+"/desc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, body := callAPI(t, apiPath+"/"+r.UDN+"/play", url.Values{"objectID": {"01/1"}}); code != http.StatusUnsupportedMediaType {
+		t.Errorf("play: %d %s", code, body)
+	}
+	if calls := stub.takeCalls(); len(calls) != 0 {
+		t.Errorf("play invoked %+v", calls)
+	}
+}
+
+func TestUPnPError(t *testing.T) {
+	stub := newStubRenderer(t, "http-get:*:*:*")
+	r, err := AddRenderer(context.Background(), stub.URL+"/desc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.faults["Pause"] = 701
+	err = r.Pause(context.Background())
+	var upnpErr *soap.UPnPError
+	if !errors.As(err, &upnpErr) || upnpErr.Code != 701 || upnpErr.Description != "Stub failure" {
+		t.Fatalf("got %v, want UPnP error 701", err)
+	}
+	if code, _ := callAPI(t, apiPath+"/"+r.UDN+"/pause", nil); code != http.StatusBadGateway {
+		t.Errorf("pause answered %d, want 502", code)
+	}
+}
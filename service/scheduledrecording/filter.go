@@ -0,0 +1,79 @@
+package scheduledrecording
+
+import "strings"
+
+// A filter selects the optional properties of record schedules and tasks to
+// send, as requested by the Filter argument. The ID, title, class, state and
+// the schedule of a task are always sent.
+type filter struct {
+	all        bool
+	properties map[string]bool
+}
+
+// parseFilter parses "*" or a comma separated list of properties such as
+// "scheduledChannelID,scheduledDuration". Properties may have the srs: prefix.
+func parseFilter(s string) filter {
+	f := filter{properties: make(map[string]bool)}
+	for _, property := range strings.Split(s, ",") {
+		property = strings.TrimSpace(property)
+		if property == "*" {
+			f.all = true
+		}
+		f.properties[strings.TrimPrefix(property, "srs:")] = true
+	}
+	return f
+}
+
+func (f filter) includes(property string) bool {
+	return f.all || f.properties[property]
+}
+
+// apply returns a copy of a record schedule or task with only the properties
+// f selects. The cached objects are shared, so they are never changed.
+func (f filter) apply(item interface{}) interface{} {
+	if f.all {
+		return item
+	}
+	switch o := item.(type) {
+	case *RecordSchedule:
+		filtered := *o
+		if !f.includes("scheduledChannelID") {
+			filtered.ScheduledChannelID = nil
+		}
+		if !f.includes("scheduledStartDateTime") {
+			filtered.ScheduledStartDateTime = ""
+		}
+		if !f.includes("scheduledDuration") {
+			filtered.ScheduledDuration = ""
+		}
+		if !f.includes("scheduledProgramCode") {
+			filtered.ScheduledProgramCode = nil
+		}
+		if !f.includes("matchingName") {
+			filtered.MatchingName = nil
+		}
+		if !f.includes("recordDestination") {
+			filtered.RecordDestination = nil
+		}
+		return &filtered
+	case *RecordTask:
+		filtered := *o
+		if !f.includes("scheduledChannelID") {
+			filtered.ScheduledChannelID = nil
+		}
+		if !f.includes("scheduledStartDateTime") {
+			filtered.ScheduledStartDateTime = ""
+		}
+		if !f.includes("scheduledDuration") {
+			filtered.ScheduledDuration = ""
+		}
+		if !f.includes("scheduledProgramCode") {
+			filtered.ScheduledProgramCode = nil
+		}
+		if !f.includes("recordDestination") {
+			filtered.RecordDestination = nil
+		}
+		return &filtered
+	}
+	return item
+}
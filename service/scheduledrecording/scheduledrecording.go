@@ -0,0 +1,672 @@
+// Package scheduledrecording implements the ScheduledRecording:1 service on top
+// of the reserves and rules of EPGStation. Keyword rules are record schedules
+// of class QUERY.CONTENTNAME and reserves made by hand are DIRECT schedules of
+// their own. Every reserve is a record task of the schedule it came from.
+package scheduledrecording
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"go-upnp-playground/epgstation"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSuchRecordSchedule = errors.New("no such record schedule")
+	ErrInvalidSyntax        = errors.New("invalid syntax")
+	ErrInvalidValue         = errors.New("invalid value")
+	ErrUnsupportedClass     = errors.New("unsupported class")
+	ErrInvalidSortCriteria  = errors.New("unsupported sort criteria")
+)
+
+// A change is an entry of LastChange, such as recordScheduleCreated.
+type change struct {
+	XMLName  xml.Name
+	ObjectID string `xml:"objectID,attr"`
+	UpdateID int    `xml:"updateID,attr"`
+}
+
+type stateEvent struct {
+	XMLName xml.Name `xml:"urn:schemas-upnp-org:av:srs-event StateEvent"`
+	Changes []change
+}
+
+// pageSize is how many rules or reserves each request to EPGStation asks for.
+const pageSize = 100
+
+// pollInterval is how often RunPoll looks at EPGStation for changes.
+const pollInterval = 1 * time.Minute
+
+var errReservesChanged = errors.New("reserves changed while paging")
+
+// refreshMu serializes loads, so that an older load never replaces a newer
+// one.
+var refreshMu sync.Mutex
+
+// mu guards stateUpdateID, known and the schedules and tasks of the last load,
+// which actions answer from.
+var mu sync.Mutex
+var stateUpdateID int
+var schedules []*RecordSchedule
+var tasks []*RecordTask
+
+// known maps the ID of every object seen by the last load to its XML, to tell
+// which objects changed since. It is nil until the first load.
+var known map[string][]byte
+
+var updateListeners []func(stateUpdateID int, lastChange string)
+
+// OnUpdate registers f to be called with the new StateUpdateID and LastChange
+// whenever schedules or tasks change.
+func OnUpdate(f func(stateUpdateID int, lastChange string)) {
+	updateListeners = append(updateListeners, f)
+}
+
+// ruleItem is a rule of GET /rules. The generated Rule lacks the id.
+type ruleItem struct {
+	ID           epgstation.RuleId `json:"id"`
+	SearchOption struct {
+		Keyword *string `json:"keyword"`
+	} `json:"searchOption"`
+	ReserveOption struct {
+		Enable bool `json:"enable"`
+	} `json:"reserveOption"`
+}
+
+// fetchRules pages through the rules.
+func fetchRules(ctx context.Context) ([]ruleItem, error) {
+	var rules []ruleItem
+	for {
+		offset, limit := epgstation.Offset(len(rules)), epgstation.Limit(pageSize)
+		res, err := epgstation.EPGStation.GetRulesWithResponse(ctx, &epgstation.GetRulesParams{
+			Offset: &offset,
+			Limit:  &limit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.JSON200 == nil {
+			return nil, fmt.Errorf("get rules: %s", res.Status())
+		}
+		var page struct {
+			Rules []ruleItem `json:"rules"`
+			Total int        `json:"total"`
+		}
+		if err := json.Unmarshal(res.Body, &page); err != nil {
+			return nil, err
+		}
+		rules = append(rules, page.Rules...)
+		if len(page.Rules) == 0 || len(rules) >= page.Total {
+			return rules, nil
+		}
+	}
+}
+
+// fetchReserves pages through the reserves. It starts over should the total
+// change in between, as the pages would have shifted.
+func fetchReserves(ctx context.Context) ([]epgstation.ReserveItem, error) {
+	for tries := 0; tries < 3; tries++ {
+		reserves, err := fetchReservePages(ctx)
+		if err == errReservesChanged {
+			continue
+		}
+		return reserves, err
+	}
+	return nil, errReservesChanged
+}
+
+func fetchReservePages(ctx context.Context) ([]epgstation.ReserveItem, error) {
+	var reserves []epgstation.ReserveItem
+	total := -1
+	for {
+		offset, limit := epgstation.Offset(len(reserves)), epgstation.Limit(pageSize)
+		res, err := epgstation.EPGStation.GetReservesWithResponse(ctx, &epgstation.GetReservesParams{
+			IsHalfWidth: false,
+			Offset:      &offset,
+			Limit:       &limit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.JSON200 == nil {
+			return nil, fmt.Errorf("get reserves: %s", res.Status())
+		}
+		if total >= 0 && res.JSON200.Total != total {
+			return nil, errReservesChanged
+		}
+		total = res.JSON200.Total
+		reserves = append(reserves, res.JSON200.Reserves...)
+		if len(res.JSON200.Reserves) == 0 || len(reserves) >= total {
+			return reserves, nil
+		}
+	}
+}
+
+// load reads the rules and reserves of EPGStation as record schedules and
+// tasks.
+func load() ([]*RecordSchedule, []*RecordTask, error) {
+	rules, err := fetchRules(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+	reserves, err := fetchReserves(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	var schedules []*RecordSchedule
+	tasks := make([]*RecordTask, 0, len(reserves))
+	taskCounts := make(map[string]int)
+	for _, reserve := range reserves {
+		tasks = append(tasks, newRecordTask(reserve, now))
+		taskCounts[scheduleIDOf(reserve)]++
+		if reserve.RuleId == nil {
+			schedules = append(schedules, newManualSchedule(reserve))
+		}
+	}
+	for _, rule := range rules {
+		item := epgstation.RuleKeywordItem{Id: rule.ID}
+		if rule.SearchOption.Keyword != nil {
+			item.Keyword = *rule.SearchOption.Keyword
+		}
+		taskCount := taskCounts[fmt.Sprintf("rule%d", rule.ID)]
+		schedules = append(schedules, newRuleSchedule(item, rule.ReserveOption.Enable, taskCount))
+	}
+	sort.SliceStable(schedules, func(i, j int) bool {
+		return schedules[i].ID < schedules[j].ID
+	})
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].ScheduledStartDateTime < tasks[j].ScheduledStartDateTime
+	})
+	return schedules, tasks, nil
+}
+
+// track compares the objects with those of the last load, giving each change
+// the next StateUpdateID, and returns the LastChange to event. It keeps the
+// objects for the actions to answer from, unless marshalling fails.
+func track(newSchedules []*RecordSchedule, newTasks []*RecordTask) (string, error) {
+	current := make(map[string][]byte, len(newSchedules)+len(newTasks))
+	for _, s := range newSchedules {
+		data, err := xml.Marshal(s)
+		if err != nil {
+			return "", err
+		}
+		current[s.ID] = data
+	}
+	for _, t := range newTasks {
+		data, err := xml.Marshal(t)
+		if err != nil {
+			return "", err
+		}
+		current[t.ID] = data
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	previous := known
+	if previous == nil {
+		schedules, tasks, known = newSchedules, newTasks, current
+		return "", nil
+	}
+
+	ids := make([]string, 0, len(current)+len(previous))
+	for id := range current {
+		ids = append(ids, id)
+	}
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	updateID := stateUpdateID
+	var changes []change
+	for _, id := range ids {
+		kind := "recordSchedule"
+		if strings.HasPrefix(id, "task") {
+			kind = "recordTask"
+		}
+		old, existed := previous[id]
+		data, exists := current[id]
+		switch {
+		case !existed:
+			kind += "Created"
+		case !exists:
+			kind += "Deleted"
+		case !bytes.Equal(old, data):
+			kind += "Modified"
+		default:
+			continue
+		}
+		updateID++
+		changes = append(changes, change{
+			XMLName:  xml.Name{Space: "urn:schemas-upnp-org:av:srs-event", Local: kind},
+			ObjectID: id,
+			UpdateID: updateID,
+		})
+	}
+	var lastChange string
+	if len(changes) > 0 {
+		data, err := xml.Marshal(stateEvent{Changes: changes})
+		if err != nil {
+			return "", err
+		}
+		lastChange = xml.Header + string(data)
+	}
+	schedules, tasks, known = newSchedules, newTasks, current
+	stateUpdateID = updateID
+	return lastChange, nil
+}
+
+// refresh loads the schedules and tasks and events what changed, whether
+// through this service or on EPGStation itself.
+func refresh() ([]*RecordSchedule, []*RecordTask, int, error) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	schedules, tasks, err := load()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	lastChange, err := track(schedules, tasks)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	updateID := GetStateUpdateID()
+	if lastChange != "" {
+		for _, f := range updateListeners {
+			f(updateID, lastChange)
+		}
+	}
+	return schedules, tasks, updateID, nil
+}
+
+// cached returns the schedules and tasks of the last load, loading them if
+// there was none yet.
+func cached() ([]*RecordSchedule, []*RecordTask, int, error) {
+	mu.Lock()
+	if known != nil {
+		defer mu.Unlock()
+		return schedules, tasks, stateUpdateID, nil
+	}
+	mu.Unlock()
+	return refresh()
+}
+
+// GetStateUpdateID returns the StateUpdateID of the last change seen.
+func GetStateUpdateID() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return stateUpdateID
+}
+
+// Refresh checks EPGStation for changes, so that GetStateUpdateID and the
+// LastChange event reflect reserves made elsewhere.
+func Refresh() error {
+	_, _, _, err := refresh()
+	return err
+}
+
+// RunPoll refreshes every pollInterval until ctx is done, so that changes
+// made on EPGStation itself are evented without waiting for an action.
+func RunPoll(ctx context.Context) {
+	if err := Refresh(); err != nil {
+		log.Printf("loading record schedules failed: %s", err)
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := Refresh(); err != nil {
+			log.Printf("loading record schedules failed: %s", err)
+		}
+	}
+}
+
+// page returns the part of n objects that a Browse starting at index for
+// count objects returns. count 0 means all the rest.
+func page(n int, startingIndex int, requestedCount int) (int, int) {
+	if startingIndex > n {
+		startingIndex = n
+	}
+	end := n
+	if requestedCount > 0 && startingIndex+requestedCount < n {
+		end = startingIndex + requestedCount
+	}
+	return startingIndex, end
+}
+
+// browse returns the srs document of the page of items sorted by keys, with
+// the properties f selects, the number returned and the total.
+func browse(items []interface{}, f filter, keys []sortKey, startingIndex int, requestedCount int) (string, int, int, error) {
+	sortItems(items, keys)
+	start, end := page(len(items), startingIndex, requestedCount)
+	selected := make([]interface{}, 0, end-start)
+	for _, item := range items[start:end] {
+		selected = append(selected, f.apply(item))
+	}
+	result, err := marshalSRS(selected)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return result, len(selected), len(items), nil
+}
+
+// BrowseRecordSchedules returns the srs document of the record schedules with
+// the properties in filter, sorted by sortCriteria, the number returned, the
+// total and the StateUpdateID.
+func BrowseRecordSchedules(filter string, sortCriteria string, startingIndex int, requestedCount int) (string, int, int, int, error) {
+	keys, err := parseSortCriteria(sortCriteria)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+	schedules, _, updateID, err := cached()
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+	items := make([]interface{}, len(schedules))
+	for i, s := range schedules {
+		items[i] = s
+	}
+	result, returned, total, err := browse(items, parseFilter(filter), keys, startingIndex, requestedCount)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+	return result, returned, total, updateID, nil
+}
+
+// BrowseRecordTasks returns the record tasks of a schedule, or of all
+// schedules if recordScheduleID is empty, like BrowseRecordSchedules.
+func BrowseRecordTasks(recordScheduleID string, filter string, sortCriteria string, startingIndex int, requestedCount int) (string, int, int, int, error) {
+	keys, err := parseSortCriteria(sortCriteria)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+	schedules, tasks, updateID, err := cached()
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+	if recordScheduleID != "" && findSchedule(schedules, recordScheduleID) == nil {
+		return "", 0, 0, 0, ErrNoSuchRecordSchedule
+	}
+	var items []interface{}
+	for _, t := range tasks {
+		if recordScheduleID == "" || t.RecordScheduleID == recordScheduleID {
+			items = append(items, t)
+		}
+	}
+	result, returned, total, err := browse(items, parseFilter(filter), keys, startingIndex, requestedCount)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+	return result, returned, total, updateID, nil
+}
+
+func findSchedule(schedules []*RecordSchedule, id string) *RecordSchedule {
+	for _, s := range schedules {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// GetRecordSchedule returns the srs document of a single record schedule with
+// the properties in filter and the StateUpdateID.
+func GetRecordSchedule(recordScheduleID string, filter string) (string, int, error) {
+	schedules, _, updateID, err := cached()
+	if err != nil {
+		return "", 0, err
+	}
+	schedule := findSchedule(schedules, recordScheduleID)
+	if schedule == nil {
+		return "", 0, ErrNoSuchRecordSchedule
+	}
+	result, err := marshalSRS([]interface{}{parseFilter(filter).apply(schedule)})
+	if err != nil {
+		return "", 0, err
+	}
+	return result, updateID, nil
+}
+
+// parseScheduleID splits a record schedule ID such as "rule3" into its kind,
+// "rule" or "reserve", and the EPGStation ID.
+func parseScheduleID(id string) (string, int, error) {
+	for _, kind := range []string{"rule", "reserve"} {
+		if strings.HasPrefix(id, kind) {
+			n, err := strconv.Atoi(strings.TrimPrefix(id, kind))
+			if err != nil {
+				break
+			}
+			return kind, n, nil
+		}
+	}
+	return "", 0, ErrNoSuchRecordSchedule
+}
+
+// timeSpecifiedOption and manualReserveOption are the body of POST
+// /reserves. The generated ManualReserveOption lacks the programId and
+// timeSpecifiedOption of the first schema of its allOf.
+type timeSpecifiedOption struct {
+	Name      string                `json:"name"`
+	ChannelId epgstation.ChannelId  `json:"channelId"`
+	StartAt   epgstation.UnixtimeMS `json:"startAt"`
+	EndAt     epgstation.UnixtimeMS `json:"endAt"`
+}
+
+type manualReserveOption struct {
+	ProgramId           *epgstation.ProgramId `json:"programId,omitempty"`
+	TimeSpecifiedOption *timeSpecifiedOption  `json:"timeSpecifiedOption,omitempty"`
+	epgstation.EditManualReserveOption
+}
+
+func postReserve(option manualReserveOption) (string, error) {
+	body, err := json.Marshal(option)
+	if err != nil {
+		return "", err
+	}
+	res, err := epgstation.EPGStation.PostReservesWithBodyWithResponse(context.Background(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	if res.JSON201 == nil {
+		return "", fmt.Errorf("post reserve: %s", res.Status())
+	}
+	return fmt.Sprintf("reserve%d", res.JSON201.ReserveId), nil
+}
+
+func postRule(keyword string) (string, error) {
+	enable := true
+	res, err := epgstation.EPGStation.PostRulesWithResponse(context.Background(), epgstation.PostRulesJSONRequestBody{
+		SearchOption: epgstation.RuleSearchOption{
+			Keyword: &keyword,
+			Name:    &enable,
+			GR:      &enable,
+			BS:      &enable,
+			CS:      &enable,
+			SKY:     &enable,
+		},
+		ReserveOption: epgstation.RuleReserveOption{
+			Enable:       true,
+			AllowEndLack: true,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if res.JSON201 == nil {
+		return "", fmt.Errorf("post rule: %s", res.Status())
+	}
+	return fmt.Sprintf("rule%d", res.JSON201.RuleId), nil
+}
+
+// createSchedule creates the reserve or rule for the properties of a record
+// schedule and returns the ID of the new schedule.
+func createSchedule(elements *RecordSchedule) (string, error) {
+	switch elements.Class {
+	case classManual:
+		if elements.Title == "" || elements.ScheduledChannelID == nil || elements.ScheduledStartDateTime == "" || elements.ScheduledDuration == "" {
+			return "", ErrInvalidValue
+		}
+		channel, err := strconv.Atoi(elements.ScheduledChannelID.Value)
+		if err != nil || elements.ScheduledChannelID.Type != channelIDType {
+			return "", ErrInvalidValue
+		}
+		start, err := parseDateTime(elements.ScheduledStartDateTime)
+		if err != nil {
+			return "", ErrInvalidValue
+		}
+		duration, err := parseDuration(elements.ScheduledDuration)
+		if err != nil || duration <= 0 {
+			return "", ErrInvalidValue
+		}
+		startAt := epgstation.UnixtimeMS(start.UnixNano() / int64(time.Millisecond))
+		return postReserve(manualReserveOption{
+			TimeSpecifiedOption: &timeSpecifiedOption{
+				Name:      elements.Title,
+				ChannelId: epgstation.ChannelId(channel),
+				StartAt:   startAt,
+				EndAt:     startAt + epgstation.UnixtimeMS(duration/time.Millisecond),
+			},
+		})
+	case classProgramCode:
+		if elements.ScheduledProgramCode == nil || elements.ScheduledProgramCode.Type != programCodeType {
+			return "", ErrInvalidValue
+		}
+		programID, err := strconv.Atoi(elements.ScheduledProgramCode.Value)
+		if err != nil {
+			return "", ErrInvalidValue
+		}
+		id := epgstation.ProgramId(programID)
+		return postReserve(manualReserveOption{ProgramId: &id})
+	case classContentName:
+		if elements.MatchingName == nil || strings.TrimSpace(elements.MatchingName.Value) == "" {
+			return "", ErrInvalidValue
+		}
+		return postRule(strings.TrimSpace(elements.MatchingName.Value))
+	}
+	return "", ErrUnsupportedClass
+}
+
+// CreateRecordSchedule creates a record schedule from the srs document
+// elements. Schedules of class DIRECT.MANUAL and DIRECT.PROGRAMCODE become
+// reserves and those of class QUERY.CONTENTNAME keyword rules. It returns the
+// ID of the schedule, its srs document and the StateUpdateID.
+func CreateRecordSchedule(elements string) (string, string, int, error) {
+	var doc struct {
+		Items []RecordSchedule `xml:"item"`
+	}
+	if err := xml.Unmarshal([]byte(elements), &doc); err != nil {
+		return "", "", 0, ErrInvalidSyntax
+	}
+	if len(doc.Items) != 1 {
+		return "", "", 0, ErrInvalidSyntax
+	}
+	id, err := createSchedule(&doc.Items[0])
+	if err != nil {
+		return "", "", 0, err
+	}
+	if err := Refresh(); err != nil {
+		return "", "", 0, err
+	}
+	result, updateID, err := GetRecordSchedule(id, "*")
+	if err != nil {
+		return "", "", 0, err
+	}
+	return id, result, updateID, nil
+}
+
+func checkStatus(res interface{ StatusCode() int }, format string, a ...interface{}) error {
+	if res.StatusCode() != http.StatusOK {
+		return fmt.Errorf(format+": status %d", append(a, res.StatusCode())...)
+	}
+	return nil
+}
+
+// DeleteRecordSchedule deletes a rule, which takes its reserves with it, or a
+// reserve made by hand.
+func DeleteRecordSchedule(recordScheduleID string) error {
+	if _, _, err := GetRecordSchedule(recordScheduleID, "*"); err != nil {
+		return err
+	}
+	kind, id, err := parseScheduleID(recordScheduleID)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case "rule":
+		res, err := epgstation.EPGStation.DeleteRulesRuleIdWithResponse(context.Background(), epgstation.PathRuleId(id))
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(res, "delete rule %d", id); err != nil {
+			return err
+		}
+	default:
+		res, err := epgstation.EPGStation.DeleteReservesReserveIdWithResponse(context.Background(), epgstation.PathReserveId(id))
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(res, "delete reserve %d", id); err != nil {
+			return err
+		}
+	}
+	return Refresh()
+}
+
+// setEnabled enables or disables a rule. Reserves made by hand are always
+// enabled; to disable one, delete it.
+func setEnabled(recordScheduleID string, enable bool) error {
+	if _, _, err := GetRecordSchedule(recordScheduleID, "*"); err != nil {
+		return err
+	}
+	kind, id, err := parseScheduleID(recordScheduleID)
+	if err != nil {
+		return err
+	}
+	if kind != "rule" {
+		if enable {
+			return nil
+		}
+		return ErrInvalidValue
+	}
+	if enable {
+		res, err := epgstation.EPGStation.PutRulesRuleIdEnableWithResponse(context.Background(), epgstation.PathRuleId(id))
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(res, "enable rule %d", id); err != nil {
+			return err
+		}
+	} else {
+		res, err := epgstation.EPGStation.PutRulesRuleIdDisableWithResponse(context.Background(), epgstation.PathRuleId(id))
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(res, "disable rule %d", id); err != nil {
+			return err
+		}
+	}
+	return Refresh()
+}
+
+func EnableRecordSchedule(recordScheduleID string) error {
+	return setEnabled(recordScheduleID, true)
+}
+
+func DisableRecordSchedule(recordScheduleID string) error {
+	return setEnabled(recordScheduleID, false)
+}
@@ -0,0 +1,227 @@
+package scheduledrecording
+
+import (
+	"go-upnp-playground/epgstation"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ms returns t in EPGStation's milliseconds.
+func ms(t time.Time) epgstation.UnixtimeMS {
+	return epgstation.UnixtimeMS(t.UnixNano() / int64(time.Millisecond))
+}
+
+// setupTest makes a rule with two reserves and two reserves made by hand the
+// last load.
+func setupTest(t *testing.T) {
+	t.Helper()
+	start := time.Date(2021, 1, 1, 21, 0, 0, 0, jst)
+	rule := epgstation.RuleId(1)
+	program := epgstation.ProgramId(100)
+	reserves := []epgstation.ReserveItem{
+		{Id: 1, RuleId: &rule, ChannelId: 10, Name: "ドラマ第1話", StartAt: ms(start), EndAt: ms(start.Add(time.Hour))},
+		{Id: 2, RuleId: &rule, ChannelId: 10, Name: "ドラマ第2話", StartAt: ms(start.Add(7 * 24 * time.Hour)), EndAt: ms(start.Add(7*24*time.Hour + time.Hour))},
+		{Id: 3, ChannelId: 11, ProgramId: &program, Name: "ニュース", StartAt: ms(start.Add(-time.Hour)), EndAt: ms(start.Add(-30 * time.Minute))},
+		{Id: 4, ChannelId: 12, IsTimeSpecified: true, IsConflict: true, Name: "映画", StartAt: ms(start.Add(24 * time.Hour)), EndAt: ms(start.Add(26 * time.Hour))},
+	}
+	now := start.Add(-2 * time.Hour)
+	var schedules []*RecordSchedule
+	var tasks []*RecordTask
+	for _, reserve := range reserves {
+		tasks = append(tasks, newRecordTask(reserve, now))
+		if reserve.RuleId == nil {
+			schedules = append(schedules, newManualSchedule(reserve))
+		}
+	}
+	schedules = append(schedules, newRuleSchedule(epgstation.RuleKeywordItem{Id: rule, Keyword: "ドラマ"}, true, 2))
+
+	mu.Lock()
+	known, stateUpdateID = nil, 0
+	mu.Unlock()
+	if _, err := track(schedules, tasks); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var itemID = regexp.MustCompile(`<item id="([^"]*)"`)
+
+// ids returns the IDs of the items of an srs document, in order.
+func ids(result string) []string {
+	var found []string
+	for _, match := range itemID.FindAllStringSubmatch(result, -1) {
+		found = append(found, match[1])
+	}
+	return found
+}
+
+func TestBrowseRecordSchedules(t *testing.T) {
+	setupTest(t)
+	for _, test := range []struct {
+		criteria string
+		want     []string
+	}{
+		{"", []string{"reserve3", "reserve4", "rule1"}},
+		{"-@id", []string{"rule1", "reserve4", "reserve3"}},
+		{"+title", []string{"ドラマ", "ニュース", "映画"}},
+		// The rule has no start and sorts first.
+		{"+scheduledStartDateTime", []string{"rule1", "reserve3", "reserve4"}},
+		{"-srs:scheduledStartDateTime", []string{"reserve4", "reserve3", "rule1"}},
+		{"+class,-title", []string{"reserve4", "reserve3", "rule1"}},
+	} {
+		result, returned, total, _, err := BrowseRecordSchedules("*", test.criteria, 0, 0)
+		if err != nil {
+			t.Errorf("sorted by %q: %v", test.criteria, err)
+			continue
+		}
+		got := ids(result)
+		if test.criteria == "+title" {
+			got = nil
+			for _, match := range regexp.MustCompile(`<title>([^<]*)</title>`).FindAllStringSubmatch(result, -1) {
+				got = append(got, match[1])
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) || returned != 3 || total != 3 {
+			t.Errorf("sorted by %q: got %v, %d of %d, want %v", test.criteria, got, returned, total, test.want)
+		}
+	}
+
+	result, returned, total, _, err := BrowseRecordSchedules("*", "+@id", 1, 1)
+	if err != nil || !reflect.DeepEqual(ids(result), []string{"reserve4"}) || returned != 1 || total != 3 {
+		t.Errorf("second page: %v, %d of %d, %v", ids(result), returned, total, err)
+	}
+
+	for _, criteria := range []string{"@id", "+dc:title", "+scheduleState", "+title,-title", "+title,", "*title"} {
+		if _, _, _, _, err := BrowseRecordSchedules("*", criteria, 0, 0); err != ErrInvalidSortCriteria {
+			t.Errorf("sorted by %q: want ErrInvalidSortCriteria, got %v", criteria, err)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	setupTest(t)
+	all, _, _, _, err := BrowseRecordSchedules("*", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, property := range []string{"scheduledChannelID", "scheduledStartDateTime", "scheduledDuration", "scheduledProgramCode", "matchingName", "recordDestination"} {
+		if !strings.Contains(all, "<"+property) {
+			t.Errorf("unfiltered schedules lack %s: %s", property, all)
+		}
+	}
+
+	result, _, _, _, err := BrowseRecordSchedules("srs:scheduledDuration,matchingName", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, property := range []string{"scheduledChannelID", "scheduledStartDateTime", "scheduledProgramCode", "recordDestination"} {
+		if strings.Contains(result, "<"+property) {
+			t.Errorf("filtered schedules have %s: %s", property, result)
+		}
+	}
+	for _, property := range []string{"title", "class", "scheduleState", "scheduledDuration", "matchingName"} {
+		if !strings.Contains(result, "<"+property) {
+			t.Errorf("filtered schedules lack %s: %s", property, result)
+		}
+	}
+
+	// The cached schedules are left as they were.
+	if again, _, _, _, _ := BrowseRecordSchedules("*", "", 0, 0); again != all {
+		t.Errorf("filtering changed the schedules: %s", again)
+	}
+
+	result, _, err = GetRecordSchedule("reserve3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result, "<scheduledProgramCode") || !strings.Contains(result, "<title>ニュース</title>") {
+		t.Errorf("GetRecordSchedule with an empty filter: %s", result)
+	}
+}
+
+func TestBrowseRecordTasks(t *testing.T) {
+	setupTest(t)
+	result, returned, total, _, err := BrowseRecordTasks("rule1", "*", "-scheduledStartDateTime", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(result); !reflect.DeepEqual(got, []string{"task2", "task1"}) || returned != 2 || total != 2 {
+		t.Errorf("tasks of rule1: got %v, %d of %d", got, returned, total)
+	}
+	if !strings.Contains(result, "<scheduledStartDateTime>2021-01-08T21:00:00+09:00</scheduledStartDateTime>") || !strings.Contains(result, "<scheduledDuration>P01:00:00</scheduledDuration>") {
+		t.Errorf("tasks of rule1: %s", result)
+	}
+
+	result, _, total, _, err = BrowseRecordTasks("", "recordDestination", "+scheduledStartDateTime", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(result); !reflect.DeepEqual(got, []string{"task3", "task1", "task4", "task2"}) || total != 4 {
+		t.Errorf("all tasks: got %v of %d", got, total)
+	}
+	if strings.Contains(result, "<scheduledStartDateTime") || !strings.Contains(result, "<recordDestination") || !strings.Contains(result, "<taskState>IDLE.NOTREADY</taskState>") {
+		t.Errorf("filtered tasks: %s", result)
+	}
+
+	if _, _, _, _, err := BrowseRecordTasks("rule9", "*", "", 0, 0); err != ErrNoSuchRecordSchedule {
+		t.Errorf("tasks of an unknown schedule: got %v", err)
+	}
+	if _, _, _, _, err := BrowseRecordTasks("", "*", "+taskState", 0, 0); err != ErrInvalidSortCriteria {
+		t.Errorf("tasks sorted by taskState: got %v", err)
+	}
+}
+
+func TestTrack(t *testing.T) {
+	setupTest(t)
+	mu.Lock()
+	schedules, tasks := append([]*RecordSchedule(nil), schedules...), append([]*RecordTask(nil), tasks...)
+	mu.Unlock()
+
+	renamed := *tasks[0]
+	renamed.Title = "ドラマ第1話(再)"
+	tasks[0] = &renamed
+	tasks = tasks[:3]
+	lastChange, err := track(schedules, tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range []string{`<recordTaskModified xmlns="urn:schemas-upnp-org:av:srs-event" objectID="task1" updateID="1">`, `<recordTaskDeleted xmlns="urn:schemas-upnp-org:av:srs-event" objectID="task4" updateID="2">`} {
+		if !strings.Contains(lastChange, change) {
+			t.Errorf("LastChange lacks %s: %s", change, lastChange)
+		}
+	}
+	if GetStateUpdateID() != 2 {
+		t.Errorf("StateUpdateID is %d, want 2", GetStateUpdateID())
+	}
+	if lastChange, err := track(schedules, tasks); err != nil || lastChange != "" {
+		t.Errorf("nothing changed, but got LastChange %q, %v", lastChange, err)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	for _, test := range []struct {
+		d time.Duration
+		s string
+	}{
+		{30 * time.Minute, "P00:30:00"},
+		{26*time.Hour + 5*time.Second, "P1D02:00:05"},
+	} {
+		if s := formatDuration(test.d); s != test.s {
+			t.Errorf("format %s: got %s, want %s", test.d, s, test.s)
+		}
+		if d, err := parseDuration(test.s); err != nil || d != test.d {
+			t.Errorf("parse %s: got %s, %v", test.s, d, err)
+		}
+	}
+	for _, s := range []string{"00:30:00", "P00:60:00", "P-1D00:00:00", "P00:30"} {
+		if _, err := parseDuration(s); err == nil {
+			t.Errorf("parse %s: want error", s)
+		}
+	}
+	// Start times without a zone are in JST.
+	if start, err := parseDateTime("2021-01-01T21:00:00"); err != nil || !start.Equal(time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("parse a JST start: got %s, %v", start, err)
+	}
+}
@@ -0,0 +1,99 @@
+package scheduledrecording
+
+import (
+	"sort"
+	"strings"
+)
+
+// SortCapabilities lists the properties of record schedules and tasks which
+// may appear in SortCriteria.
+var SortCapabilities = []string{"@id", "title", "class", "scheduledStartDateTime"}
+
+type sortKey struct {
+	property   string
+	descending bool
+}
+
+// parseSortCriteria parses a SortCriteria such as
+// "+scheduledStartDateTime,-title". Properties may have the srs: prefix.
+func parseSortCriteria(criteria string) ([]sortKey, error) {
+	var keys []sortKey
+	if strings.TrimSpace(criteria) == "" {
+		return keys, nil
+	}
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(criteria, ",") {
+		field = strings.TrimSpace(field)
+		var key sortKey
+		switch {
+		case strings.HasPrefix(field, "+"):
+			key.property = field[1:]
+		case strings.HasPrefix(field, "-"):
+			key.property, key.descending = field[1:], true
+		default:
+			return nil, ErrInvalidSortCriteria
+		}
+		key.property = strings.TrimPrefix(key.property, "srs:")
+		supported := false
+		for _, capability := range SortCapabilities {
+			supported = supported || capability == key.property
+		}
+		if !supported || seen[key.property] {
+			return nil, ErrInvalidSortCriteria
+		}
+		seen[key.property] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// sortValue returns a property of a record schedule or task, or "" if it has
+// none. Date times are all in JST, so they sort as strings.
+func sortValue(item interface{}, property string) string {
+	switch o := item.(type) {
+	case *RecordSchedule:
+		switch property {
+		case "@id":
+			return o.ID
+		case "title":
+			return o.Title
+		case "class":
+			return o.Class
+		case "scheduledStartDateTime":
+			return o.ScheduledStartDateTime
+		}
+	case *RecordTask:
+		switch property {
+		case "@id":
+			return o.ID
+		case "title":
+			return o.Title
+		case "class":
+			return o.Class
+		case "scheduledStartDateTime":
+			return o.ScheduledStartDateTime
+		}
+	}
+	return ""
+}
+
+// sortItems sorts record schedules or tasks in place. Items without a
+// property sort before items with it, and items which compare equal on every
+// key keep their original order.
+func sortItems(items []interface{}, keys []sortKey) {
+	if len(keys) == 0 {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		for _, key := range keys {
+			c := strings.Compare(sortValue(items[i], key.property), sortValue(items[j], key.property))
+			if key.descending {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,219 @@
+package scheduledrecording
+
+import (
+	"encoding/xml"
+	"fmt"
+	"go-upnp-playground/epgstation"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	classManual      = "OBJECT.RECORDSCHEDULE.DIRECT.MANUAL"
+	classProgramCode = "OBJECT.RECORDSCHEDULE.DIRECT.PROGRAMCODE"
+	classContentName = "OBJECT.RECORDSCHEDULE.QUERY.CONTENTNAME"
+	classRecordTask  = "OBJECT.RECORDTASK"
+)
+
+// EPGStation channel and program IDs follow none of the standard formats, so
+// they have vendor-defined types.
+const (
+	channelIDType   = "EPGStation_channelId"
+	programCodeType = "EPGStation_programId"
+)
+
+// jst is the time zone of the broadcasts.
+var jst = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+type typedValue struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type recordDestination struct {
+	MediaType  string `xml:"mediaType,attr"`
+	Preference int    `xml:"preference,attr"`
+	Value      string `xml:",chardata"`
+}
+
+// Recordings all go to the storage of EPGStation.
+var destination = recordDestination{MediaType: "HDD", Preference: 1, Value: "EPGStation"}
+
+// A RecordSchedule is a recordSchedule object. It is also what
+// CreateRecordSchedule decodes its Elements into.
+type RecordSchedule struct {
+	XMLName xml.Name `xml:"item"`
+
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title"`
+	Class string `xml:"class"`
+
+	ScheduledChannelID     *typedValue `xml:"scheduledChannelID,omitempty"`
+	ScheduledStartDateTime string      `xml:"scheduledStartDateTime,omitempty"`
+	ScheduledDuration      string      `xml:"scheduledDuration,omitempty"`
+	ScheduledProgramCode   *typedValue `xml:"scheduledProgramCode,omitempty"`
+	MatchingName           *typedValue `xml:"matchingName,omitempty"`
+
+	ScheduleState          string             `xml:"scheduleState,omitempty"`
+	CurrentRecordTaskCount int                `xml:"currentRecordTaskCount"`
+	RecordDestination      *recordDestination `xml:"recordDestination,omitempty"`
+}
+
+// A RecordTask is a recordTask object, a single recording of a schedule.
+type RecordTask struct {
+	XMLName xml.Name `xml:"item"`
+
+	ID               string `xml:"id,attr"`
+	Title            string `xml:"title"`
+	Class            string `xml:"class"`
+	RecordScheduleID string `xml:"recordScheduleID"`
+
+	ScheduledChannelID     *typedValue `xml:"scheduledChannelID,omitempty"`
+	ScheduledStartDateTime string      `xml:"scheduledStartDateTime,omitempty"`
+	ScheduledDuration      string      `xml:"scheduledDuration,omitempty"`
+	ScheduledProgramCode   *typedValue `xml:"scheduledProgramCode,omitempty"`
+
+	TaskState         string             `xml:"taskState"`
+	RecordDestination *recordDestination `xml:"recordDestination,omitempty"`
+}
+
+type srs struct {
+	XMLName xml.Name `xml:"urn:schemas-upnp-org:av:srs srs"`
+	Items   []interface{}
+}
+
+func marshalSRS(items []interface{}) (string, error) {
+	data, err := xml.Marshal(srs{Items: items})
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(data), nil
+}
+
+func formatDateTime(t epgstation.UnixtimeMS) string {
+	return time.Unix(int64(t)/1000, 0).In(jst).Format(time.RFC3339)
+}
+
+// parseDateTime parses a scheduledStartDateTime. Values without a time zone
+// are in JST, where the broadcasts are.
+func parseDateTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04:05", s, jst)
+}
+
+// formatDuration formats a scheduledDuration such as "P00:30:00" or
+// "P1D02:00:00".
+func formatDuration(d time.Duration) string {
+	days := d / (24 * time.Hour)
+	h := (d % (24 * time.Hour)) / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	if days > 0 {
+		return fmt.Sprintf("P%dD%02d:%02d:%02d", days, h, m, s)
+	}
+	return fmt.Sprintf("P%02d:%02d:%02d", h, m, s)
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	s = s[1:]
+	var d time.Duration
+	if i := strings.Index(s, "D"); i >= 0 {
+		days, err := strconv.Atoi(s[:i])
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d = time.Duration(days) * 24 * time.Hour
+		s = s[i+1:]
+	}
+	var h, m, sec int
+	if n, err := fmt.Sscanf(s, "%d:%d:%d", &h, &m, &sec); err != nil || n != 3 || h < 0 || m < 0 || m > 59 || sec < 0 || sec > 59 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d + time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second, nil
+}
+
+func channelID(id epgstation.ChannelId) *typedValue {
+	return &typedValue{Type: channelIDType, Value: strconv.Itoa(int(id))}
+}
+
+func reserveDuration(reserve epgstation.ReserveItem) string {
+	return formatDuration(time.Duration(reserve.EndAt-reserve.StartAt) * time.Millisecond)
+}
+
+// newRecordTask makes the record task of a reserve.
+func newRecordTask(reserve epgstation.ReserveItem, now time.Time) *RecordTask {
+	task := &RecordTask{
+		ID:                     fmt.Sprintf("task%d", reserve.Id),
+		Title:                  reserve.Name,
+		Class:                  classRecordTask,
+		RecordScheduleID:       scheduleIDOf(reserve),
+		ScheduledChannelID:     channelID(reserve.ChannelId),
+		ScheduledStartDateTime: formatDateTime(reserve.StartAt),
+		ScheduledDuration:      reserveDuration(reserve),
+		TaskState:              "IDLE.READY",
+		RecordDestination:      &destination,
+	}
+	if reserve.ProgramId != nil {
+		task.ScheduledProgramCode = &typedValue{Type: programCodeType, Value: strconv.Itoa(int(*reserve.ProgramId))}
+	}
+	nowMS := epgstation.UnixtimeMS(now.UnixNano() / int64(time.Millisecond))
+	switch {
+	case reserve.IsSkip || reserve.IsConflict || reserve.IsOverlap:
+		task.TaskState = "IDLE.NOTREADY"
+	case reserve.StartAt <= nowMS && nowMS < reserve.EndAt:
+		task.TaskState = "ACTIVE.RECORDING"
+	}
+	return task
+}
+
+// newManualSchedule makes the record schedule of a reserve made by hand
+// rather than by a rule.
+func newManualSchedule(reserve epgstation.ReserveItem) *RecordSchedule {
+	schedule := &RecordSchedule{
+		ID:                     scheduleIDOf(reserve),
+		Title:                  reserve.Name,
+		Class:                  classManual,
+		ScheduledChannelID:     channelID(reserve.ChannelId),
+		ScheduledStartDateTime: formatDateTime(reserve.StartAt),
+		ScheduledDuration:      reserveDuration(reserve),
+		ScheduleState:          "OPERATIONAL",
+		CurrentRecordTaskCount: 1,
+		RecordDestination:      &destination,
+	}
+	if reserve.ProgramId != nil && !reserve.IsTimeSpecified {
+		schedule.Class = classProgramCode
+		schedule.ScheduledProgramCode = &typedValue{Type: programCodeType, Value: strconv.Itoa(int(*reserve.ProgramId))}
+	}
+	return schedule
+}
+
+// newRuleSchedule makes the record schedule of a keyword rule.
+func newRuleSchedule(rule epgstation.RuleKeywordItem, enabled bool, taskCount int) *RecordSchedule {
+	schedule := &RecordSchedule{
+		ID:                     fmt.Sprintf("rule%d", rule.Id),
+		Title:                  rule.Keyword,
+		Class:                  classContentName,
+		MatchingName:           &typedValue{Type: "PROGRAM", Value: rule.Keyword},
+		ScheduleState:          "OPERATIONAL",
+		CurrentRecordTaskCount: taskCount,
+		RecordDestination:      &destination,
+	}
+	if !enabled {
+		schedule.ScheduleState = "DISABLED"
+	}
+	return schedule
+}
+
+// scheduleIDOf returns the ID of the record schedule a reserve belongs to.
+func scheduleIDOf(reserve epgstation.ReserveItem) string {
+	if reserve.RuleId != nil {
+		return fmt.Sprintf("rule%d", *reserve.RuleId)
+	}
+	return fmt.Sprintf("reserve%d", reserve.Id)
+}
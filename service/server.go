@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io"
@@ -9,12 +10,18 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"text/template"
 	"time"
 
 	"go-upnp-playground/bufferpool"
 	"go-upnp-playground/epgstation"
 	"go-upnp-playground/service/contentdirectory"
+	"go-upnp-playground/service/epgstationsource"
+	"go-upnp-playground/service/filesource"
+	"go-upnp-playground/service/mirakurunsource"
+	"go-upnp-playground/service/playto"
+	"go-upnp-playground/service/scheduledrecording"
 	"go-upnp-playground/soap"
 
 	"github.com/google/uuid"
@@ -22,6 +29,14 @@ import (
 
 var URLBase string
 
+// UseEPGStation serves the recordings of EPGStation, and its schedules with
+// the ScheduledRecording service. MirakurunURL, if set, serves the live
+// channels of Mirakurun, and MediaDirs the files under some directories. They
+// must be set before Setup.
+var UseEPGStation = true
+var MirakurunURL string
+var MediaDirs []string
+
 func serveXMLFileHandler(tmplFile string, vars map[string]interface{}) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/xml")
@@ -46,15 +61,39 @@ func serveXMLFileHandler(tmplFile string, vars map[string]interface{}) http.Hand
 	}
 }
 
-func serviceContentDirectoryControlHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
-	w.Header().Set("Server", "Linux/i686 UPnP/1.0 go-upnp-playground/0.0.1")
-	buf := bufferpool.NewBytesBuffer()
-	defer bufferpool.PutBytesBuffer(buf)
-	buf.WriteString(xml.Header)
-	buf.Write(soap.HandleAction(r))
-	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
-	w.Write(buf.Bytes())
+// deviceDescriptionHandler serves the device description. Xbox consoles only
+// list media servers whose modelName says they are Windows Media Connect
+// compatible.
+func deviceDescriptionHandler(vars map[string]interface{}) http.HandlerFunc {
+	xboxVars := map[string]interface{}{"modelName": "Windows Media Connect compatible (go-upnp-playground)"}
+	for k, v := range vars {
+		xboxVars[k] = v
+	}
+	vars["modelName"] = "go-upnp-playground"
+	defaultHandler := serveXMLFileHandler("tmpl/device.xml", vars)
+	xboxHandler := serveXMLFileHandler("tmpl/device.xml", xboxVars)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.UserAgent(), "Xbox") {
+			xboxHandler(w, r)
+		} else {
+			defaultHandler(w, r)
+		}
+	}
+}
+
+func serviceControlHandler(service *soap.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		w.Header().Set("Server", "Linux/i686 UPnP/1.0 go-upnp-playground/0.0.1")
+		buf := bufferpool.NewBytesBuffer()
+		defer bufferpool.PutBytesBuffer(buf)
+		status, res := service.HandleAction(r)
+		buf.WriteString(xml.Header)
+		buf.Write(res)
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.WriteHeader(status)
+		w.Write(buf.Bytes())
+	}
 }
 
 func parseTimeSeekHeader(header string) (time.Duration, string) {
@@ -66,37 +105,17 @@ func parseTimeSeekHeader(header string) (time.Duration, string) {
 }
 
 func recordedVideoStreamHandler(w http.ResponseWriter, r *http.Request) {
-	videoFileId := r.URL.Query().Get("videoFileId")
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/videos/%s", epgstation.ServerAPIRoot, videoFileId), nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	for k, vs := range r.Header {
-		req.Header.Set(k, vs[0])
-	}
+	resourceID := r.URL.Query().Get("videoFileId")
 	timeSeekReqHeader := r.Header.Get("Timeseekrange.dlna.org")
-	if timeSeekReqHeader != "" {
+	resource := contentdirectory.GetResource(resourceID)
+	if timeSeekReqHeader != "" && resource != nil && resource.DurationNS > 0 {
 		startDuration, startStr := parseTimeSeekHeader(timeSeekReqHeader)
-		resource := contentdirectory.GetObject(videoFileId).(*contentdirectory.Res)
 		elapsedRatio := float64(startDuration) / float64(resource.DurationNS)
 		startByte := int(elapsedRatio * float64(resource.Size))
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", startByte, resource.Size-1))
+		r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", startByte, resource.Size-1))
 		w.Header().Set("Timeseekrange.dlna.org", fmt.Sprintf("npt=%s-%s/%s", startStr, resource.Duration, resource.Duration))
 	}
-	client := new(http.Client)
-	res, err := client.Do(req)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer res.Body.Close()
-	for k, vs := range res.Header {
-		if k == "Content-Type" && vs[0] == "video/mp2t" {
-			vs[0] = "video/mpeg"
-		}
-		w.Header().Set(k, vs[0])
-	}
-	w.WriteHeader(res.StatusCode)
-	io.Copy(w, res.Body)
+	contentdirectory.Source.ServeResource(w, r, resourceID)
 }
 
 // A Server defines parameters for running an HTTPU server.
@@ -120,23 +139,56 @@ func (s *Server) Listen() {
 }
 
 func (s *Server) Setup() {
-	epgstation.Setup(net.TCPAddr{
-		IP:   s.hostIP,
-		Port: 8888,
-	})
+	var sources []contentdirectory.ContentSource
+	if UseEPGStation {
+		epgstation.Setup(net.TCPAddr{
+			IP:   s.hostIP,
+			Port: 8888,
+		})
+		sources = append(sources, epgstationsource.New())
+	}
+	if MirakurunURL != "" {
+		sources = append(sources, mirakurunsource.New(MirakurunURL))
+	}
+	if len(MediaDirs) > 0 {
+		sources = append(sources, filesource.New(MediaDirs))
+	}
+	if len(sources) == 0 {
+		log.Fatal("no content source")
+	}
+	contentdirectory.Source = contentdirectory.CombineSources(sources...)
+	setupEvents()
 	contentdirectory.Setup(URLBase)
+	go contentdirectory.RunSync(context.Background())
 
-	http.HandleFunc("/", serveXMLFileHandler("tmpl/device.xml", map[string]interface{}{
+	http.HandleFunc("/", deviceDescriptionHandler(map[string]interface{}{
 		"uuid":    s.deviceUUID,
 		"URLBase": URLBase,
+		// ScheduledRecording is only there for EPGStation.
+		"scheduledRecording": UseEPGStation,
 	}))
-	http.HandleFunc("/ContentDirectory/scpd.xml", serveXMLFileHandler("file/ContentDirectory1.xml", nil))
+	http.HandleFunc("/ContentDirectory/scpd.xml", serveXMLFileHandler("file/ContentDirectory3.xml", nil))
 	http.HandleFunc("/ConnectionManager/scpd.xml", serveXMLFileHandler("file/ConnectionManager1.xml", nil))
-
-	http.HandleFunc("/ContentDirectory/control.xml", serviceContentDirectoryControlHandler)
-	http.HandleFunc("/ConnectionManager/control.xml", serviceContentDirectoryControlHandler)
+	http.HandleFunc("/X_MS_MediaReceiverRegistrar/scpd.xml", serveXMLFileHandler("file/X_MS_MediaReceiverRegistrar1.xml", nil))
+
+	http.HandleFunc("/ContentDirectory/control.xml", serviceControlHandler(soap.NewContentDirectoryService(soap.Action{})))
+	http.HandleFunc("/ConnectionManager/control.xml", serviceControlHandler(soap.NewConnectionManagerService(soap.ConnectionManagerAction{})))
+	http.HandleFunc("/X_MS_MediaReceiverRegistrar/control.xml", serviceControlHandler(soap.NewMediaReceiverRegistrarService(soap.MediaReceiverRegistrarAction{})))
+
+	http.Handle("/ContentDirectory/event.xml", contentDirectoryEvents)
+	http.Handle("/ConnectionManager/event.xml", connectionManagerEvents)
+	http.Handle("/X_MS_MediaReceiverRegistrar/event.xml", mediaReceiverRegistrarEvents)
+	if UseEPGStation {
+		http.HandleFunc("/ScheduledRecording/scpd.xml", serveXMLFileHandler("file/ScheduledRecording1.xml", nil))
+		http.HandleFunc("/ScheduledRecording/control.xml", serviceControlHandler(soap.NewScheduledRecordingService(soap.ScheduledRecordingAction{})))
+		http.Handle("/ScheduledRecording/event.xml", scheduledRecordingEvents)
+		go scheduledrecording.RunPoll(context.Background())
+	}
 
 	http.HandleFunc("/videos/recorded", recordedVideoStreamHandler)
+
+	http.Handle("/api/renderers", playto.Handler)
+	http.Handle("/api/renderers/", playto.Handler)
 }
 
 func (s *Server) Serve() error {
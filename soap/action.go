@@ -1,38 +1,214 @@
 package soap
 
 import (
+	"context"
+	"encoding/xml"
+	"errors"
 	"go-upnp-playground/service/contentdirectory"
-	"log"
+	"strings"
 )
 
+var (
+	ErrNoSuchObject           = &UPnPError{701, "No such object"}
+	ErrInvalidCurrentTagValue = &UPnPError{702, "Invalid currentTagValue"}
+	ErrInvalidNewTagValue     = &UPnPError{703, "Invalid newTagValue"}
+	ErrRequiredTag            = &UPnPError{704, "Required tag"}
+	ErrReadOnlyTag            = &UPnPError{705, "Read only tag"}
+	ErrParameterMismatch      = &UPnPError{706, "Parameter Mismatch"}
+	ErrInvalidSearchCriteria  = &UPnPError{708, "Unsupported or invalid search criteria"}
+	ErrInvalidSortCriteria    = &UPnPError{709, "Unsupported or invalid sort criteria"}
+	ErrNoSuchContainer        = &UPnPError{710, "No such container"}
+	ErrRestrictedObject       = &UPnPError{711, "Restricted object"}
+	ErrRestrictedParentObject = &UPnPError{713, "Restricted parent object"}
+)
+
+// contentDirectoryError converts errors of the contentdirectory package to
+// the matching UPnP error.
+func contentDirectoryError(err error) error {
+	var searchErr *contentdirectory.SearchCriteriaError
+	var sortErr *contentdirectory.SortCriteriaError
+	switch {
+	case errors.Is(err, contentdirectory.ErrNoSuchObject):
+		return ErrNoSuchObject
+	case errors.Is(err, contentdirectory.ErrInvalidCurrentTagValue):
+		return ErrInvalidCurrentTagValue
+	case errors.Is(err, contentdirectory.ErrInvalidNewTagValue):
+		return ErrInvalidNewTagValue
+	case errors.Is(err, contentdirectory.ErrRequiredTag):
+		return ErrRequiredTag
+	case errors.Is(err, contentdirectory.ErrReadOnlyTag):
+		return ErrReadOnlyTag
+	case errors.Is(err, contentdirectory.ErrParameterMismatch):
+		return ErrParameterMismatch
+	case errors.Is(err, contentdirectory.ErrNoSuchContainer):
+		return ErrNoSuchContainer
+	case errors.Is(err, contentdirectory.ErrRestrictedObject):
+		return ErrRestrictedObject
+	case errors.Is(err, contentdirectory.ErrRestrictedParentObject):
+		return ErrRestrictedParentObject
+	case errors.As(err, &searchErr):
+		return &UPnPError{ErrInvalidSearchCriteria.Code, searchErr.Error()}
+	case errors.As(err, &sortErr):
+		return &UPnPError{ErrInvalidSortCriteria.Code, sortErr.Error()}
+	}
+	return err
+}
+
+// xboxContainerIDs maps the Windows Media Connect container IDs which Xbox
+// consoles and Windows Media Player browse and search in onto our tree.
+var xboxContainerIDs = map[string]string{
+	"2": "01", "8": "01", // video, all video
+	"9":  "02", // video genres
+	"15": "0",  // video folders
+}
+
+// xboxEmptyContainers are the Windows Media Connect music and picture
+// containers, which are always empty.
+var xboxEmptyContainers = map[string]struct{ parentID, title string }{
+	"1": {"0", "Music"}, "4": {"1", "All Music"}, "5": {"1", "Genre"}, "6": {"1", "Artist"},
+	"7": {"1", "Album"}, "F": {"1", "Playlists"}, "14": {"1", "Folders"},
+	"3": {"0", "Pictures"}, "B": {"3", "All Pictures"}, "16": {"3", "Folders"},
+}
+
 type Action struct {
+	UnimplementedContentDirectory
+	// ctx is the context of the request, if any.
+	ctx context.Context
+	// version is the version of ContentDirectory the client invoked.
+	version int
+}
+
+// ForRequest returns the Action answering a request of a client of a version
+// of ContentDirectory.
+func (a Action) ForRequest(ctx context.Context, version int) ContentDirectory {
+	a.ctx = ctx
+	a.version = version
+	return a
+}
+
+// context returns the context of the request.
+func (a Action) context() context.Context {
+	if a.ctx == nil {
+		return context.Background()
+	}
+	return a.ctx
+}
+
+// xboxContainerID returns the ID in our tree of a container the client asked
+// for, or "" for an always empty one. IDs of our own take precedence.
+func xboxContainerID(id string) string {
+	if _, ok := contentdirectory.GetObject(id).(*contentdirectory.Container); ok {
+		return id
+	}
+	if mapped, ok := xboxContainerIDs[id]; ok {
+		return mapped
+	}
+	if _, ok := xboxEmptyContainers[id]; ok {
+		return ""
+	}
+	return id
+}
+
+// emptyResult is the result of browsing or searching an always empty
+// container.
+func (a Action) emptyResult() (string, uint32, uint32, uint32, error) {
+	updateID, _ := a.GetSystemUpdateID()
+	data, _ := xml.Marshal(contentdirectory.DIDLLite{})
+	return string(data), 0, 0, updateID, nil
 }
 
-func (a Action) Browse(ObjectID string, BrowseFlag string, Filter string, StartingIndex int, RequestedCount int, SortCriteria string) (string, int, int, int) {
-	switch BrowseFlag {
-	case "BrowseMetadata":
-		return contentdirectory.MarshalMetadata(ObjectID), 1, 1, a.GetSystemUpdateID()
-	case "BrowseDirectChildren":
-		container := contentdirectory.GetObject(ObjectID).(*contentdirectory.Container)
-		return contentdirectory.MarshalDirectChildren(ObjectID, StartingIndex, RequestedCount), container.ChildCount - StartingIndex, container.ChildCount, a.GetSystemUpdateID()
-	default:
-		log.Printf("invalid BrowseFlag: %s", BrowseFlag)
-		// Result, NumberReturned, TotalMatches, UpdateID
-		return "", 0, 0, a.GetSystemUpdateID()
+// emptyMetadata is the result of browsing the metadata of the always empty
+// container id.
+func (a Action) emptyMetadata(id string, Filter string) (string, uint32, uint32, uint32, error) {
+	updateID, _ := a.GetSystemUpdateID()
+	container := xboxEmptyContainers[id]
+	result := contentdirectory.MarshalContainer(&contentdirectory.Container{
+		Id:         contentdirectory.ObjectID(id),
+		ParentID:   contentdirectory.ObjectID(container.parentID),
+		Title:      container.title,
+		Class:      "object.container",
+		Restricted: "true",
+	}, Filter, a.version)
+	return result, 1, 1, updateID, nil
+}
+
+func (a Action) Browse(ObjectID string, BrowseFlag string, Filter string, StartingIndex uint32, RequestedCount uint32, SortCriteria string) (string, uint32, uint32, uint32, error) {
+	id := xboxContainerID(ObjectID)
+	if id == "" {
+		if BrowseFlag == "BrowseMetadata" {
+			return a.emptyMetadata(ObjectID, Filter)
+		}
+		return a.emptyResult()
+	}
+	result, returned, total, updateID, err := contentdirectory.Browse(id, BrowseFlag == "BrowseMetadata", Filter, SortCriteria, int(StartingIndex), int(RequestedCount), a.version)
+	if err != nil {
+		return "", 0, 0, 0, contentDirectoryError(err)
+	}
+	// Result, NumberReturned, TotalMatches, UpdateID
+	return result, uint32(returned), uint32(total), uint32(updateID), nil
+}
+
+func (a Action) Search(ContainerID string, SearchCriteria string, Filter string, StartingIndex uint32, RequestedCount uint32, SortCriteria string) (string, uint32, uint32, uint32, error) {
+	ContainerID = xboxContainerID(ContainerID)
+	if ContainerID == "" {
+		return a.emptyResult()
 	}
+	result, returned, total, updateID, err := contentdirectory.Search(a.context(), ContainerID, SearchCriteria, Filter, SortCriteria, int(StartingIndex), int(RequestedCount), a.version)
+	if err != nil {
+		return "", 0, 0, 0, contentDirectoryError(err)
+	}
+	// Result, NumberReturned, TotalMatches, UpdateID
+	return result, uint32(returned), uint32(total), uint32(updateID), nil
+}
+
+func (a Action) CreateReference(ContainerID string, ObjectID string) (string, error) {
+	// NewID
+	newID, err := contentdirectory.CreateReference(ContainerID, ObjectID)
+	return newID, contentDirectoryError(err)
+}
+
+func (a Action) DestroyObject(ObjectID string) error {
+	return contentDirectoryError(contentdirectory.DestroyObject(ObjectID))
 }
 
-func (a Action) GetSystemUpdateID() int {
+func (a Action) UpdateObject(ObjectID string, CurrentTagValue string, NewTagValue string) error {
+	return contentDirectoryError(contentdirectory.UpdateObject(ObjectID, CurrentTagValue, NewTagValue))
+}
+
+func (a Action) GetSystemUpdateID() (uint32, error) {
 	// SystemUpdateID
-	return contentdirectory.GetRecordedTotal()
+	return uint32(contentdirectory.GetSystemUpdateID()), nil
 }
 
-func (a Action) GetSearchCapabilities() string {
+func (a Action) GetServiceResetToken() (string, error) {
+	// ResetToken
+	return contentdirectory.GetServiceResetToken(), nil
+}
+
+func (a Action) GetSearchCapabilities() (string, error) {
 	// SearchCapabilities
-	return ""
+	return strings.Join(contentdirectory.Capabilities(contentdirectory.SearchCapabilities, a.version), ","), nil
 }
 
-func (a Action) GetSortCapabilities() string {
+func (a Action) GetSortCapabilities() (string, error) {
 	// SortCapabilities
-	return ""
+	return strings.Join(contentdirectory.Capabilities(contentdirectory.SortCapabilities, a.version), ","), nil
+}
+
+func (a Action) GetFeatureList() (string, error) {
+	// FeatureList
+	return contentdirectory.MarshalFeatureList(), nil
+}
+
+// X_GetFeatureList is the Samsung extension which TVs use to find the video
+// container.
+func (a Action) X_GetFeatureList() (string, error) {
+	// FeatureList
+	return contentdirectory.MarshalFeatureList(), nil
+}
+
+// X_SetBookmark is the Samsung extension which TVs call when playback stops,
+// so that it can resume from PosSecond next time.
+func (a Action) X_SetBookmark(CategoryType uint32, RID uint32, ObjectID string, PosSecond uint32) error {
+	return contentDirectoryError(contentdirectory.SetBookmark(ObjectID, int(PosSecond)))
 }
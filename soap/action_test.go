@@ -0,0 +1,79 @@
+package soap
+
+import (
+	"context"
+	"errors"
+	"go-upnp-playground/service/contentdirectory"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSource has a single recording.
+type fakeSource struct{}
+
+func (fakeSource) Contents(ctx context.Context) ([]contentdirectory.Content, error) {
+	return []contentdirectory.Content{{
+		ID:        "1",
+		Title:     "番組1",
+		Start:     time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:       time.Date(2021, 1, 1, 1, 0, 0, 0, time.UTC),
+		Resources: []contentdirectory.Resource{{ID: "1", Filename: "1.ts", Size: 1, Duration: time.Hour}},
+	}}, nil
+}
+
+func (fakeSource) Probe(ctx context.Context, resource contentdirectory.Resource) (contentdirectory.Probe, error) {
+	return contentdirectory.Probe{}, nil
+}
+
+func (fakeSource) ServeResource(w http.ResponseWriter, r *http.Request, resourceID string) {}
+
+func (fakeSource) Delete(ctx context.Context, contentID string) error {
+	return contentdirectory.ErrRestrictedObject
+}
+
+func TestXboxContainers(t *testing.T) {
+	contentdirectory.StateDir = t.TempDir()
+	contentdirectory.Source = fakeSource{}
+	contentdirectory.Setup("http://localhost/")
+	a := Action{version: contentdirectory.Version}
+
+	// Video containers are ours.
+	for _, id := range []string{"2", "8", "15"} {
+		result, returned, total, _, err := a.Browse(id, "BrowseDirectChildren", "*", 0, 0, "")
+		if err != nil {
+			t.Errorf("browsing %s: %v", id, err)
+			continue
+		}
+		if id != "15" && (returned != 1 || total != 1 || !strings.Contains(result, "番組1")) {
+			t.Errorf("browsing %s: got %d of %d: %s", id, returned, total, result)
+		}
+	}
+	result, _, _, _, err := a.Browse("8", "BrowseMetadata", "*", 0, 0, "")
+	if err != nil || !strings.Contains(result, `id="01"`) {
+		t.Errorf("metadata of 8: %s, %v", result, err)
+	}
+
+	// Music and picture containers are there, but empty.
+	for _, id := range []string{"1", "7", "3", "B"} {
+		result, returned, total, _, err := a.Browse(id, "BrowseDirectChildren", "*", 0, 0, "")
+		if err != nil || returned != 0 || total != 0 || strings.Contains(result, "<container") || strings.Contains(result, "<item") {
+			t.Errorf("browsing %s: got %d of %d: %s, %v", id, returned, total, result, err)
+		}
+		result, returned, total, _, err = a.Browse(id, "BrowseMetadata", "*", 0, 0, "")
+		if err != nil || returned != 1 || total != 1 || !strings.Contains(result, "<container") || !strings.Contains(result, `id="`+id+`"`) || !strings.Contains(result, `childCount="0"`) {
+			t.Errorf("metadata of %s: got %d of %d: %s, %v", id, returned, total, result, err)
+		}
+		result, returned, _, _, err = a.Search(id, "*", "*", 0, 0, "")
+		if err != nil || returned != 0 {
+			t.Errorf("searching %s: got %d: %s, %v", id, returned, result, err)
+		}
+	}
+
+	// Other IDs are not there.
+	var upnpErr *UPnPError
+	if _, _, _, _, err := a.Browse("X", "BrowseMetadata", "*", 0, 0, ""); !errors.As(err, &upnpErr) || upnpErr.Code != ErrNoSuchObject.Code {
+		t.Errorf("metadata of X: want error 701, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package soap
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Args holds the in arguments of an action invocation by name.
+type Args map[string]string
+
+// Boolean is a UPnP boolean. It accepts every spelling allowed by UPnP and is
+// always sent as 0 or 1.
+type Boolean bool
+
+func (b Boolean) MarshalText() ([]byte, error) {
+	if b {
+		return []byte("1"), nil
+	}
+	return []byte("0"), nil
+}
+
+func (b *Boolean) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "1", "true", "yes":
+		*b = true
+	case "0", "false", "no":
+		*b = false
+	default:
+		return fmt.Errorf("not a boolean: %q", text)
+	}
+	return nil
+}
+
+func (a Args) value(name string) (string, error) {
+	v, ok := a[name]
+	if !ok {
+		return "", invalidArgs("missing argument %s", name)
+	}
+	return v, nil
+}
+
+func (a Args) String(name string, allowed []string) (string, error) {
+	v, err := a.value(name)
+	if err != nil || allowed == nil {
+		return v, err
+	}
+	for _, s := range allowed {
+		if v == s {
+			return v, nil
+		}
+	}
+	return "", invalidArgs("argument %s has value %q not in allowed value list", name, v)
+}
+
+func (a Args) Boolean(name string) (Boolean, error) {
+	v, err := a.value(name)
+	if err != nil {
+		return false, err
+	}
+	switch v {
+	case "1", "true", "yes":
+		return true, nil
+	case "0", "false", "no":
+		return false, nil
+	}
+	return false, invalidArgs("argument %s is not a boolean: %q", name, v)
+}
+
+func (a Args) uint(name string, bitSize int) (uint64, error) {
+	v, err := a.value(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(v, 10, bitSize)
+	if err != nil {
+		return 0, invalidArgs("argument %s is not a ui%d: %q", name, bitSize/8, v)
+	}
+	return n, nil
+}
+
+func (a Args) int(name string, bitSize int) (int64, error) {
+	v, err := a.value(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(v, 10, bitSize)
+	if err != nil {
+		return 0, invalidArgs("argument %s is not an i%d: %q", name, bitSize/8, v)
+	}
+	return n, nil
+}
+
+func (a Args) UI1(name string) (uint8, error) {
+	n, err := a.uint(name, 8)
+	return uint8(n), err
+}
+
+func (a Args) UI2(name string) (uint16, error) {
+	n, err := a.uint(name, 16)
+	return uint16(n), err
+}
+
+func (a Args) UI4(name string) (uint32, error) {
+	n, err := a.uint(name, 32)
+	return uint32(n), err
+}
+
+func (a Args) I1(name string) (int8, error) {
+	n, err := a.int(name, 8)
+	return int8(n), err
+}
+
+func (a Args) I2(name string) (int16, error) {
+	n, err := a.int(name, 16)
+	return int16(n), err
+}
+
+func (a Args) I4(name string) (int32, error) {
+	n, err := a.int(name, 32)
+	return int32(n), err
+}
@@ -0,0 +1,303 @@
+package soap
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+const (
+	userAgent = "Linux/i686 UPnP/1.0 go-upnp-playground/0.0.1"
+	// maxResponseSize bounds what we read from a remote device. Browse
+	// results of large containers are the longest responses.
+	maxResponseSize = 8 * 1024 * 1024
+)
+
+// DeviceDescription is the description document of a remote root device.
+type DeviceDescription struct {
+	URLBase string `xml:"URLBase"`
+	Device  Device `xml:"device"`
+
+	// location is where the description was fetched from, against which
+	// relative URLs are resolved when URLBase is missing.
+	location *url.URL
+}
+
+type Device struct {
+	DeviceType   string               `xml:"deviceType"`
+	FriendlyName string               `xml:"friendlyName"`
+	Manufacturer string               `xml:"manufacturer"`
+	ModelName    string               `xml:"modelName"`
+	UDN          string               `xml:"UDN"`
+	Services     []ServiceDescription `xml:"serviceList>service"`
+	Devices      []Device             `xml:"deviceList>device"`
+}
+
+type ServiceDescription struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceID   string `xml:"serviceId"`
+	SCPDURL     string `xml:"SCPDURL"`
+	ControlURL  string `xml:"controlURL"`
+	EventSubURL string `xml:"eventSubURL"`
+}
+
+// SCPD is the service description of a remote service.
+type SCPD struct {
+	Actions []struct {
+		Name      string `xml:"name"`
+		Arguments []struct {
+			Name                 string `xml:"name"`
+			Direction            string `xml:"direction"`
+			RelatedStateVariable string `xml:"relatedStateVariable"`
+		} `xml:"argumentList>argument"`
+	} `xml:"actionList>action"`
+	StateVariables []struct {
+		SendEvents    string   `xml:"sendEvents,attr"`
+		Name          string   `xml:"name"`
+		DataType      string   `xml:"dataType"`
+		AllowedValues []string `xml:"allowedValueList>allowedValue"`
+	} `xml:"serviceStateTable>stateVariable"`
+}
+
+// HasAction reports whether the service implements the action.
+func (s *SCPD) HasAction(name string) bool {
+	for _, action := range s.Actions {
+		if action.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func get(ctx context.Context, client *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	data, err := readResponse(res.Body)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(data, v)
+}
+
+func readResponse(body io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxResponseSize {
+		return nil, fmt.Errorf("response larger than %d bytes", maxResponseSize)
+	}
+	return data, nil
+}
+
+// FetchDevice reads the device description at location, the LOCATION of an
+// SSDP response or advertisement.
+func FetchDevice(ctx context.Context, location string) (*DeviceDescription, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	var desc DeviceDescription
+	if err := get(ctx, http.DefaultClient, location, &desc); err != nil {
+		return nil, err
+	}
+	desc.location = u
+	return &desc, nil
+}
+
+func (d *DeviceDescription) resolve(ref string) (string, error) {
+	base := d.location
+	if d.URLBase != "" {
+		u, err := url.Parse(d.URLBase)
+		if err != nil {
+			return "", err
+		}
+		base = u
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if base == nil {
+		return u.String(), nil
+	}
+	return base.ResolveReference(u).String(), nil
+}
+
+func (d *Device) findService(serviceType string) (*ServiceDescription, bool) {
+	name, version, ok := splitVersion(serviceType)
+	if !ok {
+		return nil, false
+	}
+	for i := range d.Services {
+		n, v, ok := splitVersion(d.Services[i].ServiceType)
+		if ok && n == name && v >= version {
+			return &d.Services[i], true
+		}
+	}
+	for i := range d.Devices {
+		if s, ok := d.Devices[i].findService(serviceType); ok {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Client returns a client for a service of the device or of its embedded
+// devices. A later version of serviceType is accepted, as it is backward
+// compatible.
+func (d *DeviceDescription) Client(serviceType string) (*Client, error) {
+	s, ok := d.Device.findService(serviceType)
+	if !ok {
+		return nil, fmt.Errorf("%s has no %s service", d.Device.FriendlyName, serviceType)
+	}
+	controlURL, err := d.resolve(s.ControlURL)
+	if err != nil {
+		return nil, err
+	}
+	scpdURL, err := d.resolve(s.SCPDURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		ServiceType: s.ServiceType,
+		ControlURL:  controlURL,
+		SCPDURL:     scpdURL,
+		HTTPClient:  http.DefaultClient,
+	}, nil
+}
+
+// A Client invokes actions of a service of a remote device.
+type Client struct {
+	// ServiceType is the service type the device advertises, which actions
+	// are invoked in.
+	ServiceType string
+	ControlURL  string
+	SCPDURL     string
+	HTTPClient  *http.Client
+}
+
+// SCPD fetches the service description.
+func (c *Client) SCPD(ctx context.Context) (*SCPD, error) {
+	var scpd SCPD
+	if err := get(ctx, c.HTTPClient, c.SCPDURL, &scpd); err != nil {
+		return nil, err
+	}
+	return &scpd, nil
+}
+
+// actionElement marshals the action element of an invocation with the u
+// prefix most UPnP stacks expect, leaving the arguments unqualified.
+type actionElement struct {
+	serviceType string
+	name        string
+	args        interface{}
+}
+
+func (a actionElement) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	start := xml.StartElement{
+		Name: xml.Name{Local: "u:" + a.name},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns:u"}, Value: a.serviceType}},
+	}
+	if a.args == nil {
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		return e.EncodeToken(start.End())
+	}
+	return e.EncodeElement(a.args, start)
+}
+
+// faultDetail decodes a SOAP fault, whichever namespaces the device used.
+type faultDetail struct {
+	FaultString string `xml:"faultstring"`
+	Detail      struct {
+		UPnPError struct {
+			ErrorCode        int    `xml:"errorCode"`
+			ErrorDescription string `xml:"errorDescription"`
+		}
+	} `xml:"detail"`
+}
+
+// decodeBody decodes the element in the Body of a SOAP envelope into v.
+func decodeBody(data []byte, v interface{}) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	inBody := false
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return fmt.Errorf("malformed SOAP envelope: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if inBody {
+			return d.DecodeElement(v, &start)
+		}
+		inBody = start.Name.Space == soapEnvelopeNamespace && start.Name.Local == "Body"
+	}
+}
+
+// Invoke invokes an action. args holds the in arguments, such as a
+// *ContentDirectoryBrowseRequest, and may be nil for actions without any. The
+// out arguments are decoded into res, such as a
+// *ContentDirectoryBrowseResponse, unless it is nil. A UPnP error returned by
+// the device is a *UPnPError.
+func (c *Client) Invoke(ctx context.Context, action string, args interface{}, res interface{}) error {
+	body, err := xml.Marshal(newEnvelope(actionElement{c.ServiceType, action, args}))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.ControlURL, bytes.NewReader(append([]byte(xml.Header), body...)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("User-Agent", userAgent)
+	// Putting headers in here avoids them being title-cased.
+	req.Header["SOAPACTION"] = []string{fmt.Sprintf(`"%s#%s"`, c.ServiceType, action)}
+	httpRes, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close()
+	data, err := readResponse(httpRes.Body)
+	if err != nil {
+		return err
+	}
+
+	switch httpRes.StatusCode {
+	case http.StatusOK:
+		if res == nil {
+			return nil
+		}
+		return decodeBody(data, res)
+	case http.StatusInternalServerError:
+		var fault faultDetail
+		if err := decodeBody(data, &fault); err != nil {
+			return err
+		}
+		if fault.Detail.UPnPError.ErrorCode == 0 {
+			return fmt.Errorf("%s: SOAP fault %s", action, fault.FaultString)
+		}
+		return &UPnPError{fault.Detail.UPnPError.ErrorCode, fault.Detail.UPnPError.ErrorDescription}
+	}
+	return fmt.Errorf("%s: %s", action, httpRes.Status)
+}
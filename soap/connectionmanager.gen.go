@@ -0,0 +1,127 @@
+// Code generated by scpdgen from ../file/ConnectionManager1.xml. DO NOT EDIT.
+
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+)
+
+const ConnectionManagerServiceType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+
+var connectionManagerAllowedConnectionStatus = []string{"OK", "ContentFormatMismatch", "InsufficientBandwidth", "UnreliableChannel", "Unknown"}
+
+var connectionManagerAllowedDirection = []string{"Input", "Output"}
+
+// ConnectionManager is implemented by the urn:schemas-upnp-org:service:ConnectionManager:1 service.
+type ConnectionManager interface {
+	GetCurrentConnectionIDs() (string, error)
+	GetCurrentConnectionInfo(ConnectionID int32) (int32, int32, string, string, int32, string, string, error)
+	GetProtocolInfo() (string, string, error)
+}
+
+// UnimplementedConnectionManager answers every action with error 602. Embed it in an
+// implementation to provide only the actions it supports.
+type UnimplementedConnectionManager struct{}
+
+func (UnimplementedConnectionManager) GetCurrentConnectionIDs() (string, error) {
+	return "", ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedConnectionManager) GetCurrentConnectionInfo(int32) (int32, int32, string, string, int32, string, string, error) {
+	return 0, 0, "", "", 0, "", "", ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedConnectionManager) GetProtocolInfo() (string, string, error) {
+	return "", "", ErrOptionalActionNotImplemented
+}
+
+type ConnectionManagerGetCurrentConnectionIDsRequest struct {
+	XMLName xml.Name
+}
+
+type ConnectionManagerGetCurrentConnectionIDsResponse struct {
+	XMLName       xml.Name
+	ConnectionIDs string
+}
+
+type ConnectionManagerGetCurrentConnectionInfoRequest struct {
+	XMLName      xml.Name
+	ConnectionID int32
+}
+
+type ConnectionManagerGetCurrentConnectionInfoResponse struct {
+	XMLName               xml.Name
+	RcsID                 int32
+	AVTransportID         int32
+	ProtocolInfo          string
+	PeerConnectionManager string
+	PeerConnectionID      int32
+	Direction             string
+	Status                string
+}
+
+type ConnectionManagerGetProtocolInfoRequest struct {
+	XMLName xml.Name
+}
+
+type ConnectionManagerGetProtocolInfoResponse struct {
+	XMLName xml.Name
+	Source  string
+	Sink    string
+}
+
+// NewConnectionManagerService returns a Service dispatching urn:schemas-upnp-org:service:ConnectionManager:1 actions to impl.
+func NewConnectionManagerService(impl ConnectionManager) *Service {
+	// forRequest lets impl answer each version of the service the way it
+	// was specified, and stop working on requests the client gave up on, if
+	// it tells them apart.
+	forRequest := func(ctx context.Context, serviceType string) ConnectionManager {
+		if v, ok := impl.(interface {
+			ForRequest(ctx context.Context, version int) ConnectionManager
+		}); ok {
+			_, version, _ := splitVersion(serviceType)
+			return v.ForRequest(ctx, version)
+		}
+		return impl
+	}
+	return &Service{
+		Type: ConnectionManagerServiceType,
+		actions: map[string]actionFunc{
+			"GetCurrentConnectionIDs": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var res ConnectionManagerGetCurrentConnectionIDsResponse
+				var err error
+				res.ConnectionIDs, err = forRequest(ctx, serviceType).GetCurrentConnectionIDs()
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetCurrentConnectionIDsResponse"}
+				return &res, nil
+			},
+			"GetCurrentConnectionInfo": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ConnectionManagerGetCurrentConnectionInfoRequest
+				var res ConnectionManagerGetCurrentConnectionInfoResponse
+				var err error
+				if req.ConnectionID, err = args.I4("ConnectionID"); err != nil {
+					return nil, err
+				}
+				res.RcsID, res.AVTransportID, res.ProtocolInfo, res.PeerConnectionManager, res.PeerConnectionID, res.Direction, res.Status, err = forRequest(ctx, serviceType).GetCurrentConnectionInfo(req.ConnectionID)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetCurrentConnectionInfoResponse"}
+				return &res, nil
+			},
+			"GetProtocolInfo": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var res ConnectionManagerGetProtocolInfoResponse
+				var err error
+				res.Source, res.Sink, err = forRequest(ctx, serviceType).GetProtocolInfo()
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetProtocolInfoResponse"}
+				return &res, nil
+			},
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package soap
+
+import "strings"
+
+var ErrInvalidConnectionReference = &UPnPError{706, "Invalid connection reference"}
+
+// sourceProtocolInfo lists the formats served by the content directory.
+var sourceProtocolInfo = []string{
+	"http-get:*:video/mpeg:*",
+	"http-get:*:video/mp4:*",
+	"http-get:*:video/x-matroska:*",
+	"http-get:*:audio/mpeg:*",
+	"http-get:*:audio/mp4:*",
+	"http-get:*:image/jpeg:*",
+	"http-get:*:image/png:*",
+}
+
+// ConnectionManagerAction implements ConnectionManager for a server which
+// does not support PrepareForConnection, so only connection 0 ever exists.
+type ConnectionManagerAction struct {
+	UnimplementedConnectionManager
+}
+
+func (a ConnectionManagerAction) GetProtocolInfo() (string, string, error) {
+	// Source, Sink
+	return strings.Join(sourceProtocolInfo, ","), "", nil
+}
+
+func (a ConnectionManagerAction) GetCurrentConnectionIDs() (string, error) {
+	// ConnectionIDs
+	return "0", nil
+}
+
+func (a ConnectionManagerAction) GetCurrentConnectionInfo(ConnectionID int32) (int32, int32, string, string, int32, string, string, error) {
+	if ConnectionID != 0 {
+		return 0, 0, "", "", 0, "", "", ErrInvalidConnectionReference
+	}
+	// RcsID, AVTransportID, ProtocolInfo, PeerConnectionManager, PeerConnectionID, Direction, Status
+	return -1, -1, "", "", -1, "Output", "OK", nil
+}
@@ -0,0 +1,619 @@
+// Code generated by scpdgen from ../file/ContentDirectory1.xml, ../file/ContentDirectory2.xml, ../file/ContentDirectory3.xml. DO NOT EDIT.
+
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+)
+
+const ContentDirectoryServiceType = "urn:schemas-upnp-org:service:ContentDirectory:3"
+
+var contentDirectoryAllowedBrowseFlag = []string{"BrowseMetadata", "BrowseDirectChildren"}
+
+var contentDirectoryAllowedTransferStatus = []string{"COMPLETED", "ERROR", "IN_PROGRESS", "STOPPED"}
+
+// ContentDirectory is implemented by the urn:schemas-upnp-org:service:ContentDirectory:3 service.
+type ContentDirectory interface {
+	Browse(ObjectID string, BrowseFlag string, Filter string, StartingIndex uint32, RequestedCount uint32, SortCriteria string) (string, uint32, uint32, uint32, error)
+	CreateObject(ContainerID string, Elements string) (string, string, error)
+	CreateReference(ContainerID string, ObjectID string) (string, error)
+	DeleteResource(ResourceURI string) error
+	DestroyObject(ObjectID string) error
+	ExportResource(SourceURI string, DestinationURI string) (uint32, error)
+	GetSearchCapabilities() (string, error)
+	GetSortCapabilities() (string, error)
+	GetServiceResetToken() (string, error)
+	GetSystemUpdateID() (uint32, error)
+	GetTransferProgress(TransferID uint32) (string, string, string, error)
+	ImportResource(SourceURI string, DestinationURI string) (uint32, error)
+	Search(ContainerID string, SearchCriteria string, Filter string, StartingIndex uint32, RequestedCount uint32, SortCriteria string) (string, uint32, uint32, uint32, error)
+	StopTransferResource(TransferID uint32) error
+	UpdateObject(ObjectID string, CurrentTagValue string, NewTagValue string) error
+	GetFeatureList() (string, error)
+	X_GetFeatureList() (string, error)
+	X_SetBookmark(CategoryType uint32, RID uint32, ObjectID string, PosSecond uint32) error
+}
+
+// UnimplementedContentDirectory answers every action with error 602. Embed it in an
+// implementation to provide only the actions it supports.
+type UnimplementedContentDirectory struct{}
+
+func (UnimplementedContentDirectory) Browse(string, string, string, uint32, uint32, string) (string, uint32, uint32, uint32, error) {
+	return "", 0, 0, 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) CreateObject(string, string) (string, string, error) {
+	return "", "", ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) CreateReference(string, string) (string, error) {
+	return "", ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) DeleteResource(string) error {
+	return ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) DestroyObject(string) error {
+	return ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) ExportResource(string, string) (uint32, error) {
+	return 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) GetSearchCapabilities() (string, error) {
+	return "", ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) GetSortCapabilities() (string, error) {
+	return "", ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) GetServiceResetToken() (string, error) {
+	return "", ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) GetSystemUpdateID() (uint32, error) {
+	return 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) GetTransferProgress(uint32) (string, string, string, error) {
+	return "", "", "", ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) ImportResource(string, string) (uint32, error) {
+	return 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) Search(string, string, string, uint32, uint32, string) (string, uint32, uint32, uint32, error) {
+	return "", 0, 0, 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) StopTransferResource(uint32) error {
+	return ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) UpdateObject(string, string, string) error {
+	return ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) GetFeatureList() (string, error) {
+	return "", ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) X_GetFeatureList() (string, error) {
+	return "", ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedContentDirectory) X_SetBookmark(uint32, uint32, string, uint32) error {
+	return ErrOptionalActionNotImplemented
+}
+
+type ContentDirectoryBrowseRequest struct {
+	XMLName        xml.Name
+	ObjectID       string
+	BrowseFlag     string
+	Filter         string
+	StartingIndex  uint32
+	RequestedCount uint32
+	SortCriteria   string
+}
+
+type ContentDirectoryBrowseResponse struct {
+	XMLName        xml.Name
+	Result         string
+	NumberReturned uint32
+	TotalMatches   uint32
+	UpdateID       uint32
+}
+
+type ContentDirectoryCreateObjectRequest struct {
+	XMLName     xml.Name
+	ContainerID string
+	Elements    string
+}
+
+type ContentDirectoryCreateObjectResponse struct {
+	XMLName  xml.Name
+	ObjectID string
+	Result   string
+}
+
+type ContentDirectoryCreateReferenceRequest struct {
+	XMLName     xml.Name
+	ContainerID string
+	ObjectID    string
+}
+
+type ContentDirectoryCreateReferenceResponse struct {
+	XMLName xml.Name
+	NewID   string
+}
+
+type ContentDirectoryDeleteResourceRequest struct {
+	XMLName     xml.Name
+	ResourceURI string
+}
+
+type ContentDirectoryDeleteResourceResponse struct {
+	XMLName xml.Name
+}
+
+type ContentDirectoryDestroyObjectRequest struct {
+	XMLName  xml.Name
+	ObjectID string
+}
+
+type ContentDirectoryDestroyObjectResponse struct {
+	XMLName xml.Name
+}
+
+type ContentDirectoryExportResourceRequest struct {
+	XMLName        xml.Name
+	SourceURI      string
+	DestinationURI string
+}
+
+type ContentDirectoryExportResourceResponse struct {
+	XMLName    xml.Name
+	TransferID uint32
+}
+
+type ContentDirectoryGetSearchCapabilitiesRequest struct {
+	XMLName xml.Name
+}
+
+type ContentDirectoryGetSearchCapabilitiesResponse struct {
+	XMLName    xml.Name
+	SearchCaps string
+}
+
+type ContentDirectoryGetSortCapabilitiesRequest struct {
+	XMLName xml.Name
+}
+
+type ContentDirectoryGetSortCapabilitiesResponse struct {
+	XMLName  xml.Name
+	SortCaps string
+}
+
+type ContentDirectoryGetServiceResetTokenRequest struct {
+	XMLName xml.Name
+}
+
+type ContentDirectoryGetServiceResetTokenResponse struct {
+	XMLName    xml.Name
+	ResetToken string
+}
+
+type ContentDirectoryGetSystemUpdateIDRequest struct {
+	XMLName xml.Name
+}
+
+type ContentDirectoryGetSystemUpdateIDResponse struct {
+	XMLName xml.Name
+	Id      uint32
+}
+
+type ContentDirectoryGetTransferProgressRequest struct {
+	XMLName    xml.Name
+	TransferID uint32
+}
+
+type ContentDirectoryGetTransferProgressResponse struct {
+	XMLName        xml.Name
+	TransferStatus string
+	TransferLength string
+	TransferTotal  string
+}
+
+type ContentDirectoryImportResourceRequest struct {
+	XMLName        xml.Name
+	SourceURI      string
+	DestinationURI string
+}
+
+type ContentDirectoryImportResourceResponse struct {
+	XMLName    xml.Name
+	TransferID uint32
+}
+
+type ContentDirectorySearchRequest struct {
+	XMLName        xml.Name
+	ContainerID    string
+	SearchCriteria string
+	Filter         string
+	StartingIndex  uint32
+	RequestedCount uint32
+	SortCriteria   string
+}
+
+type ContentDirectorySearchResponse struct {
+	XMLName        xml.Name
+	Result         string
+	NumberReturned uint32
+	TotalMatches   uint32
+	UpdateID       uint32
+}
+
+type ContentDirectoryStopTransferResourceRequest struct {
+	XMLName    xml.Name
+	TransferID uint32
+}
+
+type ContentDirectoryStopTransferResourceResponse struct {
+	XMLName xml.Name
+}
+
+type ContentDirectoryUpdateObjectRequest struct {
+	XMLName         xml.Name
+	ObjectID        string
+	CurrentTagValue string
+	NewTagValue     string
+}
+
+type ContentDirectoryUpdateObjectResponse struct {
+	XMLName xml.Name
+}
+
+type ContentDirectoryGetFeatureListRequest struct {
+	XMLName xml.Name
+}
+
+type ContentDirectoryGetFeatureListResponse struct {
+	XMLName     xml.Name
+	FeatureList string
+}
+
+type ContentDirectoryX_GetFeatureListRequest struct {
+	XMLName xml.Name
+}
+
+type ContentDirectoryX_GetFeatureListResponse struct {
+	XMLName     xml.Name
+	FeatureList string
+}
+
+type ContentDirectoryX_SetBookmarkRequest struct {
+	XMLName      xml.Name
+	CategoryType uint32
+	RID          uint32
+	ObjectID     string
+	PosSecond    uint32
+}
+
+type ContentDirectoryX_SetBookmarkResponse struct {
+	XMLName xml.Name
+}
+
+// NewContentDirectoryService returns a Service dispatching urn:schemas-upnp-org:service:ContentDirectory:3 actions to impl.
+func NewContentDirectoryService(impl ContentDirectory) *Service {
+	// forRequest lets impl answer each version of the service the way it
+	// was specified, and stop working on requests the client gave up on, if
+	// it tells them apart.
+	forRequest := func(ctx context.Context, serviceType string) ContentDirectory {
+		if v, ok := impl.(interface {
+			ForRequest(ctx context.Context, version int) ContentDirectory
+		}); ok {
+			_, version, _ := splitVersion(serviceType)
+			return v.ForRequest(ctx, version)
+		}
+		return impl
+	}
+	return &Service{
+		Type: ContentDirectoryServiceType,
+		versions: map[string]int{
+			"GetServiceResetToken": 3,
+			"GetFeatureList":       2,
+		},
+		actions: map[string]actionFunc{
+			"Browse": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectoryBrowseRequest
+				var res ContentDirectoryBrowseResponse
+				var err error
+				if req.ObjectID, err = args.String("ObjectID", nil); err != nil {
+					return nil, err
+				}
+				if req.BrowseFlag, err = args.String("BrowseFlag", contentDirectoryAllowedBrowseFlag); err != nil {
+					return nil, err
+				}
+				if req.Filter, err = args.String("Filter", nil); err != nil {
+					return nil, err
+				}
+				if req.StartingIndex, err = args.UI4("StartingIndex"); err != nil {
+					return nil, err
+				}
+				if req.RequestedCount, err = args.UI4("RequestedCount"); err != nil {
+					return nil, err
+				}
+				if req.SortCriteria, err = args.String("SortCriteria", nil); err != nil {
+					return nil, err
+				}
+				res.Result, res.NumberReturned, res.TotalMatches, res.UpdateID, err = forRequest(ctx, serviceType).Browse(req.ObjectID, req.BrowseFlag, req.Filter, req.StartingIndex, req.RequestedCount, req.SortCriteria)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "BrowseResponse"}
+				return &res, nil
+			},
+			"CreateObject": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectoryCreateObjectRequest
+				var res ContentDirectoryCreateObjectResponse
+				var err error
+				if req.ContainerID, err = args.String("ContainerID", nil); err != nil {
+					return nil, err
+				}
+				if req.Elements, err = args.String("Elements", nil); err != nil {
+					return nil, err
+				}
+				res.ObjectID, res.Result, err = forRequest(ctx, serviceType).CreateObject(req.ContainerID, req.Elements)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "CreateObjectResponse"}
+				return &res, nil
+			},
+			"CreateReference": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectoryCreateReferenceRequest
+				var res ContentDirectoryCreateReferenceResponse
+				var err error
+				if req.ContainerID, err = args.String("ContainerID", nil); err != nil {
+					return nil, err
+				}
+				if req.ObjectID, err = args.String("ObjectID", nil); err != nil {
+					return nil, err
+				}
+				res.NewID, err = forRequest(ctx, serviceType).CreateReference(req.ContainerID, req.ObjectID)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "CreateReferenceResponse"}
+				return &res, nil
+			},
+			"DeleteResource": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectoryDeleteResourceRequest
+				var res ContentDirectoryDeleteResourceResponse
+				var err error
+				if req.ResourceURI, err = args.String("ResourceURI", nil); err != nil {
+					return nil, err
+				}
+				err = forRequest(ctx, serviceType).DeleteResource(req.ResourceURI)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "DeleteResourceResponse"}
+				return &res, nil
+			},
+			"DestroyObject": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectoryDestroyObjectRequest
+				var res ContentDirectoryDestroyObjectResponse
+				var err error
+				if req.ObjectID, err = args.String("ObjectID", nil); err != nil {
+					return nil, err
+				}
+				err = forRequest(ctx, serviceType).DestroyObject(req.ObjectID)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "DestroyObjectResponse"}
+				return &res, nil
+			},
+			"ExportResource": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectoryExportResourceRequest
+				var res ContentDirectoryExportResourceResponse
+				var err error
+				if req.SourceURI, err = args.String("SourceURI", nil); err != nil {
+					return nil, err
+				}
+				if req.DestinationURI, err = args.String("DestinationURI", nil); err != nil {
+					return nil, err
+				}
+				res.TransferID, err = forRequest(ctx, serviceType).ExportResource(req.SourceURI, req.DestinationURI)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "ExportResourceResponse"}
+				return &res, nil
+			},
+			"GetSearchCapabilities": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var res ContentDirectoryGetSearchCapabilitiesResponse
+				var err error
+				res.SearchCaps, err = forRequest(ctx, serviceType).GetSearchCapabilities()
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetSearchCapabilitiesResponse"}
+				return &res, nil
+			},
+			"GetSortCapabilities": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var res ContentDirectoryGetSortCapabilitiesResponse
+				var err error
+				res.SortCaps, err = forRequest(ctx, serviceType).GetSortCapabilities()
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetSortCapabilitiesResponse"}
+				return &res, nil
+			},
+			"GetServiceResetToken": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var res ContentDirectoryGetServiceResetTokenResponse
+				var err error
+				res.ResetToken, err = forRequest(ctx, serviceType).GetServiceResetToken()
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetServiceResetTokenResponse"}
+				return &res, nil
+			},
+			"GetSystemUpdateID": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var res ContentDirectoryGetSystemUpdateIDResponse
+				var err error
+				res.Id, err = forRequest(ctx, serviceType).GetSystemUpdateID()
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetSystemUpdateIDResponse"}
+				return &res, nil
+			},
+			"GetTransferProgress": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectoryGetTransferProgressRequest
+				var res ContentDirectoryGetTransferProgressResponse
+				var err error
+				if req.TransferID, err = args.UI4("TransferID"); err != nil {
+					return nil, err
+				}
+				res.TransferStatus, res.TransferLength, res.TransferTotal, err = forRequest(ctx, serviceType).GetTransferProgress(req.TransferID)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetTransferProgressResponse"}
+				return &res, nil
+			},
+			"ImportResource": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectoryImportResourceRequest
+				var res ContentDirectoryImportResourceResponse
+				var err error
+				if req.SourceURI, err = args.String("SourceURI", nil); err != nil {
+					return nil, err
+				}
+				if req.DestinationURI, err = args.String("DestinationURI", nil); err != nil {
+					return nil, err
+				}
+				res.TransferID, err = forRequest(ctx, serviceType).ImportResource(req.SourceURI, req.DestinationURI)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "ImportResourceResponse"}
+				return &res, nil
+			},
+			"Search": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectorySearchRequest
+				var res ContentDirectorySearchResponse
+				var err error
+				if req.ContainerID, err = args.String("ContainerID", nil); err != nil {
+					return nil, err
+				}
+				if req.SearchCriteria, err = args.String("SearchCriteria", nil); err != nil {
+					return nil, err
+				}
+				if req.Filter, err = args.String("Filter", nil); err != nil {
+					return nil, err
+				}
+				if req.StartingIndex, err = args.UI4("StartingIndex"); err != nil {
+					return nil, err
+				}
+				if req.RequestedCount, err = args.UI4("RequestedCount"); err != nil {
+					return nil, err
+				}
+				if req.SortCriteria, err = args.String("SortCriteria", nil); err != nil {
+					return nil, err
+				}
+				res.Result, res.NumberReturned, res.TotalMatches, res.UpdateID, err = forRequest(ctx, serviceType).Search(req.ContainerID, req.SearchCriteria, req.Filter, req.StartingIndex, req.RequestedCount, req.SortCriteria)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "SearchResponse"}
+				return &res, nil
+			},
+			"StopTransferResource": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectoryStopTransferResourceRequest
+				var res ContentDirectoryStopTransferResourceResponse
+				var err error
+				if req.TransferID, err = args.UI4("TransferID"); err != nil {
+					return nil, err
+				}
+				err = forRequest(ctx, serviceType).StopTransferResource(req.TransferID)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "StopTransferResourceResponse"}
+				return &res, nil
+			},
+			"UpdateObject": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectoryUpdateObjectRequest
+				var res ContentDirectoryUpdateObjectResponse
+				var err error
+				if req.ObjectID, err = args.String("ObjectID", nil); err != nil {
+					return nil, err
+				}
+				if req.CurrentTagValue, err = args.String("CurrentTagValue", nil); err != nil {
+					return nil, err
+				}
+				if req.NewTagValue, err = args.String("NewTagValue", nil); err != nil {
+					return nil, err
+				}
+				err = forRequest(ctx, serviceType).UpdateObject(req.ObjectID, req.CurrentTagValue, req.NewTagValue)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "UpdateObjectResponse"}
+				return &res, nil
+			},
+			"GetFeatureList": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var res ContentDirectoryGetFeatureListResponse
+				var err error
+				res.FeatureList, err = forRequest(ctx, serviceType).GetFeatureList()
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetFeatureListResponse"}
+				return &res, nil
+			},
+			"X_GetFeatureList": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var res ContentDirectoryX_GetFeatureListResponse
+				var err error
+				res.FeatureList, err = forRequest(ctx, serviceType).X_GetFeatureList()
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "X_GetFeatureListResponse"}
+				return &res, nil
+			},
+			"X_SetBookmark": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ContentDirectoryX_SetBookmarkRequest
+				var res ContentDirectoryX_SetBookmarkResponse
+				var err error
+				if req.CategoryType, err = args.UI4("CategoryType"); err != nil {
+					return nil, err
+				}
+				if req.RID, err = args.UI4("RID"); err != nil {
+					return nil, err
+				}
+				if req.ObjectID, err = args.String("ObjectID", nil); err != nil {
+					return nil, err
+				}
+				if req.PosSecond, err = args.UI4("PosSecond"); err != nil {
+					return nil, err
+				}
+				err = forRequest(ctx, serviceType).X_SetBookmark(req.CategoryType, req.RID, req.ObjectID, req.PosSecond)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "X_SetBookmarkResponse"}
+				return &res, nil
+			},
+		},
+	}
+}
@@ -0,0 +1,139 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	soapEnvelopeNamespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	soapEncodingStyle     = "http://schemas.xmlsoap.org/soap/encoding/"
+
+	// maxRequestSize bounds the SOAP request body. The longest arguments we
+	// take, such as SearchCriteria, are far shorter.
+	maxRequestSize = 64 * 1024
+	// maxDepth allows Envelope, Body (or Header), the action and its
+	// arguments, which carry character data only.
+	maxDepth = 4
+	// maxAttributes and maxAttributesSize bound the attributes of a single
+	// element, namespace declarations included.
+	maxAttributes     = 16
+	maxAttributesSize = 4 * 1024
+	maxArguments      = 32
+	maxArgumentSize   = 16 * 1024
+)
+
+var errRequestTooLarge = errors.New("request too large")
+
+// readRequest reads the request body, failing if it exceeds maxRequestSize.
+func readRequest(body io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxRequestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxRequestSize {
+		return nil, errRequestTooLarge
+	}
+	return data, nil
+}
+
+// checkStructure scans the tokens of a SOAP request before it is unmarshalled
+// and rejects nesting, attributes and directives no UPnP control point sends.
+// The request must be an Envelope holding a Body with a single action element.
+func checkStructure(data []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	inBody := false
+	bodies, actions := 0, 0
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return invalidArgs("malformed SOAP envelope: %s", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth > maxDepth {
+				return invalidArgs("SOAP envelope nested too deep")
+			}
+			if len(t.Attr) > maxAttributes {
+				return invalidArgs("too many attributes on %s", t.Name.Local)
+			}
+			size := 0
+			for _, attr := range t.Attr {
+				size += len(attr.Name.Space) + len(attr.Name.Local) + len(attr.Value)
+			}
+			if size > maxAttributesSize {
+				return invalidArgs("attributes of %s too large", t.Name.Local)
+			}
+			switch depth {
+			case 1:
+				if t.Name.Space != soapEnvelopeNamespace || t.Name.Local != "Envelope" {
+					return invalidArgs("not a SOAP envelope")
+				}
+			case 2:
+				if t.Name.Space != soapEnvelopeNamespace || (t.Name.Local != "Header" && t.Name.Local != "Body") {
+					return invalidArgs("unexpected %s in SOAP envelope", t.Name.Local)
+				}
+				inBody = t.Name.Local == "Body"
+				if inBody {
+					bodies++
+				}
+			case 3:
+				if inBody {
+					actions++
+				}
+			}
+		case xml.EndElement:
+			depth--
+		case xml.Directive:
+			return invalidArgs("DTD not allowed in SOAP envelope")
+		}
+	}
+	if bodies != 1 || actions != 1 {
+		return invalidArgs("SOAP Body must hold a single action")
+	}
+	return nil
+}
+
+// decodeRequest parses a SOAP request and checks that it is a UPnP action
+// invocation.
+func decodeRequest(data []byte) (*Request, error) {
+	if err := checkStructure(data); err != nil {
+		return nil, err
+	}
+	var soapReq Request
+	if err := xml.Unmarshal(data, &soapReq); err != nil {
+		return nil, invalidArgs("malformed SOAP envelope: %s", err)
+	}
+	if soapReq.EncodingStyle != "" && soapReq.EncodingStyle != soapEncodingStyle {
+		return nil, invalidArgs("unsupported encodingStyle %q", soapReq.EncodingStyle)
+	}
+	if len(soapReq.Body.Action.Arguments) > maxArguments {
+		return nil, invalidArgs("too many arguments")
+	}
+	return &soapReq, nil
+}
+
+// args returns the arguments of an action invocation, rejecting duplicated
+// and oversized arguments.
+func (r *Request) args() (Args, error) {
+	args := make(Args, len(r.Body.Action.Arguments))
+	for _, arg := range r.Body.Action.Arguments {
+		name := arg.XMLName.Local
+		if _, ok := args[name]; ok {
+			return nil, invalidArgs("duplicate argument %s", name)
+		}
+		if len(arg.Value) > maxArgumentSize {
+			return nil, invalidArgs("argument %s too long", name)
+		}
+		args[name] = arg.Value
+	}
+	return args, nil
+}
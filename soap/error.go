@@ -0,0 +1,51 @@
+package soap
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// UPnPError is an error returned to the control point as a SOAP fault.
+type UPnPError struct {
+	Code        int
+	Description string
+}
+
+func (e *UPnPError) Error() string {
+	return fmt.Sprintf("UPnP error %d: %s", e.Code, e.Description)
+}
+
+var (
+	ErrInvalidAction                = &UPnPError{401, "Invalid Action"}
+	ErrInvalidArgs                  = &UPnPError{402, "Invalid Args"}
+	ErrActionFailed                 = &UPnPError{501, "Action Failed"}
+	ErrOptionalActionNotImplemented = &UPnPError{602, "Optional Action Not Implemented"}
+)
+
+func invalidArgs(format string, a ...interface{}) error {
+	return &UPnPError{ErrInvalidArgs.Code, fmt.Sprintf(format, a...)}
+}
+
+// Fault is a SOAP fault carrying a UPnP error, sent in an Envelope.
+type Fault struct {
+	XMLName     xml.Name `xml:"s:Fault"`
+	FaultCode   string   `xml:"faultcode"`
+	FaultString string   `xml:"faultstring"`
+	Detail      struct {
+		UPnPError struct {
+			XMLName          xml.Name `xml:"urn:schemas-upnp-org:control-1-0 UPnPError"`
+			ErrorCode        int      `xml:"errorCode"`
+			ErrorDescription string   `xml:"errorDescription"`
+		}
+	} `xml:"detail"`
+}
+
+func newFault(e *UPnPError) *Fault {
+	f := &Fault{
+		FaultCode:   "s:Client",
+		FaultString: "UPnPError",
+	}
+	f.Detail.UPnPError.ErrorCode = e.Code
+	f.Detail.UPnPError.ErrorDescription = e.Description
+	return f
+}
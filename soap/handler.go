@@ -1,36 +1,128 @@
+//go:generate go run ./scpdgen -service ContentDirectory -type urn:schemas-upnp-org:service:ContentDirectory:3 -o contentdirectory.gen.go ../file/ContentDirectory1.xml ../file/ContentDirectory2.xml ../file/ContentDirectory3.xml
+//go:generate go run ./scpdgen -service ConnectionManager -type urn:schemas-upnp-org:service:ConnectionManager:1 -o connectionmanager.gen.go ../file/ConnectionManager1.xml
+//go:generate go run ./scpdgen -service MediaReceiverRegistrar -type urn:microsoft.com:service:X_MS_MediaReceiverRegistrar:1 -o mediareceiverregistrar.gen.go ../file/X_MS_MediaReceiverRegistrar1.xml
+//go:generate go run ./scpdgen -service ScheduledRecording -type urn:schemas-upnp-org:service:ScheduledRecording:1 -o scheduledrecording.gen.go ../file/ScheduledRecording1.xml
 package soap
 
 import (
+	"context"
 	"encoding/xml"
-	"io/ioutil"
+	"errors"
+	"log"
 	"net/http"
-	"reflect"
-	"regexp"
+	"strconv"
+	"strings"
 )
 
-const actionNameRegexp = `"urn:schemas-upnp-org:service:ContentDirectory:1#(.+)"`
+// actionFunc validates the arguments of one action, invokes it and returns the
+// response struct to be marshalled into the SOAP body.
+type actionFunc func(ctx context.Context, serviceType string, args Args) (interface{}, error)
 
-func HandleAction(r *http.Request) []byte {
-	actionName := regexp.MustCompile(actionNameRegexp).FindStringSubmatch(r.Header.Get("SoapAction"))[1]
+// A Service dispatches SOAP action invocations for one UPnP service type.
+// Services are created by the generated New<Service>Service constructors.
+type Service struct {
+	Type string
+	// versions are the versions of the service which introduced the
+	// actions which were not in version 1, generated from the SCPD of each
+	// version. Vendor actions such as X_GetFeatureList are in every version.
+	versions map[string]int
+	actions  map[string]actionFunc
+}
+
+// parseSoapAction splits a SOAPACTION header value such as
+// "urn:schemas-upnp-org:service:ContentDirectory:1#Browse".
+func parseSoapAction(header string) (serviceType string, actionName string, ok bool) {
+	header = strings.Trim(header, `"`)
+	i := strings.LastIndex(header, "#")
+	if i < 0 {
+		return "", "", false
+	}
+	return header[:i], header[i+1:], true
+}
 
-	data, _ := ioutil.ReadAll(r.Body)
-	var soapReq Request
-	xml.Unmarshal(data, &soapReq)
-	reqStruct := reflect.ValueOf(soapReq.Body).FieldByName(actionName).Elem()
-	argv := make([]reflect.Value, reqStruct.NumField()-1)
-	for i := range argv {
-		argv[i] = reqStruct.Field(i + 1) // skip XMLName field
+// splitVersion splits a type URN such as
+// "urn:schemas-upnp-org:service:ContentDirectory:3" into its name and version.
+func splitVersion(urn string) (name string, version int, ok bool) {
+	i := strings.LastIndex(urn, ":")
+	if i < 0 {
+		return "", 0, false
+	}
+	version, err := strconv.Atoi(urn[i+1:])
+	if err != nil || version < 1 {
+		return "", 0, false
 	}
-	result := reflect.ValueOf(&Action{}).MethodByName(actionName).Call(argv)
+	return urn[:i], version, true
+}
 
-	var soapRes Response
-	soapRes.EncodingStyle = "http://schemas.xmlsoap.org/soap/encoding/"
-	resStructFieldPtr := reflect.ValueOf(soapRes.Body).FieldByName(actionName + "Response")
-	resStructPtr := reflect.New(resStructFieldPtr.Type().Elem())
-	for i, v := range result {
-		resStructPtr.Elem().Field(i + 1).Set(v) // skip XMLName field
+// supports reports whether actionName is an action of serviceType, which must
+// be s.Type or an earlier version of it. Later versions of a service are
+// backward compatible, so clients invoking an earlier version are answered in
+// the namespace they used, with the actions of that version.
+func (s *Service) supports(serviceType string, actionName string) bool {
+	name, version, ok := splitVersion(serviceType)
+	if !ok {
+		return false
+	}
+	ownName, ownVersion, _ := splitVersion(s.Type)
+	if name != ownName || version > ownVersion {
+		return false
+	}
+	return version >= s.versions[actionName]
+}
+
+func (s *Service) invoke(r *http.Request) (interface{}, error) {
+	serviceType, actionName, ok := parseSoapAction(r.Header.Get("SoapAction"))
+	if !ok || !s.supports(serviceType, actionName) {
+		return nil, ErrInvalidAction
+	}
+	action, ok := s.actions[actionName]
+	if !ok {
+		return nil, ErrInvalidAction
+	}
+
+	data, err := readRequest(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	soapReq, err := decodeRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	if soapReq.Body.Action.XMLName.Space != serviceType || soapReq.Body.Action.XMLName.Local != actionName {
+		return nil, ErrInvalidAction
+	}
+	args, err := soapReq.args()
+	if err != nil {
+		return nil, err
+	}
+	res, err := action(r.Context(), serviceType, args)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// HandleAction invokes the action requested by r and returns the HTTP status
+// code and the SOAP envelope to reply with.
+func (s *Service) HandleAction(r *http.Request) (int, []byte) {
+	status := http.StatusOK
+	res, err := s.invoke(r)
+	if err != nil {
+		status = http.StatusInternalServerError
+		if errors.Is(err, errRequestTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+			err = invalidArgs("request larger than %d bytes", maxRequestSize)
+		}
+		var upnpErr *UPnPError
+		if !errors.As(err, &upnpErr) {
+			log.Printf("%s: action failed: %s", s.Type, err)
+			upnpErr = ErrActionFailed
+		}
+		res = newFault(upnpErr)
+	}
+	data, err := xml.Marshal(newEnvelope(res))
+	if err != nil {
+		log.Fatal(err)
 	}
-	reflect.ValueOf(&soapRes.Body).Elem().FieldByName(actionName + "Response").Set(resStructPtr)
-	res, _ := xml.Marshal(soapRes)
-	return res
+	return status, data
 }
@@ -0,0 +1,71 @@
+package soap
+
+import (
+	"errors"
+	"go-upnp-playground/service/contentdirectory"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func invokeAction(service *Service, serviceType string, action string) (int, string) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + serviceType + `"></u:` + action + `></s:Body></s:Envelope>`
+	r := httptest.NewRequest("POST", "/ContentDirectory/control.xml", strings.NewReader(body))
+	r.Header.Set("SOAPACTION", `"`+serviceType+"#"+action+`"`)
+	status, data := service.HandleAction(r)
+	return status, string(data)
+}
+
+func TestActionVersions(t *testing.T) {
+	service := NewContentDirectoryService(Action{})
+	for _, test := range []struct {
+		version string
+		action  string
+		ok      bool
+	}{
+		{"1", "GetSystemUpdateID", true},
+		{"1", "GetFeatureList", false},
+		{"2", "GetFeatureList", true},
+		{"2", "GetServiceResetToken", false},
+		{"3", "GetServiceResetToken", true},
+		{"1", "X_GetFeatureList", true},
+		{"4", "GetSystemUpdateID", false},
+	} {
+		status, data := invokeAction(service, "urn:schemas-upnp-org:service:ContentDirectory:"+test.version, test.action)
+		if ok := status == 200; ok != test.ok {
+			t.Errorf("%s of version %s: got %d %s", test.action, test.version, status, data)
+		}
+		if !test.ok && !strings.Contains(data, "<errorCode>401</errorCode>") {
+			t.Errorf("%s of version %s: want error 401, got %s", test.action, test.version, data)
+		}
+	}
+}
+
+func TestCapabilitiesOfVersion(t *testing.T) {
+	service := NewContentDirectoryService(Action{})
+	_, data := invokeAction(service, "urn:schemas-upnp-org:service:ContentDirectory:3", "GetSearchCapabilities")
+	if !strings.Contains(data, "upnp:objectUpdateID") {
+		t.Errorf("version 3 search capabilities lack upnp:objectUpdateID: %s", data)
+	}
+	_, data = invokeAction(service, "urn:schemas-upnp-org:service:ContentDirectory:1", "GetSearchCapabilities")
+	if strings.Contains(data, "upnp:objectUpdateID") {
+		t.Errorf("version 1 search capabilities have upnp:objectUpdateID: %s", data)
+	}
+}
+
+func TestCriteriaErrors(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		code int
+	}{
+		{&contentdirectory.SearchCriteriaError{Criteria: "dc:title", Reason: "unexpected end of criteria"}, 708},
+		{&contentdirectory.SortCriteriaError{Criteria: "dc:title", Reason: "missing + or -"}, 709},
+	} {
+		var upnpErr *UPnPError
+		if !errors.As(contentDirectoryError(test.err), &upnpErr) || upnpErr.Code != test.code {
+			t.Errorf("%v: want error %d, got %v", test.err, test.code, contentDirectoryError(test.err))
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Code generated by scpdgen from ../file/X_MS_MediaReceiverRegistrar1.xml. DO NOT EDIT.
+
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+)
+
+const MediaReceiverRegistrarServiceType = "urn:microsoft.com:service:X_MS_MediaReceiverRegistrar:1"
+
+// MediaReceiverRegistrar is implemented by the urn:microsoft.com:service:X_MS_MediaReceiverRegistrar:1 service.
+type MediaReceiverRegistrar interface {
+	IsAuthorized(DeviceID string) (int32, error)
+	RegisterDevice(RegistrationReqMsg string) (string, error)
+	IsValidated(DeviceID string) (int32, error)
+}
+
+// UnimplementedMediaReceiverRegistrar answers every action with error 602. Embed it in an
+// implementation to provide only the actions it supports.
+type UnimplementedMediaReceiverRegistrar struct{}
+
+func (UnimplementedMediaReceiverRegistrar) IsAuthorized(string) (int32, error) {
+	return 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedMediaReceiverRegistrar) RegisterDevice(string) (string, error) {
+	return "", ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedMediaReceiverRegistrar) IsValidated(string) (int32, error) {
+	return 0, ErrOptionalActionNotImplemented
+}
+
+type MediaReceiverRegistrarIsAuthorizedRequest struct {
+	XMLName  xml.Name
+	DeviceID string
+}
+
+type MediaReceiverRegistrarIsAuthorizedResponse struct {
+	XMLName xml.Name
+	Result  int32
+}
+
+type MediaReceiverRegistrarRegisterDeviceRequest struct {
+	XMLName            xml.Name
+	RegistrationReqMsg string
+}
+
+type MediaReceiverRegistrarRegisterDeviceResponse struct {
+	XMLName             xml.Name
+	RegistrationRespMsg string
+}
+
+type MediaReceiverRegistrarIsValidatedRequest struct {
+	XMLName  xml.Name
+	DeviceID string
+}
+
+type MediaReceiverRegistrarIsValidatedResponse struct {
+	XMLName xml.Name
+	Result  int32
+}
+
+// NewMediaReceiverRegistrarService returns a Service dispatching urn:microsoft.com:service:X_MS_MediaReceiverRegistrar:1 actions to impl.
+func NewMediaReceiverRegistrarService(impl MediaReceiverRegistrar) *Service {
+	// forRequest lets impl answer each version of the service the way it
+	// was specified, and stop working on requests the client gave up on, if
+	// it tells them apart.
+	forRequest := func(ctx context.Context, serviceType string) MediaReceiverRegistrar {
+		if v, ok := impl.(interface {
+			ForRequest(ctx context.Context, version int) MediaReceiverRegistrar
+		}); ok {
+			_, version, _ := splitVersion(serviceType)
+			return v.ForRequest(ctx, version)
+		}
+		return impl
+	}
+	return &Service{
+		Type: MediaReceiverRegistrarServiceType,
+		actions: map[string]actionFunc{
+			"IsAuthorized": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req MediaReceiverRegistrarIsAuthorizedRequest
+				var res MediaReceiverRegistrarIsAuthorizedResponse
+				var err error
+				if req.DeviceID, err = args.String("DeviceID", nil); err != nil {
+					return nil, err
+				}
+				res.Result, err = forRequest(ctx, serviceType).IsAuthorized(req.DeviceID)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "IsAuthorizedResponse"}
+				return &res, nil
+			},
+			"RegisterDevice": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req MediaReceiverRegistrarRegisterDeviceRequest
+				var res MediaReceiverRegistrarRegisterDeviceResponse
+				var err error
+				if req.RegistrationReqMsg, err = args.String("RegistrationReqMsg", nil); err != nil {
+					return nil, err
+				}
+				res.RegistrationRespMsg, err = forRequest(ctx, serviceType).RegisterDevice(req.RegistrationReqMsg)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "RegisterDeviceResponse"}
+				return &res, nil
+			},
+			"IsValidated": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req MediaReceiverRegistrarIsValidatedRequest
+				var res MediaReceiverRegistrarIsValidatedResponse
+				var err error
+				if req.DeviceID, err = args.String("DeviceID", nil); err != nil {
+					return nil, err
+				}
+				res.Result, err = forRequest(ctx, serviceType).IsValidated(req.DeviceID)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "IsValidatedResponse"}
+				return &res, nil
+			},
+		},
+	}
+}
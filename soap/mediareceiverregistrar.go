@@ -0,0 +1,24 @@
+package soap
+
+// MediaReceiverRegistrarAction implements X_MS_MediaReceiverRegistrar, which
+// Xbox consoles and Windows Media Player require before they list a server.
+// Every device is authorized; the registration handshake is not needed for
+// plain playback.
+type MediaReceiverRegistrarAction struct {
+	UnimplementedMediaReceiverRegistrar
+}
+
+func (a MediaReceiverRegistrarAction) IsAuthorized(DeviceID string) (int32, error) {
+	// Result
+	return 1, nil
+}
+
+func (a MediaReceiverRegistrarAction) IsValidated(DeviceID string) (int32, error) {
+	// Result
+	return 1, nil
+}
+
+func (a MediaReceiverRegistrarAction) RegisterDevice(RegistrationReqMsg string) (string, error) {
+	// RegistrationRespMsg
+	return "", nil
+}
@@ -0,0 +1,329 @@
+// Code generated by scpdgen from ../file/ScheduledRecording1.xml. DO NOT EDIT.
+
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+)
+
+const ScheduledRecordingServiceType = "urn:schemas-upnp-org:service:ScheduledRecording:1"
+
+// ScheduledRecording is implemented by the urn:schemas-upnp-org:service:ScheduledRecording:1 service.
+type ScheduledRecording interface {
+	GetSortCapabilities() (string, uint32, error)
+	GetStateUpdateID() (uint32, error)
+	BrowseRecordSchedules(Filter string, StartingIndex uint32, RequestedCount uint32, SortCriteria string) (string, uint32, uint32, uint32, error)
+	BrowseRecordTasks(RecordScheduleID string, Filter string, StartingIndex uint32, RequestedCount uint32, SortCriteria string) (string, uint32, uint32, uint32, error)
+	CreateRecordSchedule(Elements string) (string, string, uint32, error)
+	DeleteRecordSchedule(RecordScheduleID string) error
+	GetRecordSchedule(RecordScheduleID string, Filter string) (string, uint32, error)
+	EnableRecordSchedule(RecordScheduleID string) error
+	DisableRecordSchedule(RecordScheduleID string) error
+}
+
+// UnimplementedScheduledRecording answers every action with error 602. Embed it in an
+// implementation to provide only the actions it supports.
+type UnimplementedScheduledRecording struct{}
+
+func (UnimplementedScheduledRecording) GetSortCapabilities() (string, uint32, error) {
+	return "", 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedScheduledRecording) GetStateUpdateID() (uint32, error) {
+	return 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedScheduledRecording) BrowseRecordSchedules(string, uint32, uint32, string) (string, uint32, uint32, uint32, error) {
+	return "", 0, 0, 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedScheduledRecording) BrowseRecordTasks(string, string, uint32, uint32, string) (string, uint32, uint32, uint32, error) {
+	return "", 0, 0, 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedScheduledRecording) CreateRecordSchedule(string) (string, string, uint32, error) {
+	return "", "", 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedScheduledRecording) DeleteRecordSchedule(string) error {
+	return ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedScheduledRecording) GetRecordSchedule(string, string) (string, uint32, error) {
+	return "", 0, ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedScheduledRecording) EnableRecordSchedule(string) error {
+	return ErrOptionalActionNotImplemented
+}
+
+func (UnimplementedScheduledRecording) DisableRecordSchedule(string) error {
+	return ErrOptionalActionNotImplemented
+}
+
+type ScheduledRecordingGetSortCapabilitiesRequest struct {
+	XMLName xml.Name
+}
+
+type ScheduledRecordingGetSortCapabilitiesResponse struct {
+	XMLName      xml.Name
+	SortCaps     string
+	SortLevelCap uint32
+}
+
+type ScheduledRecordingGetStateUpdateIDRequest struct {
+	XMLName xml.Name
+}
+
+type ScheduledRecordingGetStateUpdateIDResponse struct {
+	XMLName xml.Name
+	Id      uint32
+}
+
+type ScheduledRecordingBrowseRecordSchedulesRequest struct {
+	XMLName        xml.Name
+	Filter         string
+	StartingIndex  uint32
+	RequestedCount uint32
+	SortCriteria   string
+}
+
+type ScheduledRecordingBrowseRecordSchedulesResponse struct {
+	XMLName        xml.Name
+	Result         string
+	NumberReturned uint32
+	TotalMatches   uint32
+	UpdateID       uint32
+}
+
+type ScheduledRecordingBrowseRecordTasksRequest struct {
+	XMLName          xml.Name
+	RecordScheduleID string
+	Filter           string
+	StartingIndex    uint32
+	RequestedCount   uint32
+	SortCriteria     string
+}
+
+type ScheduledRecordingBrowseRecordTasksResponse struct {
+	XMLName        xml.Name
+	Result         string
+	NumberReturned uint32
+	TotalMatches   uint32
+	UpdateID       uint32
+}
+
+type ScheduledRecordingCreateRecordScheduleRequest struct {
+	XMLName  xml.Name
+	Elements string
+}
+
+type ScheduledRecordingCreateRecordScheduleResponse struct {
+	XMLName          xml.Name
+	RecordScheduleID string
+	Result           string
+	UpdateID         uint32
+}
+
+type ScheduledRecordingDeleteRecordScheduleRequest struct {
+	XMLName          xml.Name
+	RecordScheduleID string
+}
+
+type ScheduledRecordingDeleteRecordScheduleResponse struct {
+	XMLName xml.Name
+}
+
+type ScheduledRecordingGetRecordScheduleRequest struct {
+	XMLName          xml.Name
+	RecordScheduleID string
+	Filter           string
+}
+
+type ScheduledRecordingGetRecordScheduleResponse struct {
+	XMLName  xml.Name
+	Result   string
+	UpdateID uint32
+}
+
+type ScheduledRecordingEnableRecordScheduleRequest struct {
+	XMLName          xml.Name
+	RecordScheduleID string
+}
+
+type ScheduledRecordingEnableRecordScheduleResponse struct {
+	XMLName xml.Name
+}
+
+type ScheduledRecordingDisableRecordScheduleRequest struct {
+	XMLName          xml.Name
+	RecordScheduleID string
+}
+
+type ScheduledRecordingDisableRecordScheduleResponse struct {
+	XMLName xml.Name
+}
+
+// NewScheduledRecordingService returns a Service dispatching urn:schemas-upnp-org:service:ScheduledRecording:1 actions to impl.
+func NewScheduledRecordingService(impl ScheduledRecording) *Service {
+	// forRequest lets impl answer each version of the service the way it
+	// was specified, and stop working on requests the client gave up on, if
+	// it tells them apart.
+	forRequest := func(ctx context.Context, serviceType string) ScheduledRecording {
+		if v, ok := impl.(interface {
+			ForRequest(ctx context.Context, version int) ScheduledRecording
+		}); ok {
+			_, version, _ := splitVersion(serviceType)
+			return v.ForRequest(ctx, version)
+		}
+		return impl
+	}
+	return &Service{
+		Type: ScheduledRecordingServiceType,
+		actions: map[string]actionFunc{
+			"GetSortCapabilities": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var res ScheduledRecordingGetSortCapabilitiesResponse
+				var err error
+				res.SortCaps, res.SortLevelCap, err = forRequest(ctx, serviceType).GetSortCapabilities()
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetSortCapabilitiesResponse"}
+				return &res, nil
+			},
+			"GetStateUpdateID": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var res ScheduledRecordingGetStateUpdateIDResponse
+				var err error
+				res.Id, err = forRequest(ctx, serviceType).GetStateUpdateID()
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetStateUpdateIDResponse"}
+				return &res, nil
+			},
+			"BrowseRecordSchedules": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ScheduledRecordingBrowseRecordSchedulesRequest
+				var res ScheduledRecordingBrowseRecordSchedulesResponse
+				var err error
+				if req.Filter, err = args.String("Filter", nil); err != nil {
+					return nil, err
+				}
+				if req.StartingIndex, err = args.UI4("StartingIndex"); err != nil {
+					return nil, err
+				}
+				if req.RequestedCount, err = args.UI4("RequestedCount"); err != nil {
+					return nil, err
+				}
+				if req.SortCriteria, err = args.String("SortCriteria", nil); err != nil {
+					return nil, err
+				}
+				res.Result, res.NumberReturned, res.TotalMatches, res.UpdateID, err = forRequest(ctx, serviceType).BrowseRecordSchedules(req.Filter, req.StartingIndex, req.RequestedCount, req.SortCriteria)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "BrowseRecordSchedulesResponse"}
+				return &res, nil
+			},
+			"BrowseRecordTasks": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ScheduledRecordingBrowseRecordTasksRequest
+				var res ScheduledRecordingBrowseRecordTasksResponse
+				var err error
+				if req.RecordScheduleID, err = args.String("RecordScheduleID", nil); err != nil {
+					return nil, err
+				}
+				if req.Filter, err = args.String("Filter", nil); err != nil {
+					return nil, err
+				}
+				if req.StartingIndex, err = args.UI4("StartingIndex"); err != nil {
+					return nil, err
+				}
+				if req.RequestedCount, err = args.UI4("RequestedCount"); err != nil {
+					return nil, err
+				}
+				if req.SortCriteria, err = args.String("SortCriteria", nil); err != nil {
+					return nil, err
+				}
+				res.Result, res.NumberReturned, res.TotalMatches, res.UpdateID, err = forRequest(ctx, serviceType).BrowseRecordTasks(req.RecordScheduleID, req.Filter, req.StartingIndex, req.RequestedCount, req.SortCriteria)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "BrowseRecordTasksResponse"}
+				return &res, nil
+			},
+			"CreateRecordSchedule": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ScheduledRecordingCreateRecordScheduleRequest
+				var res ScheduledRecordingCreateRecordScheduleResponse
+				var err error
+				if req.Elements, err = args.String("Elements", nil); err != nil {
+					return nil, err
+				}
+				res.RecordScheduleID, res.Result, res.UpdateID, err = forRequest(ctx, serviceType).CreateRecordSchedule(req.Elements)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "CreateRecordScheduleResponse"}
+				return &res, nil
+			},
+			"DeleteRecordSchedule": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ScheduledRecordingDeleteRecordScheduleRequest
+				var res ScheduledRecordingDeleteRecordScheduleResponse
+				var err error
+				if req.RecordScheduleID, err = args.String("RecordScheduleID", nil); err != nil {
+					return nil, err
+				}
+				err = forRequest(ctx, serviceType).DeleteRecordSchedule(req.RecordScheduleID)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "DeleteRecordScheduleResponse"}
+				return &res, nil
+			},
+			"GetRecordSchedule": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ScheduledRecordingGetRecordScheduleRequest
+				var res ScheduledRecordingGetRecordScheduleResponse
+				var err error
+				if req.RecordScheduleID, err = args.String("RecordScheduleID", nil); err != nil {
+					return nil, err
+				}
+				if req.Filter, err = args.String("Filter", nil); err != nil {
+					return nil, err
+				}
+				res.Result, res.UpdateID, err = forRequest(ctx, serviceType).GetRecordSchedule(req.RecordScheduleID, req.Filter)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "GetRecordScheduleResponse"}
+				return &res, nil
+			},
+			"EnableRecordSchedule": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ScheduledRecordingEnableRecordScheduleRequest
+				var res ScheduledRecordingEnableRecordScheduleResponse
+				var err error
+				if req.RecordScheduleID, err = args.String("RecordScheduleID", nil); err != nil {
+					return nil, err
+				}
+				err = forRequest(ctx, serviceType).EnableRecordSchedule(req.RecordScheduleID)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "EnableRecordScheduleResponse"}
+				return &res, nil
+			},
+			"DisableRecordSchedule": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+				var req ScheduledRecordingDisableRecordScheduleRequest
+				var res ScheduledRecordingDisableRecordScheduleResponse
+				var err error
+				if req.RecordScheduleID, err = args.String("RecordScheduleID", nil); err != nil {
+					return nil, err
+				}
+				err = forRequest(ctx, serviceType).DisableRecordSchedule(req.RecordScheduleID)
+				if err != nil {
+					return nil, err
+				}
+				res.XMLName = xml.Name{Space: serviceType, Local: "DisableRecordScheduleResponse"}
+				return &res, nil
+			},
+		},
+	}
+}
@@ -0,0 +1,96 @@
+package soap
+
+import (
+	"errors"
+	"go-upnp-playground/service/scheduledrecording"
+	"strings"
+)
+
+var (
+	ErrInvalidSyntax        = &UPnPError{704, "Invalid syntax"}
+	ErrInvalidValue         = &UPnPError{705, "Invalid value"}
+	ErrUnsupportedClass     = &UPnPError{706, "Unsupported class"}
+	ErrNoSuchRecordSchedule = &UPnPError{713, "No such record schedule"}
+)
+
+// scheduledRecordingError converts errors of the scheduledrecording package
+// to the matching UPnP error.
+func scheduledRecordingError(err error) error {
+	switch {
+	case errors.Is(err, scheduledrecording.ErrNoSuchRecordSchedule):
+		return ErrNoSuchRecordSchedule
+	case errors.Is(err, scheduledrecording.ErrInvalidSyntax):
+		return ErrInvalidSyntax
+	case errors.Is(err, scheduledrecording.ErrInvalidValue):
+		return ErrInvalidValue
+	case errors.Is(err, scheduledrecording.ErrUnsupportedClass):
+		return ErrUnsupportedClass
+	case errors.Is(err, scheduledrecording.ErrInvalidSortCriteria):
+		return ErrInvalidSortCriteria
+	}
+	return err
+}
+
+// ScheduledRecordingAction implements ScheduledRecording:1 on the reserves and
+// rules of EPGStation.
+type ScheduledRecordingAction struct {
+	UnimplementedScheduledRecording
+}
+
+func (a ScheduledRecordingAction) GetSortCapabilities() (string, uint32, error) {
+	// SortCaps, SortLevelCap
+	return strings.Join(scheduledrecording.SortCapabilities, ","), uint32(len(scheduledrecording.SortCapabilities)), nil
+}
+
+func (a ScheduledRecordingAction) GetStateUpdateID() (uint32, error) {
+	// The ID is kept current by scheduledrecording.RunPoll.
+	return uint32(scheduledrecording.GetStateUpdateID()), nil
+}
+
+func (a ScheduledRecordingAction) BrowseRecordSchedules(Filter string, StartingIndex uint32, RequestedCount uint32, SortCriteria string) (string, uint32, uint32, uint32, error) {
+	result, returned, total, updateID, err := scheduledrecording.BrowseRecordSchedules(Filter, SortCriteria, int(StartingIndex), int(RequestedCount))
+	if err != nil {
+		return "", 0, 0, 0, scheduledRecordingError(err)
+	}
+	// Result, NumberReturned, TotalMatches, UpdateID
+	return result, uint32(returned), uint32(total), uint32(updateID), nil
+}
+
+func (a ScheduledRecordingAction) BrowseRecordTasks(RecordScheduleID string, Filter string, StartingIndex uint32, RequestedCount uint32, SortCriteria string) (string, uint32, uint32, uint32, error) {
+	result, returned, total, updateID, err := scheduledrecording.BrowseRecordTasks(RecordScheduleID, Filter, SortCriteria, int(StartingIndex), int(RequestedCount))
+	if err != nil {
+		return "", 0, 0, 0, scheduledRecordingError(err)
+	}
+	// Result, NumberReturned, TotalMatches, UpdateID
+	return result, uint32(returned), uint32(total), uint32(updateID), nil
+}
+
+func (a ScheduledRecordingAction) CreateRecordSchedule(Elements string) (string, string, uint32, error) {
+	id, result, updateID, err := scheduledrecording.CreateRecordSchedule(Elements)
+	if err != nil {
+		return "", "", 0, scheduledRecordingError(err)
+	}
+	// RecordScheduleID, Result, UpdateID
+	return id, result, uint32(updateID), nil
+}
+
+func (a ScheduledRecordingAction) DeleteRecordSchedule(RecordScheduleID string) error {
+	return scheduledRecordingError(scheduledrecording.DeleteRecordSchedule(RecordScheduleID))
+}
+
+func (a ScheduledRecordingAction) GetRecordSchedule(RecordScheduleID string, Filter string) (string, uint32, error) {
+	result, updateID, err := scheduledrecording.GetRecordSchedule(RecordScheduleID, Filter)
+	if err != nil {
+		return "", 0, scheduledRecordingError(err)
+	}
+	// Result, UpdateID
+	return result, uint32(updateID), nil
+}
+
+func (a ScheduledRecordingAction) EnableRecordSchedule(RecordScheduleID string) error {
+	return scheduledRecordingError(scheduledrecording.EnableRecordSchedule(RecordScheduleID))
+}
+
+func (a ScheduledRecordingAction) DisableRecordSchedule(RecordScheduleID string) error {
+	return scheduledRecordingError(scheduledrecording.DisableRecordSchedule(RecordScheduleID))
+}
@@ -0,0 +1,312 @@
+// Command scpdgen reads the UPnP service descriptions (SCPD) of each version of
+// a service, from version 1 up to the version served, and generates typed
+// request/response structs, argument validation and a static dispatch table
+// for the soap package. Actions are those of the last SCPD; the earlier ones
+// tell which version introduced each action.
+//
+//	$ go run ./scpdgen -service ContentDirectory -type urn:schemas-upnp-org:service:ContentDirectory:3 \
+//	      -o contentdirectory.gen.go ../file/ContentDirectory1.xml ../file/ContentDirectory2.xml ../file/ContentDirectory3.xml
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+type scpd struct {
+	Actions []struct {
+		Name      string `xml:"name"`
+		Arguments []struct {
+			Name                 string `xml:"name"`
+			Direction            string `xml:"direction"`
+			RelatedStateVariable string `xml:"relatedStateVariable"`
+		} `xml:"argumentList>argument"`
+	} `xml:"actionList>action"`
+	StateVariables []struct {
+		Name          string   `xml:"name"`
+		DataType      string   `xml:"dataType"`
+		AllowedValues []string `xml:"allowedValueList>allowedValue"`
+	} `xml:"serviceStateTable>stateVariable"`
+}
+
+// upnpType describes how a UPnP data type is represented in Go.
+type upnpType struct {
+	GoType   string // type exposed to service implementations
+	WireType string // type of the request/response struct field
+	Parser   string // Args method which parses and validates the value
+}
+
+var upnpTypes = map[string]upnpType{
+	"ui1":     {"uint8", "uint8", "UI1"},
+	"ui2":     {"uint16", "uint16", "UI2"},
+	"ui4":     {"uint32", "uint32", "UI4"},
+	"i1":      {"int8", "int8", "I1"},
+	"i2":      {"int16", "int16", "I2"},
+	"i4":      {"int32", "int32", "I4"},
+	"int":     {"int32", "int32", "I4"},
+	"boolean": {"bool", "Boolean", "Boolean"},
+}
+
+var stringType = upnpType{"string", "string", "String"}
+
+type argument struct {
+	Name    string
+	Type    upnpType
+	Allowed string // name of the allowed value list variable, if any
+}
+
+type action struct {
+	Name string
+	In   []argument
+	Out  []argument
+}
+
+type allowedList struct {
+	Name   string
+	Values []string
+}
+
+// An actionVersion is an action which was not in version 1 of a service, and
+// the version which introduced it.
+type actionVersion struct {
+	Name    string
+	Version int
+}
+
+type service struct {
+	Name        string
+	Type        string
+	Source      string
+	Actions     []action
+	AllowedList []allowedList
+	Versions    []actionVersion
+}
+
+func lowerFirst(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+func parse(name, serviceType, path string) (*service, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var desc scpd
+	if err := xml.Unmarshal(data, &desc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	svc := &service{Name: name, Type: serviceType, Source: path}
+	types := make(map[string]upnpType)
+	allowed := make(map[string]string)
+	for _, v := range desc.StateVariables {
+		t, ok := upnpTypes[v.DataType]
+		if !ok {
+			t = stringType
+		}
+		types[v.Name] = t
+		if len(v.AllowedValues) > 0 {
+			listName := lowerFirst(name) + "Allowed" + strings.TrimPrefix(v.Name, "A_ARG_TYPE_")
+			allowed[v.Name] = listName
+			svc.AllowedList = append(svc.AllowedList, allowedList{listName, v.AllowedValues})
+		}
+	}
+	for _, a := range desc.Actions {
+		act := action{Name: a.Name}
+		for _, arg := range a.Arguments {
+			t, ok := types[arg.RelatedStateVariable]
+			if !ok {
+				return nil, fmt.Errorf("%s: action %s argument %s refers to unknown state variable %s", path, a.Name, arg.Name, arg.RelatedStateVariable)
+			}
+			switch arg.Direction {
+			case "in":
+				act.In = append(act.In, argument{arg.Name, t, allowed[arg.RelatedStateVariable]})
+			case "out":
+				act.Out = append(act.Out, argument{arg.Name, t, ""})
+			default:
+				return nil, fmt.Errorf("%s: action %s argument %s has invalid direction %q", path, a.Name, arg.Name, arg.Direction)
+			}
+		}
+		svc.Actions = append(svc.Actions, act)
+	}
+	return svc, nil
+}
+
+var funcs = template.FuncMap{
+	"lowerFirst": lowerFirst,
+}
+
+var tmpl = template.Must(template.New("").Funcs(funcs).Parse(`// Code generated by scpdgen from {{.Source}}. DO NOT EDIT.
+
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+)
+
+const {{.Name}}ServiceType = "{{.Type}}"
+{{range .AllowedList}}
+var {{.Name}} = []string{ {{- range $i, $v := .Values}}{{if $i}}, {{end}}"{{$v}}"{{end -}} }
+{{end}}
+// {{.Name}} is implemented by the {{.Type}} service.
+type {{.Name}} interface {
+{{- range .Actions}}
+	{{.Name}}({{range $i, $a := .In}}{{if $i}}, {{end}}{{.Name}} {{.Type.GoType}}{{end}}) ({{range .Out}}{{.Type.GoType}}, {{end}}error)
+{{- end}}
+}
+
+// Unimplemented{{.Name}} answers every action with error 602. Embed it in an
+// implementation to provide only the actions it supports.
+type Unimplemented{{.Name}} struct{}
+{{range .Actions}}
+func (Unimplemented{{$.Name}}) {{.Name}}({{range $i, $a := .In}}{{if $i}}, {{end}}{{.Type.GoType}}{{end}}) ({{range .Out}}{{.Type.GoType}}, {{end}}error) {
+	return {{range .Out}}{{if eq .Type.GoType "string"}}""{{else if eq .Type.GoType "bool"}}false{{else}}0{{end}}, {{end}}ErrOptionalActionNotImplemented
+}
+{{end}}
+{{- range .Actions}}
+type {{$.Name}}{{.Name}}Request struct {
+	XMLName xml.Name
+{{- range .In}}
+	{{.Name}} {{.Type.WireType}}
+{{- end}}
+}
+
+type {{$.Name}}{{.Name}}Response struct {
+	XMLName xml.Name
+{{- range .Out}}
+	{{.Name}} {{.Type.WireType}}
+{{- end}}
+}
+{{end}}
+// New{{.Name}}Service returns a Service dispatching {{.Type}} actions to impl.
+func New{{.Name}}Service(impl {{.Name}}) *Service {
+	// forRequest lets impl answer each version of the service the way it
+	// was specified, and stop working on requests the client gave up on, if
+	// it tells them apart.
+	forRequest := func(ctx context.Context, serviceType string) {{.Name}} {
+		if v, ok := impl.(interface {
+			ForRequest(ctx context.Context, version int) {{.Name}}
+		}); ok {
+			_, version, _ := splitVersion(serviceType)
+			return v.ForRequest(ctx, version)
+		}
+		return impl
+	}
+	return &Service{
+		Type: {{.Name}}ServiceType,
+{{- if .Versions}}
+		versions: map[string]int{
+{{- range .Versions}}
+			"{{.Name}}": {{.Version}},
+{{- end}}
+		},
+{{- end}}
+		actions: map[string]actionFunc{
+{{- range .Actions}}
+			"{{.Name}}": func(ctx context.Context, serviceType string, args Args) (interface{}, error) {
+{{- if .In}}
+				var req {{$.Name}}{{.Name}}Request
+{{- end}}
+				var res {{$.Name}}{{.Name}}Response
+				var err error
+{{- range .In}}
+				if req.{{.Name}}, err = args.{{.Type.Parser}}("{{.Name}}"{{if eq .Type.Parser "String"}}, {{if .Allowed}}{{.Allowed}}{{else}}nil{{end}}{{end}}); err != nil {
+					return nil, err
+				}
+{{- end}}
+{{- range .Out}}{{if ne .Type.GoType .Type.WireType}}
+				var {{lowerFirst .Name}} {{.Type.GoType}}
+{{- end}}{{end}}
+				{{range .Out}}{{if ne .Type.GoType .Type.WireType}}{{lowerFirst .Name}}{{else}}res.{{.Name}}{{end}}, {{end}}err = forRequest(ctx, serviceType).{{.Name}}({{range $i, $a := .In}}{{if $i}}, {{end}}{{if ne .Type.GoType .Type.WireType}}{{.Type.GoType}}(req.{{.Name}}){{else}}req.{{.Name}}{{end}}{{end}})
+				if err != nil {
+					return nil, err
+				}
+{{- range .Out}}
+{{- if ne .Type.GoType .Type.WireType}}
+				res.{{.Name}} = {{.Type.WireType}}({{lowerFirst .Name}})
+{{- end}}
+{{- end}}
+				res.XMLName = xml.Name{Space: serviceType, Local: "{{.Name}}Response"}
+				return &res, nil
+			},
+{{- end}}
+		},
+	}
+}
+`))
+
+// addVersions sets the version which introduced each action of svc, given
+// the services parsed from the SCPDs of every version, from version 1 on.
+// Actions stay in every later version.
+func addVersions(svc *service, versions []*service) error {
+	introduced := make(map[string]int)
+	for i, v := range versions {
+		names := make(map[string]bool)
+		for _, a := range v.Actions {
+			names[a.Name] = true
+			if _, ok := introduced[a.Name]; !ok {
+				introduced[a.Name] = i + 1
+			}
+		}
+		for name := range introduced {
+			if !names[name] {
+				return fmt.Errorf("%s: action %s of an earlier version is missing", v.Source, name)
+			}
+		}
+	}
+	for _, a := range svc.Actions {
+		if introduced[a.Name] > 1 {
+			svc.Versions = append(svc.Versions, actionVersion{a.Name, introduced[a.Name]})
+		}
+	}
+	return nil
+}
+
+func main() {
+	name := flag.String("service", "", "Go name of the service, e.g. ContentDirectory")
+	serviceType := flag.String("type", "", "service type URN, e.g. urn:schemas-upnp-org:service:ContentDirectory:1")
+	output := flag.String("o", "", "output file")
+	flag.Parse()
+	i := strings.LastIndex(*serviceType, ":")
+	version, err := strconv.Atoi((*serviceType)[i+1:])
+	if *name == "" || i < 0 || err != nil || *output == "" || flag.NArg() != version {
+		// One SCPD per version.
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var versions []*service
+	for _, path := range flag.Args() {
+		svc, err := parse(*name, *serviceType, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		versions = append(versions, svc)
+	}
+	svc := versions[len(versions)-1]
+	svc.Source = strings.Join(flag.Args(), ", ")
+	if err := addVersions(svc, versions); err != nil {
+		log.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, svc); err != nil {
+		log.Fatal(err)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("gofmt generated code: %s\n%s", err, buf.Bytes())
+	}
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
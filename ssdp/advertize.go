@@ -89,6 +89,10 @@ func (s *SSDPAdvertiser) NotifyAlive() {
 		s.notifyTarget(upnpMediaServer)
 		s.notifyTarget(upnpContentDirectory)
 		s.notifyTarget(upnpConnectionManager)
+		if ScheduledRecording {
+			s.notifyTarget(upnpScheduledRecording)
+		}
+		s.notifyTarget(msMediaReceiverRegistrar)
 		s.notifyTarget(upnpRootDevice)
 	}
 }
@@ -118,6 +122,10 @@ func (s *SSDPAdvertiser) NotifyByebye() {
 		s.notifyByebye(upnpMediaServer)
 		s.notifyByebye(upnpContentDirectory)
 		s.notifyByebye(upnpConnectionManager)
+		if ScheduledRecording {
+			s.notifyByebye(upnpScheduledRecording)
+		}
+		s.notifyByebye(msMediaReceiverRegistrar)
 		s.notifyByebye(upnpRootDevice)
 	}
 }
@@ -0,0 +1,80 @@
+package ssdp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const methodSearch = "M-SEARCH"
+
+// A SearchResponse is the answer of a device to an M-SEARCH.
+type SearchResponse struct {
+	ST       string
+	USN      string
+	Location string
+}
+
+// Search multicasts an M-SEARCH for target and collects the responses until
+// MX seconds have passed or ctx is done. Responses are deduplicated by USN.
+func Search(ctx context.Context, target string, mx int) ([]SearchResponse, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	destAddr, err := net.ResolveUDPAddr("udp4", ssdpUDP4Addr)
+	if err != nil {
+		return nil, err
+	}
+	// Written by hand since http.Request.Write title-cases the headers and
+	// the UPnP discovery protocol uses case-sensitive headers.
+	msg := fmt.Sprintf("%s * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: %d\r\nST: %s\r\nUSER-AGENT: %s\r\n\r\n",
+		methodSearch, ssdpUDP4Addr, mx, target, serverName)
+	// UDP is unreliable, so the search is sent twice.
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteTo([]byte(msg), destAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	deadline := time.Now().Add(time.Duration(mx)*time.Second + 500*time.Millisecond)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	var responses []SearchResponse
+	seen := make(map[string]bool)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return responses, nil
+			}
+			return responses, err
+		}
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil || res.StatusCode != http.StatusOK {
+			continue
+		}
+		r := SearchResponse{
+			ST:       res.Header.Get("ST"),
+			USN:      res.Header.Get("USN"),
+			Location: res.Header.Get("Location"),
+		}
+		if r.Location == "" || seen[r.USN] {
+			continue
+		}
+		seen[r.USN] = true
+		responses = append(responses, r)
+		if ctx.Err() != nil {
+			return responses, nil
+		}
+	}
+}
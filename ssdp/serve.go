@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,13 +14,21 @@ import (
 
 const (
 	// upnpRootDevice is a value for searchTarget that searches for all root devices.
-	upnpRootDevice        = "upnp:rootdevice"
-	upnpMediaServer       = "urn:schemas-upnp-org:device:MediaServer:1"
-	upnpContentDirectory  = "urn:schemas-upnp-org:service:ContentDirectory:1"
-	upnpConnectionManager = "urn:schemas-upnp-org:service:ConnectionManager:1"
-	vendor                = "Linux/i686 UPnP/1.0 go-upnp-playground/0.0.1"
+	upnpRootDevice         = "upnp:rootdevice"
+	upnpMediaServer        = "urn:schemas-upnp-org:device:MediaServer:3"
+	upnpContentDirectory   = "urn:schemas-upnp-org:service:ContentDirectory:3"
+	upnpConnectionManager  = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	upnpScheduledRecording = "urn:schemas-upnp-org:service:ScheduledRecording:1"
+	// msMediaReceiverRegistrar is required by Xbox and Windows Media Player.
+	msMediaReceiverRegistrar = "urn:microsoft.com:service:X_MS_MediaReceiverRegistrar:1"
+	vendor                   = "Linux/i686 UPnP/1.0 go-upnp-playground/0.0.1"
 )
 
+// ScheduledRecording tells whether the device has the ScheduledRecording
+// service, which is then searched for and advertised. It must be set before
+// serving.
+var ScheduledRecording = true
+
 func NewSSDPDiscoveryResponder(deviceUUID uuid.UUID, urlBase string) SSDPDiscoveryResponder {
 	return SSDPDiscoveryResponder{
 		Multicast:  true,
@@ -28,17 +37,31 @@ func NewSSDPDiscoveryResponder(deviceUUID uuid.UUID, urlBase string) SSDPDiscove
 	}
 }
 
+// matchesVersion reports whether target is the type urn or an earlier version
+// of it, e.g. MediaServer:1 for MediaServer:3.
+func matchesVersion(target string, urn string) bool {
+	i := strings.LastIndex(urn, ":")
+	if !strings.HasPrefix(target, urn[:i+1]) {
+		return false
+	}
+	version, err := strconv.Atoi(target[i+1:])
+	ownVersion, _ := strconv.Atoi(urn[i+1:])
+	return err == nil && version >= 1 && version <= ownVersion
+}
+
 func (s *SSDPDiscoveryResponder) stAndUSN(target string) (ST string, USN string, err error) {
 	deviceTarget := fmt.Sprintf("uuid:%s", s.deviceUUID)
-	switch target {
-	case deviceTarget:
+	switch {
+	case target == deviceTarget:
 		ST = deviceTarget
 		USN = ST
-	case upnpRootDevice:
-		fallthrough
-	case upnpContentDirectory:
-		fallthrough
-	case upnpConnectionManager:
+	case target == upnpRootDevice,
+		matchesVersion(target, upnpMediaServer),
+		matchesVersion(target, upnpContentDirectory),
+		matchesVersion(target, upnpConnectionManager),
+		ScheduledRecording && matchesVersion(target, upnpScheduledRecording),
+		target == msMediaReceiverRegistrar:
+		// Searches for an earlier version are answered with that version.
 		ST = target
 		USN = fmt.Sprintf("%s::%s", deviceTarget, ST)
 	default: